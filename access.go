@@ -0,0 +1,215 @@
+package twitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	accessEditorRefresh = time.Minute * 10
+)
+
+// Role - Access level for the admin interface, higher includes lower
+type Role int
+
+//
+const (
+	RoleNone Role = iota
+	RoleViewer
+	RoleMod
+	RoleEditor
+	RoleOwner
+)
+
+func (r Role) String() string {
+	switch r {
+	case RoleViewer:
+		return "viewer"
+	case RoleMod:
+		return "mod"
+	case RoleEditor:
+		return "editor"
+	case RoleOwner:
+		return "owner"
+	}
+
+	return "none"
+}
+
+// ParseRole - Role from name
+func ParseRole(s string) (Role, error) {
+	for r := RoleNone; r <= RoleOwner; r++ {
+		if strings.EqualFold(s, r.String()) {
+			return r, nil
+		}
+	}
+
+	return RoleNone, fmt.Errorf("Unknown role: %s", s)
+}
+
+// MarshalJSON - JSON Helper
+func (r Role) MarshalJSON() ([]byte, error) {
+	return json.Marshal(r.String())
+}
+
+// UnmarshalJSON - JSON Helper
+func (r *Role) UnmarshalJSON(b []byte) error {
+	s := ""
+	err := json.Unmarshal(b, &s)
+	if err != nil {
+		return err
+	}
+
+	*r, err = ParseRole(s)
+	return err
+}
+
+// AccessControl - Works out the role of a logged in viewer
+// Owner is the room or admin account, editors come from twitch, mods from chat and grants are explicit
+type AccessControl struct {
+	client   *Client
+	filename string
+
+	lock          sync.Mutex
+	grants        map[ID]Role
+	editors       map[ID]bool
+	editorsLoaded time.Time
+}
+
+// CreateAccessControl - Load grants from file, empty filename keeps them in memory only
+func CreateAccessControl(ah *Client, filename string) *AccessControl {
+	ac := AccessControl{
+		client:   ah,
+		filename: filename,
+		grants:   make(map[ID]Role),
+		editors:  make(map[ID]bool),
+	}
+
+	if filename == "" {
+		return &ac
+	}
+
+	fileData, err := ioutil.ReadFile(filename)
+	if err != nil {
+		if !os.IsNotExist(err) {
+			log.Printf("Unable to load role grants: %s", err)
+		}
+		return &ac
+	}
+
+	err = json.Unmarshal(fileData, &ac.grants)
+	if err != nil {
+		log.Printf("Unable to parse role grants %s\n%s", filename, err)
+	}
+
+	return &ac
+}
+
+func (ac *AccessControl) save() error {
+	if ac.filename == "" {
+		return nil
+	}
+
+	b, err := json.MarshalIndent(ac.grants, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return writeSecretFile(ac.filename, b)
+}
+
+// Grant - Explicitly give a viewer a role, RoleNone removes the grant
+func (ac *AccessControl) Grant(tid ID, r Role) error {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+
+	if r == RoleNone {
+		delete(ac.grants, tid)
+	} else {
+		ac.grants[tid] = r
+	}
+
+	return ac.save()
+}
+
+// Grants - Copy of explicit grants
+func (ac *AccessControl) Grants() map[ID]Role {
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+
+	gList := make(map[ID]Role, len(ac.grants))
+	for k, v := range ac.grants {
+		gList[k] = v
+	}
+	return gList
+}
+
+// isEditor - Cached editor list, refreshed every accessEditorRefresh
+func (ac *AccessControl) isEditor(tid ID) bool {
+	ac.lock.Lock()
+	stale := time.Since(ac.editorsLoaded) > accessEditorRefresh
+	if stale {
+		// Claim the refresh so concurrent requests use the old list
+		ac.editorsLoaded = time.Now()
+	}
+	ac.lock.Unlock()
+
+	if stale && ac.client.Channel != nil && ac.client.RoomID != "" {
+		uList, err := ac.client.Channel.GetEditors(ac.client.RoomID)
+		if err != nil {
+			log.Printf("Unable to refresh channel editors: %s", err)
+		} else {
+			editors := make(map[ID]bool, len(uList))
+			for _, u := range uList {
+				editors[u.ID] = true
+			}
+
+			ac.lock.Lock()
+			ac.editors = editors
+			ac.lock.Unlock()
+		}
+	}
+
+	ac.lock.Lock()
+	defer ac.lock.Unlock()
+	return ac.editors[tid]
+}
+
+// RoleFor - Highest role the viewer has from any source
+func (ac *AccessControl) RoleFor(vd ViewerData) Role {
+	tid := vd.TwitchID
+	if tid == "" {
+		return RoleNone
+	}
+
+	if tid == ac.client.RoomID || tid == ac.client.AdminID {
+		return RoleOwner
+	}
+
+	role := RoleViewer
+	if vd.Chatter != nil {
+		_, isModBadge := vd.Chatter.Badges["moderator"]
+		if vd.Chatter.Mod || isModBadge {
+			role = RoleMod
+		}
+	}
+
+	if ac.isEditor(tid) {
+		role = RoleEditor
+	}
+
+	ac.lock.Lock()
+	granted := ac.grants[tid]
+	ac.lock.Unlock()
+	if granted > role {
+		role = granted
+	}
+
+	return role
+}
@@ -0,0 +1,920 @@
+package twitch
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	adminAPIMaxBody      = 64 * 1024
+	adminAPIDefaultLimit = 100
+	adminAPIMaxLimit     = 1000
+)
+
+// adminHandler - args are the regex sub matches of the route
+type adminHandler func(ah *Client, w http.ResponseWriter, req *http.Request, args []string)
+
+// apiCallerKey - Request context key of the caller ViewerData
+type apiCallerKey struct{}
+
+type adminRoute struct {
+	method  string
+	pattern *regexp.Regexp
+	role    Role // Minimum role of the caller
+	handler adminHandler
+}
+
+var adminRoutes = []adminRoute{
+	{"GET", regexp.MustCompile("^viewers$"), RoleMod, (*Client).apiViewerList},
+	{"GET", regexp.MustCompile("^viewers/search$"), RoleMod, (*Client).apiViewerSearch},
+	{"GET", regexp.MustCompile("^viewers/cache$"), RoleMod, (*Client).apiViewerCache},
+	{"POST", regexp.MustCompile("^viewers/update$"), RoleEditor, (*Client).apiViewerUpdate},
+	{"GET", regexp.MustCompile("^viewers/([0-9]+)$"), RoleMod, (*Client).apiViewerDetail},
+	{"POST", regexp.MustCompile("^viewers/([0-9]+)/revoke$"), RoleOwner, (*Client).apiViewerRevoke},
+	{"POST", regexp.MustCompile("^viewers/([0-9]+)/points$"), RoleEditor, (*Client).apiViewerPoints},
+	{"GET", regexp.MustCompile("^viewers/([0-9]+)/profile$"), RoleMod, (*Client).apiViewerProfile},
+	{"POST", regexp.MustCompile("^viewers/([0-9]+)/notes$"), RoleMod, (*Client).apiViewerNote},
+	{"GET", regexp.MustCompile("^points$"), RoleMod, (*Client).apiPointsLeaderboard},
+	{"GET", regexp.MustCompile("^users/([[:word:]]+)$"), RoleMod, (*Client).apiUserByName},
+	{"GET", regexp.MustCompile("^me$"), RoleViewer, (*Client).apiMe},
+	{"GET", regexp.MustCompile("^chat$"), RoleMod, (*Client).apiChat},
+	{"POST", regexp.MustCompile("^chat/say$"), RoleMod, (*Client).apiSay},
+	{"GET", regexp.MustCompile("^room$"), RoleMod, (*Client).apiRoom},
+	{"POST", regexp.MustCompile("^room/mode$"), RoleMod, (*Client).apiRoomMode},
+	{"POST", regexp.MustCompile("^mod/(timeout|ban|unban|clear)$"), RoleMod, (*Client).apiModerate},
+	{"GET", regexp.MustCompile("^heartbeat$"), RoleMod, (*Client).apiHeartbeat},
+	{"GET", regexp.MustCompile("^hosts$"), RoleMod, (*Client).apiHosts},
+	{"GET", regexp.MustCompile("^presence$"), RoleMod, (*Client).apiPresence},
+	{"GET", regexp.MustCompile("^chatters$"), RoleMod, (*Client).apiChatters},
+	{"GET", regexp.MustCompile("^alerts$"), RoleMod, (*Client).apiAlerts},
+	{"GET", regexp.MustCompile("^alerts/queue$"), RoleMod, (*Client).apiAlertQueue},
+	{"POST", regexp.MustCompile("^alerts/queue/(pause|resume|skip|replay)$"), RoleMod, (*Client).apiAlertQueueControl},
+	{"GET", regexp.MustCompile("^badges$"), RoleMod, (*Client).apiBadges},
+	{"GET", regexp.MustCompile("^dumps$"), RoleEditor, (*Client).apiDumpList},
+	{"POST", regexp.MustCompile("^dumps$"), RoleOwner, (*Client).apiDumpCreate},
+	{"GET", regexp.MustCompile("^feed$"), RoleMod, (*Client).apiFeed},
+	{"GET", regexp.MustCompile("^roles$"), RoleOwner, (*Client).apiRoleList},
+	{"POST", regexp.MustCompile("^roles$"), RoleOwner, (*Client).apiRoleGrant},
+	{"GET", regexp.MustCompile("^capabilities$"), RoleMod, (*Client).apiCapabilities},
+	{"GET", regexp.MustCompile("^reconsent$"), RoleOwner, (*Client).apiReconsent},
+	{"POST", regexp.MustCompile("^secrets/rotate$"), RoleOwner, (*Client).apiRotateSecrets},
+	{"GET", regexp.MustCompile("^debug/(.+)$"), RoleOwner, (*Client).apiDebugProxy},
+}
+
+// APIError - Body of every non 2xx admin API response
+type APIError struct {
+	Status int    `json:"status"`
+	Error  string `json:"error"`
+}
+
+// APIViewerSummary - Short viewer entry used by list and search
+type APIViewerSummary struct {
+	ID          ID         `json:"id"`
+	Nick        IrcNick    `json:"nick"`
+	DisplayName string     `json:"display_name,omitempty"`
+	Follower    bool       `json:"follower"`
+	FollowedAt  *time.Time `json:"followed_at,omitempty"`
+	InRoom      bool       `json:"in_room"`
+}
+
+// APIViewerList - Page of viewers
+type APIViewerList struct {
+	Total   int                `json:"total"`
+	Offset  int                `json:"offset"`
+	Viewers []APIViewerSummary `json:"viewers"`
+}
+
+// APIViewerCache - Viewer memory tier metrics
+type APIViewerCache struct {
+	ViewerCacheStats
+	HitRate float64 `json:"hit_rate"`
+}
+
+// APIPresence - Who was in the room
+type APIPresence struct {
+	At       time.Time         `json:"at"`
+	Count    int               `json:"count"`
+	Sessions []PresenceSession `json:"sessions"`
+}
+
+// APIRoom - Room state
+type APIRoom struct {
+	Name   IrcNick       `json:"name"`
+	ID     ID            `json:"id"`
+	Mode   ChatModeState `json:"mode"`
+	InRoom []IrcNick     `json:"in_room"`
+	Stream *StreamBody   `json:"stream"`
+}
+
+// APIHosts - Who we are hosting and who has hosted us
+type APIHosts struct {
+	Hosting  IrcNick `json:"hosting,omitempty"`
+	HostedBy []ID    `json:"hosted_by"`
+}
+
+// apiRoute - Dispatch relPath to a route, 404 for no match, 405 for wrong method and 403 for too low a role
+func (ah *Client) apiRoute(w http.ResponseWriter, req *http.Request, relPath string, caller ViewerData, callerRole Role) {
+	relPath = strings.Trim(relPath, "/")
+
+	allowed := []string{}
+	for _, r := range adminRoutes {
+		args := r.pattern.FindStringSubmatch(relPath)
+		if args == nil {
+			continue
+		}
+
+		if r.method != req.Method {
+			allowed = append(allowed, r.method)
+			continue
+		}
+
+		if callerRole < r.role {
+			ah.logf("Admin access denied: %s #%s is %s, %s %s needs %s",
+				caller.GetNick(), caller.TwitchID, callerRole, req.Method, relPath, r.role)
+			apiError(w, http.StatusForbidden, "Requires role %s", r.role)
+			return
+		}
+
+		req = req.WithContext(context.WithValue(req.Context(), apiCallerKey{}, caller))
+		r.handler(ah, w, req, args[1:])
+		return
+	}
+
+	if len(allowed) > 0 {
+		w.Header().Set("Allow", strings.Join(allowed, ", "))
+		apiError(w, http.StatusMethodNotAllowed, "Method %s not allowed on %s", req.Method, relPath)
+		return
+	}
+
+	apiError(w, http.StatusNotFound, "Invalid Endpoint: %s", req.URL.Path)
+}
+
+// apiCaller - Viewer making the request
+func apiCaller(req *http.Request) ViewerData {
+	caller, _ := req.Context().Value(apiCallerKey{}).(ViewerData)
+	return caller
+}
+
+func apiJSON(w http.ResponseWriter, status int, data interface{}) {
+	b, err := json.Marshal(data)
+	if err != nil {
+		log.Printf("Admin API marshal failed: %s", err)
+		apiError(w, http.StatusInternalServerError, "Failed to encode response")
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func apiError(w http.ResponseWriter, status int, format string, args ...interface{}) {
+	b, _ := json.Marshal(APIError{Status: status, Error: fmt.Sprintf(format, args...)})
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(b)
+}
+
+func apiReadBody(req *http.Request, data interface{}) error {
+	dec := json.NewDecoder(io.LimitReader(req.Body, adminAPIMaxBody))
+	err := dec.Decode(data)
+	if err != nil {
+		return fmt.Errorf("Invalid JSON body: %s", err)
+	}
+	return nil
+}
+
+// apiPaging - offset and limit query values clamped to sane ranges
+func apiPaging(req *http.Request) (int, int) {
+	offset, _ := strconv.Atoi(req.URL.Query().Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	limit, err := strconv.Atoi(req.URL.Query().Get("limit"))
+	if err != nil || limit <= 0 {
+		limit = adminAPIDefaultLimit
+	}
+	if limit > adminAPIMaxLimit {
+		limit = adminAPIMaxLimit
+	}
+
+	return offset, limit
+}
+
+func (ah *Client) requireChat(w http.ResponseWriter) bool {
+	if ah.Chat == nil {
+		apiError(w, http.StatusServiceUnavailable, "Chat is not connected")
+		return false
+	}
+	return true
+}
+
+func (ah *Client) viewerSummary(vd ViewerData) APIViewerSummary {
+	vs := APIViewerSummary{
+		ID:   vd.TwitchID,
+		Nick: vd.GetNick(),
+	}
+
+	if vd.User != nil {
+		vs.DisplayName = vd.User.DisplayName
+	}
+
+	if vd.Follower != nil {
+		vs.Follower = true
+		t := ChannelRelationship(*vd.Follower).CreatedAt()
+		vs.FollowedAt = &t
+	}
+
+	if ah.Chat != nil {
+		vs.InRoom = ah.Chat.IsInRoom(vs.Nick)
+	}
+
+	return vs
+}
+
+func (ah *Client) localViewers(match func(vd ViewerData) bool) []APIViewerSummary {
+	vList := []APIViewerSummary{}
+	ah.Viewers.forEachData(func(vd ViewerData) bool {
+		if match == nil || match(vd) {
+			vList = append(vList, ah.viewerSummary(vd))
+		}
+		return true
+	})
+
+	sort.Slice(vList, func(i, j int) bool { return vList[i].Nick < vList[j].Nick })
+	return vList
+}
+
+// GET viewers?follower=1&offset=0&limit=100
+func (ah *Client) apiViewerList(w http.ResponseWriter, req *http.Request, args []string) {
+	followersOnly := req.URL.Query().Get("follower") == "1"
+	vList := ah.localViewers(func(vd ViewerData) bool {
+		return !followersOnly || vd.Follower != nil
+	})
+
+	offset, limit := apiPaging(req)
+	res := APIViewerList{Total: len(vList), Offset: offset, Viewers: []APIViewerSummary{}}
+	if offset < len(vList) {
+		end := offset + limit
+		if end > len(vList) {
+			end = len(vList)
+		}
+		res.Viewers = vList[offset:end]
+	}
+
+	apiJSON(w, http.StatusOK, res)
+}
+
+// GET viewers/cache
+func (ah *Client) apiViewerCache(w http.ResponseWriter, req *http.Request, args []string) {
+	cs := ah.Viewers.CacheStats()
+	apiJSON(w, http.StatusOK, APIViewerCache{ViewerCacheStats: cs, HitRate: cs.HitRate()})
+}
+
+// GET viewers/search?q=nick - Substring match on known viewers, falls back to twitch for an exact nick
+func (ah *Client) apiViewerSearch(w http.ResponseWriter, req *http.Request, args []string) {
+	q := strings.ToLower(strings.TrimSpace(req.URL.Query().Get("q")))
+	if q == "" {
+		apiError(w, http.StatusBadRequest, "Missing search query q")
+		return
+	}
+
+	vList := ah.localViewers(func(vd ViewerData) bool {
+		if strings.Contains(string(vd.GetNick()), q) {
+			return true
+		}
+		return vd.User != nil && strings.Contains(strings.ToLower(vd.User.DisplayName), q)
+	})
+
+	if len(vList) == 0 && IrcNick(q).IsValid() {
+		v, err := ah.Viewers.Find(IrcNick(q))
+		if err == nil {
+			vList = append(vList, ah.viewerSummary(v.GetData()))
+		}
+	}
+
+	_, limit := apiPaging(req)
+	if len(vList) > limit {
+		vList = vList[:limit]
+	}
+
+	apiJSON(w, http.StatusOK, vList)
+}
+
+// POST viewers/update {"nicks":["a","b"]} - Refresh viewers from twitch
+func (ah *Client) apiViewerUpdate(w http.ResponseWriter, req *http.Request, args []string) {
+	body := struct {
+		Nicks []IrcNick `json:"nicks"`
+	}{}
+	if err := apiReadBody(req, &body); err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	if len(body.Nicks) == 0 {
+		apiError(w, http.StatusBadRequest, "No nicks to update")
+		return
+	}
+	for _, n := range body.Nicks {
+		if !n.IsValid() {
+			apiError(w, http.StatusBadRequest, "Invalid Nick: %s", n)
+			return
+		}
+	}
+
+	vList := []APIViewerSummary{}
+	for _, v := range ah.Viewers.UpdateViewers(body.Nicks) {
+		vList = append(vList, ah.viewerSummary(v.GetData()))
+	}
+
+	apiJSON(w, http.StatusOK, vList)
+}
+
+// GET viewers/{id} - Full viewer data without auth tokens
+func (ah *Client) apiViewerDetail(w http.ResponseWriter, req *http.Request, args []string) {
+	vd, err := ah.Viewers.GetData(ID(args[0]))
+	if err != nil {
+		apiError(w, http.StatusNotFound, "No viewer %s", args[0])
+		return
+	}
+
+	vd.Auth = nil
+	apiJSON(w, http.StatusOK, vd)
+}
+
+// POST viewers/{id}/revoke - End every session of the viewer
+func (ah *Client) apiViewerRevoke(w http.ResponseWriter, req *http.Request, args []string) {
+	v, ok := ah.Viewers.lookup(ID(args[0]))
+	if !ok {
+		apiError(w, http.StatusNotFound, "No viewer %s", args[0])
+		return
+	}
+
+	num := v.RevokeSessions()
+	ah.logf("Revoked %d sessions for %s", num, args[0])
+	apiJSON(w, http.StatusOK, map[string]int{"revoked": num})
+}
+
+// POST viewers/{id}/points - Add or with a negative amount remove points
+func (ah *Client) apiViewerPoints(w http.ResponseWriter, req *http.Request, args []string) {
+	body := struct {
+		Amount Currency `json:"amount"`
+	}{}
+	if err := apiReadBody(req, &body); err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	v := ah.Viewers.GetPtr(ID(args[0]))
+	if v == nil {
+		apiError(w, http.StatusNotFound, "No viewer %s", args[0])
+		return
+	}
+
+	if _, err := v.AddPoints(body.Amount, PointsReasonAdjust, ""); err != nil {
+		apiError(w, http.StatusConflict, "%s", err)
+		return
+	}
+	ah.logf("Points adjusted by %d for %s", body.Amount, args[0])
+	apiJSON(w, http.StatusOK, v.GetData().Points)
+}
+
+// GET viewers/{id}/profile
+func (ah *Client) apiViewerProfile(w http.ResponseWriter, req *http.Request, args []string) {
+	vp, err := ah.ViewerProfile(ID(args[0]))
+	if err != nil {
+		apiError(w, http.StatusNotFound, "No viewer %s", args[0])
+		return
+	}
+	apiJSON(w, http.StatusOK, vp)
+}
+
+// POST viewers/{id}/notes - Add a mod note as the caller
+func (ah *Client) apiViewerNote(w http.ResponseWriter, req *http.Request, args []string) {
+	body := struct {
+		Text string `json:"text"`
+	}{}
+	if err := apiReadBody(req, &body); err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	v := ah.Viewers.GetPtr(ID(args[0]))
+	if v == nil {
+		apiError(w, http.StatusNotFound, "No viewer %s", args[0])
+		return
+	}
+
+	note, err := v.AddNote(apiCaller(req), body.Text)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	apiJSON(w, http.StatusCreated, note)
+}
+
+// GET points?by=balance|earned|time&limit=10
+func (ah *Client) apiPointsLeaderboard(w http.ResponseWriter, req *http.Request, args []string) {
+	_, limit := apiPaging(req)
+	rList, err := ah.Viewers.PointsLeaderboard(req.URL.Query().Get("by"), limit)
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+	apiJSON(w, http.StatusOK, rList)
+}
+
+// GET users/{nick}
+func (ah *Client) apiUserByName(w http.ResponseWriter, req *http.Request, args []string) {
+	uList, err := ah.User.GetByName([]IrcNick{IrcNick(args[0])})
+	if err != nil {
+		apiError(w, http.StatusBadGateway, "%s", err)
+		return
+	}
+	if len(uList) == 0 {
+		apiError(w, http.StatusNotFound, "No user found called: %s", args[0])
+		return
+	}
+
+	apiJSON(w, http.StatusOK, uList[0])
+}
+
+// GET me
+func (ah *Client) apiMe(w http.ResponseWriter, req *http.Request, args []string) {
+	uf, err := ah.User.GetMe()
+	if err != nil {
+		apiError(w, http.StatusBadGateway, "%s", err)
+		return
+	}
+
+	apiJSON(w, http.StatusOK, uf)
+}
+
+// GET chat?cat=#!&limit=100 - Most recent lines from the chat buffer
+func (ah *Client) apiChat(w http.ResponseWriter, req *http.Request, args []string) {
+	if !ah.requireChat(w) {
+		return
+	}
+
+	cats := req.URL.Query().Get("cat")
+	_, limit := apiPaging(req)
+	if req.URL.Query().Get("limit") == "" {
+		limit = numInteralLogLines
+	}
+
+	lines := []LogLineParsed{}
+	for _, llp := range ah.Chat.ReadChatFull() {
+		if cats == "" || strings.ContainsRune(cats, rune(llp.Cat)) {
+			lines = append(lines, llp)
+		}
+	}
+	if len(lines) > limit {
+		lines = lines[len(lines)-limit:]
+	}
+
+	apiJSON(w, http.StatusOK, lines)
+}
+
+// POST chat/say {"message":"hello"}
+func (ah *Client) apiSay(w http.ResponseWriter, req *http.Request, args []string) {
+	if !ah.requireChat(w) {
+		return
+	}
+
+	body := struct {
+		Message string `json:"message"`
+	}{}
+	if err := apiReadBody(req, &body); err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	msg := strings.TrimSpace(body.Message)
+	if msg == "" || strings.ContainsAny(msg, "\r\n") {
+		apiError(w, http.StatusBadRequest, "Message must be a single non empty line")
+		return
+	}
+
+	if err := ah.Chat.WriteSayMsg(msg); err != nil {
+		apiError(w, http.StatusConflict, "%s", err)
+		return
+	}
+	apiJSON(w, http.StatusAccepted, body)
+}
+
+// GET room
+func (ah *Client) apiRoom(w http.ResponseWriter, req *http.Request, args []string) {
+	room := APIRoom{
+		Name:   ah.RoomName,
+		ID:     ah.RoomID,
+		InRoom: []IrcNick{},
+		Stream: ah.RoomStream,
+	}
+
+	if ah.Chat != nil {
+		room.Mode = ah.Chat.Mode()
+		room.InRoom = append(room.InRoom, ah.Chat.InRoomNicks()...)
+		sort.Slice(room.InRoom, func(i, j int) bool { return room.InRoom[i] < room.InRoom[j] })
+	}
+
+	apiJSON(w, http.StatusOK, room)
+}
+
+// POST room/mode {"mode":"slow","on":true,"arg":30}
+func (ah *Client) apiRoomMode(w http.ResponseWriter, req *http.Request, args []string) {
+	if !ah.requireChat(w) {
+		return
+	}
+
+	body := struct {
+		Mode string `json:"mode"`
+		On   bool   `json:"on"`
+		Arg  int    `json:"arg"`
+	}{}
+	if err := apiReadBody(req, &body); err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	err := ah.Chat.SetMode(body.Mode, body.On, body.Arg)
+	if err == ErrChatReadOnly {
+		apiError(w, http.StatusConflict, "%s", err)
+		return
+	}
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	apiJSON(w, http.StatusAccepted, body)
+}
+
+// POST mod/{timeout|ban|unban|clear} {"nick":"ronni","seconds":600,"reason":"spam"}
+func (ah *Client) apiModerate(w http.ResponseWriter, req *http.Request, args []string) {
+	if !ah.requireChat(w) {
+		return
+	}
+
+	body := struct {
+		Nick    IrcNick `json:"nick"`
+		Seconds int     `json:"seconds"`
+		Reason  string  `json:"reason"`
+	}{}
+	if args[0] != "clear" {
+		if err := apiReadBody(req, &body); err != nil {
+			apiError(w, http.StatusBadRequest, "%s", err)
+			return
+		}
+	}
+
+	if strings.ContainsAny(body.Reason, "\r\n") {
+		apiError(w, http.StatusBadRequest, "Reason must be a single line")
+		return
+	}
+
+	var err error
+	switch args[0] {
+	case "timeout":
+		if body.Seconds <= 0 {
+			body.Seconds = 600
+		}
+		err = ah.Chat.Timeout(body.Nick, time.Duration(body.Seconds)*time.Second, body.Reason)
+	case "ban":
+		err = ah.Chat.Ban(body.Nick, body.Reason)
+	case "unban":
+		err = ah.Chat.Unban(body.Nick)
+	case "clear":
+		err = ah.Chat.ClearChat()
+	}
+
+	if err == ErrChatReadOnly {
+		apiError(w, http.StatusConflict, "%s", err)
+		return
+	}
+	if err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	apiJSON(w, http.StatusAccepted, body)
+}
+
+// GET heartbeat
+func (ah *Client) apiHeartbeat(w http.ResponseWriter, req *http.Request, args []string) {
+	beats := []HeartbeatData{}
+	if ah.Heart != nil {
+		beats = append(beats, ah.Heart.GetAllBeats()...)
+	}
+
+	apiJSON(w, http.StatusOK, beats)
+}
+
+// GET hosts
+func (ah *Client) apiHosts(w http.ResponseWriter, req *http.Request, args []string) {
+	hosts := APIHosts{HostedBy: []ID{}}
+	if ah.Heart != nil {
+		ah.Heart.heartLock.RLock()
+		hosts.HostedBy = append(hosts.HostedBy, ah.Heart.GetAllHosts()...)
+		ah.Heart.heartLock.RUnlock()
+	}
+	if ah.Chat != nil {
+		hosts.Hosting = ah.Chat.Mode().Hosting
+	}
+
+	apiJSON(w, http.StatusOK, hosts)
+}
+
+// GET presence?at=1500000000 or RFC3339 - Present at a time, now without at, nick gives their history instead
+func (ah *Client) apiPresence(w http.ResponseWriter, req *http.Request, args []string) {
+	if ah.Presence == nil {
+		apiError(w, http.StatusServiceUnavailable, "Presence is not tracked")
+		return
+	}
+
+	q := req.URL.Query()
+	res := APIPresence{At: time.Now()}
+	if s := q.Get("at"); s != "" {
+		if secs, err := strconv.ParseInt(s, 10, 64); err == nil {
+			res.At = time.Unix(secs, 0)
+		} else if t, err := time.Parse(time.RFC3339, s); err == nil {
+			res.At = t
+		} else {
+			apiError(w, http.StatusBadRequest, "Invalid at: %s", s)
+			return
+		}
+	}
+
+	switch {
+	case q.Get("nick") != "":
+		res.Sessions = ah.Presence.History(IrcNick(q.Get("nick")))
+	case q.Get("at") != "":
+		res.Sessions = ah.Presence.At(res.At)
+	default:
+		res.Sessions = ah.Presence.Present()
+	}
+
+	res.Count = len(res.Sessions)
+	apiJSON(w, http.StatusOK, res)
+}
+
+// GET chatters - Last chatters poll
+func (ah *Client) apiChatters(w http.ResponseWriter, req *http.Request, args []string) {
+	if ah.Heart == nil {
+		apiError(w, http.StatusServiceUnavailable, "Heartbeat is not running")
+		return
+	}
+
+	snap := ah.Heart.GetChatters()
+	if snap == nil {
+		apiError(w, http.StatusNotFound, "Chatters have not been polled yet")
+		return
+	}
+	apiJSON(w, http.StatusOK, snap)
+}
+
+// GET alerts?type=100,300&since=12&limit=10
+func (ah *Client) apiAlerts(w http.ResponseWriter, req *http.Request, args []string) {
+	q := req.URL.Query()
+	aq := AlertQuery{}
+
+	for _, s := range strings.Split(q.Get("type"), ",") {
+		if s == "" {
+			continue
+		}
+		n, err := strconv.Atoi(s)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, "Invalid alert type: %s", s)
+			return
+		}
+		aq.Types = append(aq.Types, AlertType(n))
+	}
+
+	_, aq.Limit = apiPaging(req)
+	if q.Get("limit") == "" {
+		aq.Limit = numRecentAlerts
+	}
+
+	if s := q.Get("since"); s != "" {
+		id, err := strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			apiError(w, http.StatusBadRequest, "Invalid since: %s", s)
+			return
+		}
+		aq.AfterID = AlertID(id)
+	}
+
+	apiJSON(w, http.StatusOK, ah.Alerts.Journal.Query(aq))
+}
+
+// APIAlertQueue - State of the overlay alert queue
+type APIAlertQueue struct {
+	Paused  bool           `json:"paused"`
+	Showing *AlertDisplay  `json:"showing"`
+	Pending []AlertDisplay `json:"pending"`
+	History []AlertDisplay `json:"history"`
+}
+
+func (ah *Client) alertQueueState() APIAlertQueue {
+	return APIAlertQueue{
+		Paused:  ah.AlertQueue.IsPaused(),
+		Showing: ah.AlertQueue.Showing(),
+		Pending: ah.AlertQueue.Pending(),
+		History: ah.AlertQueue.History(),
+	}
+}
+
+// GET alerts/queue
+func (ah *Client) apiAlertQueue(w http.ResponseWriter, req *http.Request, args []string) {
+	if ah.AlertQueue == nil {
+		apiError(w, http.StatusServiceUnavailable, "Alert queue is not enabled")
+		return
+	}
+	apiJSON(w, http.StatusOK, ah.alertQueueState())
+}
+
+// POST alerts/queue/{pause|resume|skip|replay}, replay takes ?back=N where 0 is the most recent
+func (ah *Client) apiAlertQueueControl(w http.ResponseWriter, req *http.Request, args []string) {
+	if ah.AlertQueue == nil {
+		apiError(w, http.StatusServiceUnavailable, "Alert queue is not enabled")
+		return
+	}
+
+	switch args[0] {
+	case "pause":
+		ah.AlertQueue.Pause()
+	case "resume":
+		ah.AlertQueue.Resume()
+	case "skip":
+		ah.AlertQueue.Skip()
+	case "replay":
+		back := 0
+		if s := req.URL.Query().Get("back"); s != "" {
+			n, err := strconv.Atoi(s)
+			if err != nil || n < 0 {
+				apiError(w, http.StatusBadRequest, "Invalid back: %s", s)
+				return
+			}
+			back = n
+		}
+		if back >= len(ah.AlertQueue.History()) {
+			apiError(w, http.StatusNotFound, "Nothing to replay %d back", back)
+			return
+		}
+		ah.AlertQueue.Replay(back)
+	}
+
+	caller := apiCaller(req)
+	ah.logf("Alert queue %s by %s", args[0], caller.GetNick())
+	apiJSON(w, http.StatusAccepted, ah.alertQueueState())
+}
+
+// GET badges
+func (ah *Client) apiBadges(w http.ResponseWriter, req *http.Request, args []string) {
+	if ah.Badges == nil {
+		apiError(w, http.StatusServiceUnavailable, "Badges not loaded")
+		return
+	}
+
+	ah.Badges.m.Lock()
+	defer ah.Badges.m.Unlock()
+
+	apiJSON(w, http.StatusOK, map[string]interface{}{
+		"room":   ah.Badges.RoomBadge,
+		"global": ah.Badges.GlobalBadge,
+	})
+}
+
+// GET dumps
+func (ah *Client) apiDumpList(w http.ResponseWriter, req *http.Request, args []string) {
+	files := []string{}
+	for _, res := range GetDumpListing(ah.RoomName) {
+		files = append(files, res[0])
+	}
+
+	apiJSON(w, http.StatusOK, files)
+}
+
+// POST dumps - Write viewer dump now
+func (ah *Client) apiDumpCreate(w http.ResponseWriter, req *http.Request, args []string) {
+	if err := ah.DumpViewers(); err != nil {
+		apiError(w, http.StatusInternalServerError, "Failed to Dump: %s", err)
+		return
+	}
+
+	apiJSON(w, http.StatusCreated, map[string]IrcNick{"room": ah.RoomName})
+}
+
+// GET feed - Websocket upgrade
+func (ah *Client) apiFeed(w http.ResponseWriter, req *http.Request, args []string) {
+	ah.Feed.ServeHTTP(w, req)
+}
+
+// APIRoleEntry - Role of a viewer
+type APIRoleEntry struct {
+	ID   ID      `json:"id"`
+	Nick IrcNick `json:"nick,omitempty"`
+	Role Role    `json:"role"`
+}
+
+// GET roles - Explicit grants
+func (ah *Client) apiRoleList(w http.ResponseWriter, req *http.Request, args []string) {
+	rList := []APIRoleEntry{}
+	for tid, r := range ah.Access.Grants() {
+		re := APIRoleEntry{ID: tid, Role: r}
+		v, ok := ah.Viewers.lookup(tid)
+		if ok {
+			vd := v.GetData()
+			re.Nick = vd.GetNick()
+		}
+		rList = append(rList, re)
+	}
+	sort.Slice(rList, func(i, j int) bool { return rList[i].ID < rList[j].ID })
+
+	apiJSON(w, http.StatusOK, rList)
+}
+
+// POST roles {"nick":"ronni","role":"editor"} - Grant a role, role none removes the grant
+func (ah *Client) apiRoleGrant(w http.ResponseWriter, req *http.Request, args []string) {
+	body := APIRoleEntry{}
+	if err := apiReadBody(req, &body); err != nil {
+		apiError(w, http.StatusBadRequest, "%s", err)
+		return
+	}
+
+	if body.ID == "" {
+		v, err := ah.Viewers.Find(body.Nick)
+		if err != nil {
+			apiError(w, http.StatusNotFound, "%s", err)
+			return
+		}
+		body.ID = v.GetData().TwitchID
+	}
+
+	if err := ah.Access.Grant(body.ID, body.Role); err != nil {
+		apiError(w, http.StatusInternalServerError, "Failed to save grant: %s", err)
+		return
+	}
+
+	apiJSON(w, http.StatusOK, body)
+}
+
+// APICapabilities - Features enabled by the admin scopes
+type APICapabilities struct {
+	Capabilities []Capability `json:"capabilities"`
+	Missing      []string     `json:"missing"`
+	Reconsent    string       `json:"reconsent,omitempty"`
+}
+
+// GET capabilities - Enabled and disabled features with the re-consent path
+func (ah *Client) apiCapabilities(w http.ResponseWriter, req *http.Request, args []string) {
+	apiJSON(w, http.StatusOK, APICapabilities{
+		Capabilities: ah.Capabilities(),
+		Missing:      ah.MissingScopes(),
+		Reconsent:    ah.ReconsentURL(),
+	})
+}
+
+// GET reconsent?scope=a+b - Send the admin to twitch for the extra scopes
+func (ah *Client) apiReconsent(w http.ResponseWriter, req *http.Request, args []string) {
+	ah.handleOAuthAdminStart(w, req)
+}
+
+// POST secrets/rotate - New secret key, everything sealed is written again
+func (ah *Client) apiRotateSecrets(w http.ResponseWriter, req *http.Request, args []string) {
+	keyID, err := ah.RotateSecretKey()
+	if err != nil {
+		ah.logf("Secret key rotation failed: %s", err)
+		apiError(w, http.StatusInternalServerError, "Rotation failed: %s", err)
+		return
+	}
+
+	apiJSON(w, http.StatusOK, map[string]string{"key_id": keyID})
+}
+
+// GET debug/{path} - Raw API proxy using the admin token
+func (ah *Client) apiDebugProxy(w http.ResponseWriter, req *http.Request, args []string) {
+	if !debugOptions {
+		apiError(w, http.StatusNotFound, "Invalid Endpoint: %s", req.URL.Path)
+		return
+	}
+
+	splitD := strings.SplitN(req.RequestURI, "debug/", 2)
+	ah.logf("Debug: %s", splitD[1])
+	body, err := ah.Get(ah.AdminAuth, splitD[1], nil)
+	if err != nil {
+		apiError(w, http.StatusBadGateway, "%s", err)
+		return
+	}
+
+	fmt.Fprint(w, body)
+}
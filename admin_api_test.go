@@ -0,0 +1,129 @@
+package twitch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAdminAPIRoutes(t *testing.T) {
+	ah := &Client{RoomName: "kimau", Alerts: StartAlertPump(nil)}
+	ah.Viewers = CreateViewerMethod(ah)
+	ah.Viewers.Set(ViewerData{TwitchID: "1", User: &User{ID: "1", Name: "ronni", DisplayName: "Ronni"}})
+	ah.Viewers.Set(ViewerData{TwitchID: "2", User: &User{ID: "2", Name: "fred"},
+		Auth: &UserAuth{Scopes: map[string]bool{"chat_login": true}}})
+
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/"+path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		ah.apiRoute(rec, req, path[:strings.IndexAny(path+"?", "?")], ViewerData{TwitchID: "1"}, RoleOwner)
+		return rec
+	}
+
+	rec := call("GET", "viewers?limit=1", "")
+	vl := APIViewerList{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &vl); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("viewers %d %s", rec.Code, rec.Body)
+	}
+	if vl.Total != 2 || len(vl.Viewers) != 1 || vl.Viewers[0].Nick != "fred" {
+		t.Errorf("viewers paging wrong %+v", vl)
+	}
+
+	rec = call("GET", "viewers/search?q=RON", "")
+	if !strings.Contains(rec.Body.String(), `"nick":"ronni"`) {
+		t.Errorf("search %d %s", rec.Code, rec.Body)
+	}
+
+	rec = call("GET", "viewers/2", "")
+	if rec.Code != http.StatusOK || strings.Contains(rec.Body.String(), "chat_login") {
+		t.Errorf("detail should strip auth %d %s", rec.Code, rec.Body)
+	}
+
+	tests := []struct {
+		method, path, body string
+		want               int
+	}{
+		{"GET", "nothing/here", "", http.StatusNotFound},
+		{"DELETE", "viewers", "", http.StatusMethodNotAllowed},
+		{"GET", "viewers/search", "", http.StatusBadRequest},
+		{"GET", "chat", "", http.StatusServiceUnavailable},
+		{"POST", "chat/say", `{"message":"hi"}`, http.StatusServiceUnavailable},
+		{"GET", "alerts?type=bits", "", http.StatusBadRequest},
+		{"GET", "alerts", "", http.StatusOK},
+		{"GET", "room", "", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		rec = call(tt.method, tt.path, tt.body)
+		if rec.Code != tt.want {
+			t.Errorf("%s %s = %d want %d", tt.method, tt.path, rec.Code, tt.want)
+		}
+
+		if rec.Code >= 400 {
+			ae := APIError{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &ae); err != nil || ae.Error == "" {
+				t.Errorf("%s %s missing error body: %s", tt.method, tt.path, rec.Body)
+			}
+		}
+	}
+}
+
+func TestAdminAccessRoles(t *testing.T) {
+	ah := &Client{RoomName: "kimau", RoomID: "100", AdminAuth: &UserAuth{Token: &authToken{}}, Alerts: StartAlertPump(nil)}
+	ah.Viewers = CreateViewerMethod(ah)
+	ah.Access = CreateAccessControl(ah, "")
+
+	login := func(tid ID, nick IrcNick, chatter *Chatter) *http.Cookie {
+		ah.Viewers.Set(ViewerData{TwitchID: tid, User: &User{ID: tid, Name: nick},
+			Auth: &UserAuth{Token: &authToken{UserID: tid}}, Chatter: chatter})
+		token, expires, err := ah.Viewers.GetPtr(tid).StartSession(httptest.NewRequest("GET", "/", nil))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return ah.makeSessionCookie(tid, token, expires)
+	}
+
+	owner := login("100", "kimau", nil)
+	mod := login("2", "ronni", &Chatter{Mod: true})
+	viewer := login("3", "fred", nil)
+
+	call := func(c *http.Cookie, method, path string) int {
+		req := httptest.NewRequest(method, "/twitch/admin/"+path, nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		rec := httptest.NewRecorder()
+		ah.servePath = "/twitch/admin/"
+		ah.AdminHTTP(rec, req)
+		return rec.Code
+	}
+
+	tests := []struct {
+		name   string
+		cookie *http.Cookie
+		method string
+		path   string
+		want   int
+	}{
+		{"anon", nil, "GET", "room", http.StatusUnauthorized},
+		{"forged", &http.Cookie{Name: UserAuthSessionCookieName, Value: "100:guess"}, "GET", "room", http.StatusUnauthorized},
+		{"viewer room", viewer, "GET", "room", http.StatusForbidden},
+		{"viewer debug", viewer, "GET", "debug/users", http.StatusForbidden},
+		{"mod room", mod, "GET", "room", http.StatusOK},
+		{"mod dump", mod, "POST", "dumps", http.StatusForbidden},
+		{"owner roles", owner, "GET", "roles", http.StatusOK},
+	}
+
+	for _, tt := range tests {
+		if got := call(tt.cookie, tt.method, tt.path); got != tt.want {
+			t.Errorf("%s: %s %s = %d want %d", tt.name, tt.method, tt.path, got, tt.want)
+		}
+	}
+
+	ah.Access.Grant("3", RoleEditor)
+	if got := call(viewer, "GET", "dumps"); got != http.StatusOK {
+		t.Errorf("Granted editor dumps = %d", got)
+	}
+}
@@ -1,16 +1,86 @@
 package twitch
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"strings"
 	"sync"
+	"time"
+)
+
+const (
+	numRecentAlerts = 10
 )
 
 // Alert - The main method to find out when stuff has happened
 type Alert struct {
-	Type   AlertType   `json:"type"`
-	Source IrcNick     `json:"source"`
-	Data   interface{} `json:"data"`
+	ID      AlertID     `json:"id,omitempty"`       // Set by the journal, AlertNone is never journaled
+	EventID string      `json:"event_id,omitempty"` // Stable identity from twitch such as the IRC id tag
+	Time    time.Time   `json:"time"`
+	Type    AlertType   `json:"type"`
+	Source  IrcNick     `json:"source"`
+	Data    interface{} `json:"data"`
+}
+
+// UnmarshalJSON - Restores the concrete Data type so journaled alerts behave like live ones
+func (a *Alert) UnmarshalJSON(b []byte) error {
+	raw := struct {
+		ID      AlertID         `json:"id"`
+		EventID string          `json:"event_id"`
+		Time    time.Time       `json:"time"`
+		Type    AlertType       `json:"type"`
+		Source  IrcNick         `json:"source"`
+		Data    json.RawMessage `json:"data"`
+	}{}
+
+	err := json.Unmarshal(b, &raw)
+	if err != nil {
+		return err
+	}
+
+	a.ID = raw.ID
+	a.EventID = raw.EventID
+	a.Time = raw.Time
+	a.Type = raw.Type
+	a.Source = raw.Source
+	a.Data = nil
+
+	if len(raw.Data) == 0 || string(raw.Data) == "null" {
+		return nil
+	}
+
+	switch a.Type {
+	case AlertBits, AlertWhisper:
+		llp := LogLineParsed{}
+		err = json.Unmarshal(raw.Data, &llp)
+		a.Data = llp
+
+	case AlertFollow:
+		t := time.Time{}
+		err = json.Unmarshal(raw.Data, &t)
+		a.Data = t
+
+	case AlertNone:
+		hbd := HeartbeatData{}
+		err = json.Unmarshal(raw.Data, &hbd)
+		a.Data = hbd
+
+	case AlertHost:
+		numViewers := 0
+		if json.Unmarshal(raw.Data, &numViewers) == nil {
+			a.Data = numViewers
+			return nil
+		}
+		hd := HostData{}
+		err = json.Unmarshal(raw.Data, &hd)
+		a.Data = hd
+
+	default:
+		err = json.Unmarshal(raw.Data, &a.Data)
+	}
+
+	return err
 }
 
 func (a Alert) String() string {
@@ -41,7 +111,12 @@ func (a Alert) NameString() string {
 }
 
 // IsDuplicate - Checks for Dupe Logic
+// Alerts carrying an event ID are only duplicates of the same event
 func (a Alert) IsDuplicate(other *Alert) bool {
+	if a.EventID != "" || other.EventID != "" {
+		return a.EventID == other.EventID
+	}
+
 	if a.Type != other.Type || a.Source != other.Source {
 		return false
 	}
@@ -85,36 +160,141 @@ func (a Alert) IsDuplicate(other *Alert) bool {
 	}
 }
 
+// AlertFilter - Restricts which alerts a subscriber gets, zero values match everything
+type AlertFilter struct {
+	Types          []AlertType      // Only these types, empty means all including AlertNone
+	Sources        []IrcNick        // Only from these nicks
+	MinBits        int              // Bits alerts below this are dropped
+	MinHostViewers int              // Host alerts below this are dropped, heartbeat hosts carry no count and pass
+	Match          func(Alert) bool // Custom predicate run after the other checks
+}
+
+// Allows - Checks if alert passes the filter
+func (af *AlertFilter) Allows(a Alert) bool {
+	if af == nil {
+		return true
+	}
+
+	if len(af.Types) > 0 {
+		found := false
+		for _, t := range af.Types {
+			if t == a.Type {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(af.Sources) > 0 {
+		found := false
+		for _, n := range af.Sources {
+			if strings.EqualFold(string(n), string(a.Source)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if af.MinBits > 0 && a.Type == AlertBits && a.Bits() < af.MinBits {
+		return false
+	}
+
+	if af.MinHostViewers > 0 && a.Type == AlertHost {
+		if n, known := a.hostViewerCount(); known && n < af.MinHostViewers {
+			return false
+		}
+	}
+
+	if af.Match != nil {
+		return af.Match(a)
+	}
+
+	return true
+}
+
+// Bits - Number of bits in a Bits alert otherwise 0
+func (a Alert) Bits() int {
+	switch d := a.Data.(type) {
+	case LogLineParsed:
+		if d.Msg != nil {
+			return d.Msg.Bits
+		}
+	case *LogLineParsed:
+		if d != nil && d.Msg != nil {
+			return d.Msg.Bits
+		}
+	}
+
+	return 0
+}
+
+// HostViewers - Number of viewers brought by a Host alert if known otherwise 0
+func (a Alert) HostViewers() int {
+	n, _ := a.hostViewerCount()
+	return n
+}
+
+// hostViewerCount - Viewer count from a HOSTTARGET host, HostData from the heartbeat has none
+func (a Alert) hostViewerCount() (int, bool) {
+	n, ok := a.Data.(int)
+	return n, ok
+}
+
 type subToAlertPump struct {
-	Name string
-	C    chan Alert
+	Name    string
+	C       chan Alert
+	Options SubOptions
+	Filter  *AlertFilter
+
+	counters *subCounters
+}
+
+func (sub *subToAlertPump) deliver(a Alert) bool {
+	return deliverTo(sub.C, a, sub.Options, sub.counters)
 }
 
 // AlertPump - Managers the Subscription to Alerts
 type AlertPump struct {
-	subbedToAlerts []subToAlertPump
-	newSubs        chan subToAlertPump
-	killSubs       chan subToAlertPump
+	subbedToAlerts []*subToAlertPump
+	subLock        sync.Mutex
+	newSubs        chan *subToAlertPump
+	killSubs       chan chan Alert
 	newAlerts      chan Alert
-	recentAlerts   []Alert
-	recentLock     sync.Mutex
 
-	client *Client
+	Journal *AlertJournal
+	client  *Client
 }
 
 // StartAlertPump - Start the internal go routine and create the pump
 func StartAlertPump(clientRef *Client) *AlertPump {
 
 	pump := AlertPump{
-		subbedToAlerts: []subToAlertPump{},
-		newSubs:        make(chan subToAlertPump, 10),
-		killSubs:       make(chan subToAlertPump, 10),
+		subbedToAlerts: []*subToAlertPump{},
+		newSubs:        make(chan *subToAlertPump, 10),
+		killSubs:       make(chan chan Alert, 10),
 		newAlerts:      make(chan Alert, 10),
-		recentAlerts:   []Alert{},
 
 		client: clientRef,
 	}
 
+	journalFile := ""
+	if clientRef != nil {
+		journalFile = clientRef.Config.dataPath(alertJournalPattern, clientRef.RoomName)
+	}
+
+	var err error
+	pump.Journal, err = OpenAlertJournal(journalFile)
+	if err != nil {
+		log.Printf("Unable to open alert journal %s using memory only\n%s", journalFile, err)
+		pump.Journal, _ = OpenAlertJournal("")
+	}
+
 	go pump.run()
 
 	return &pump
@@ -125,27 +305,21 @@ func (pump *AlertPump) run() {
 pumpLoop:
 	for {
 		select {
-		case deadSub, ok := <-pump.killSubs:
+		case deadChan, ok := <-pump.killSubs:
 			if !ok {
 				break pumpLoop
 			}
 
-			subs := []subToAlertPump{}
-			for _, sub := range pump.subbedToAlerts {
-				if sub.C == deadSub.C {
-					close(deadSub.C)
-				} else {
-					subs = append(subs, sub)
-				}
-			}
-			pump.subbedToAlerts = subs
+			pump.removeSub(deadChan)
 
 		case newSub, ok := <-pump.newSubs:
 			if !ok {
 				break pumpLoop
 			}
 
+			pump.subLock.Lock()
 			pump.subbedToAlerts = append(pump.subbedToAlerts, newSub)
+			pump.subLock.Unlock()
 
 		case newAlert := <-pump.newAlerts:
 			err := pump.postInternal(newAlert)
@@ -156,17 +330,51 @@ pumpLoop:
 	}
 
 	// Close all alert Channels
+	pump.subLock.Lock()
 	for _, sub := range pump.subbedToAlerts {
 		close(sub.C)
 	}
+	pump.subbedToAlerts = nil
+	pump.subLock.Unlock()
+}
+
+// removeSub - Remove and close subscription, safe to call for already removed channels
+func (pump *AlertPump) removeSub(deadChan chan Alert) {
+	pump.subLock.Lock()
+	defer pump.subLock.Unlock()
+
+	subs := []*subToAlertPump{}
+	for _, sub := range pump.subbedToAlerts {
+		if sub.C == deadChan {
+			close(sub.C)
+		} else {
+			subs = append(subs, sub)
+		}
+	}
+	pump.subbedToAlerts = subs
 }
 
 // Sub - Create a Subsciption to Alerts
 func (pump *AlertPump) Sub(subName string) chan Alert {
-	newSub := make(chan Alert, 10)
-	pump.newSubs <- subToAlertPump{
-		Name: subName,
-		C:    newSub,
+	return pump.SubWithOptions(subName, DefaultSubOptions())
+}
+
+// SubWithOptions - Create a Subsciption to Alerts with buffer size and overflow policy
+func (pump *AlertPump) SubWithOptions(subName string, opts SubOptions) chan Alert {
+	return pump.SubFiltered(subName, AlertFilter{}, opts)
+}
+
+// SubFiltered - Create a Subsciption which only gets alerts passing the filter
+func (pump *AlertPump) SubFiltered(subName string, filter AlertFilter, opts SubOptions) chan Alert {
+	opts = opts.sanitise()
+	newSub := make(chan Alert, opts.BufferSize)
+	pump.newSubs <- &subToAlertPump{
+		Name:    subName,
+		C:       newSub,
+		Options: opts,
+		Filter:  &filter,
+
+		counters: newSubCounters(),
 	}
 
 	return newSub
@@ -174,57 +382,63 @@ func (pump *AlertPump) Sub(subName string) chan Alert {
 
 // Unsub - Kill a Subscription Channel
 func (pump *AlertPump) Unsub(deadChannel chan Alert) {
-	pump.killSubs <- subToAlertPump{
-		Name: "dead",
-		C:    deadChannel,
+	pump.killSubs <- deadChannel
+}
+
+// Subscribers - Diagnostic snapshot of every current subscriber
+func (pump *AlertPump) Subscribers() []SubStats {
+	pump.subLock.Lock()
+	defer pump.subLock.Unlock()
+
+	sList := make([]SubStats, 0, len(pump.subbedToAlerts))
+	for _, sub := range pump.subbedToAlerts {
+		sList = append(sList, sub.counters.stats(sub.Name, sub.Options, len(sub.C)))
 	}
+
+	return sList
 }
 
 // Post - Post Alert to Listeners
 func (pump *AlertPump) Post(source IrcNick, name AlertType, extraData interface{}) {
+	pump.PostEvent(source, name, "", extraData)
+}
+
+// PostEvent - Post Alert with the event ID twitch gave it so repeats of the same event are dropped
+func (pump *AlertPump) PostEvent(source IrcNick, name AlertType, eventID string, extraData interface{}) {
 	pump.newAlerts <- Alert{
-		Type:   name,
-		Source: source,
-		Data:   extraData,
+		EventID: eventID,
+		Time:    time.Now(),
+		Type:    name,
+		Source:  source,
+		Data:    extraData,
 	}
 }
 
 // CopyRecentAlerts - Make a copy of recent alerts and returns them
 func (pump *AlertPump) CopyRecentAlerts() (retList []Alert) {
-	pump.recentLock.Lock()
-	retList = make([]Alert, len(pump.recentAlerts), len(pump.recentAlerts))
-	copy(retList, pump.recentAlerts)
-	pump.recentLock.Unlock()
-	return retList
+	return pump.Journal.Recent(numRecentAlerts)
 }
 
 func (pump *AlertPump) postInternal(newAlert Alert) error {
-	pump.recentLock.Lock()
-	defer pump.recentLock.Unlock()
-
 	// Exception for None just forward it
 	if newAlert.Type != AlertNone {
-
-		// Sanity Check to avoid doubling of Alerts
-		for _, a := range pump.recentAlerts {
-			if a.IsDuplicate(&newAlert) {
-				return fmt.Errorf("Doubling of Prev Alert: %s", a)
-			}
-		}
-
-		// Add to Recent Alerts
-		if len(pump.recentAlerts) > 10 {
-			pump.recentAlerts = append(pump.recentAlerts[:9], newAlert)
-		} else {
-			pump.recentAlerts = append(pump.recentAlerts, newAlert)
+		var err error
+		newAlert, err = pump.Journal.Append(newAlert)
+		if err != nil {
+			return err
 		}
 	}
 
-	// Forward Alert
-	for _, subs := range pump.subbedToAlerts {
-		select {
-		case subs.C <- newAlert:
-		default: // Non blocking
+	// Forward Alert, delivered without the lock as a blocking subscriber can take a while
+	// Subscribers are only removed on this goroutine so none are closed while sending
+	pump.subLock.Lock()
+	subs := append([]*subToAlertPump{}, pump.subbedToAlerts...)
+	pump.subLock.Unlock()
+
+	for _, sub := range subs {
+		if sub.Filter.Allows(newAlert) && !sub.deliver(newAlert) {
+			log.Printf("Alert subscriber %s overflowed and was disconnected", sub.Name)
+			pump.removeSub(sub.C)
 		}
 	}
 
@@ -0,0 +1,423 @@
+package twitch
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+)
+
+const (
+	defaultAlertDupeWindow = time.Second * 10
+	journalMaxLineSize     = 1024 * 1024
+	journalMemoryEntries   = 1000
+)
+
+// AlertID - Monotonic identifier given to an alert by the journal
+type AlertID uint64
+
+// AlertQuery - Query against the journal, zero values match everything
+type AlertQuery struct {
+	Types   []AlertType
+	From    time.Time
+	To      time.Time
+	AfterID AlertID
+	Source  IrcNick // Case insensitive
+	Limit   int     // Most recent N matches
+}
+
+type journalAck struct {
+	Consumer string    `json:"consumer"`
+	ID       AlertID   `json:"id"`
+	Time     time.Time `json:"time"`
+}
+
+// journalRecord - Single line in the journal file
+type journalRecord struct {
+	Alert *Alert      `json:"alert,omitempty"`
+	Ack   *journalAck `json:"ack,omitempty"`
+}
+
+// AlertJournal - Persistent append only log of alerts and acknowledgements
+type AlertJournal struct {
+	DupeWindow time.Duration // Alerts without an event ID are compared with others this recent
+
+	lock     sync.RWMutex
+	entries  []Alert // Most recent alerts, older ones are read back from the file
+	keep     int
+	acks     map[string]map[AlertID]time.Time
+	lastID   AlertID
+	file     *os.File
+	filename string
+}
+
+// OpenAlertJournal - Load journal from file and keep appending to it, empty filename is memory only
+func OpenAlertJournal(filename string) (*AlertJournal, error) {
+	aj := AlertJournal{
+		DupeWindow: defaultAlertDupeWindow,
+		entries:    []Alert{},
+		keep:       journalMemoryEntries,
+		acks:       make(map[string]map[AlertID]time.Time),
+	}
+
+	if filename == "" {
+		return &aj, nil
+	}
+
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, secretFileMode)
+	if err != nil {
+		return nil, err
+	}
+
+	// Journals created before the mode was tightened
+	if err = f.Chmod(secretFileMode); err != nil {
+		log.Printf("Alert Journal %s unable to restrict file mode\n%s", filename, err)
+	}
+
+	ls := bufio.NewScanner(f)
+	ls.Buffer(make([]byte, 4096), journalMaxLineSize)
+	lineNum := 0
+	lineStart, lineEnd := int64(0), int64(0) // lineEnd counts the newline even if the file has none
+	lastOK := true
+	for ls.Scan() {
+		lineNum++
+		lineStart = lineEnd
+		lineEnd += int64(len(ls.Bytes())) + 1
+
+		rec := journalRecord{}
+		err = json.Unmarshal(ls.Bytes(), &rec)
+		lastOK = err == nil
+		if err != nil {
+			log.Printf("Alert Journal %s:%d skipping bad line\n%s", filename, lineNum, err)
+			continue
+		}
+
+		aj.apply(rec)
+	}
+
+	if err = ls.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	// Crash part way through the final append, the next record would be glued onto it
+	if err = repairJournalTail(f, lineStart, lineEnd, lastOK); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	aj.file = f
+	aj.filename = filename
+	return &aj, nil
+}
+
+// repairJournalTail - Ends the file on a newline, a torn final line is dropped and a whole one terminated
+func repairJournalTail(f *os.File, lineStart int64, lineEnd int64, lastOK bool) error {
+	info, err := f.Stat()
+	if err != nil {
+		return err
+	}
+	if lineEnd <= info.Size() {
+		return nil
+	}
+
+	if lastOK {
+		_, err = f.Write([]byte{'\n'})
+		return err
+	}
+
+	log.Printf("Alert Journal %s has a torn line at %d of %d, dropping it", f.Name(), lineStart, info.Size())
+	return f.Truncate(lineStart)
+}
+
+func (aj *AlertJournal) apply(rec journalRecord) {
+	if rec.Alert != nil {
+		aj.remember(*rec.Alert)
+		if rec.Alert.ID > aj.lastID {
+			aj.lastID = rec.Alert.ID
+		}
+	}
+
+	if rec.Ack != nil {
+		cAcks, ok := aj.acks[rec.Ack.Consumer]
+		if !ok {
+			cAcks = make(map[AlertID]time.Time)
+			aj.acks[rec.Ack.Consumer] = cAcks
+		}
+		cAcks[rec.Ack.ID] = rec.Ack.Time
+	}
+}
+
+// remember - Add to the in memory tail dropping the oldest past keep
+func (aj *AlertJournal) remember(a Alert) {
+	aj.entries = append(aj.entries, a)
+	if len(aj.entries) > aj.keep {
+		aj.entries = aj.entries[len(aj.entries)-aj.keep:]
+	}
+}
+
+// firstID - ID of the oldest alert held in memory, anything before it is only in the file
+func (aj *AlertJournal) firstID() AlertID {
+	if len(aj.entries) == 0 {
+		return aj.lastID + 1
+	}
+	return aj.entries[0].ID
+}
+
+// readOlder - Alerts between afterID and before read back from the file oldest first
+// Lines before the in memory tail are never rewritten so this runs without the lock
+func readOlder(filename string, afterID AlertID, before AlertID) []Alert {
+	retList := []Alert{}
+	if filename == "" || afterID+1 >= before {
+		return retList
+	}
+
+	f, err := os.Open(filename)
+	if err != nil {
+		log.Printf("Alert Journal %s unable to read history\n%s", filename, err)
+		return retList
+	}
+	defer f.Close()
+
+	ls := bufio.NewScanner(f)
+	ls.Buffer(make([]byte, 4096), journalMaxLineSize)
+	for ls.Scan() {
+		rec := journalRecord{}
+		if json.Unmarshal(ls.Bytes(), &rec) != nil || rec.Alert == nil || rec.Alert.ID <= afterID {
+			continue
+		}
+		if rec.Alert.ID >= before {
+			break
+		}
+		retList = append(retList, *rec.Alert)
+	}
+
+	if err = ls.Err(); err != nil {
+		log.Printf("Alert Journal %s history read stopped\n%s", filename, err)
+	}
+	return retList
+}
+
+func (aj *AlertJournal) write(rec journalRecord) error {
+	if aj.file == nil {
+		return nil
+	}
+
+	b, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	_, err = aj.file.Write(append(b, '\n'))
+	return err
+}
+
+// Append - Give alert an ID and store it, fails if it duplicates one inside DupeWindow
+func (aj *AlertJournal) Append(a Alert) (Alert, error) {
+	aj.lock.Lock()
+	defer aj.lock.Unlock()
+
+	if a.Time.IsZero() {
+		a.Time = time.Now()
+	}
+
+	if dupe := aj.findDuplicate(a); dupe != nil {
+		return *dupe, fmt.Errorf("Doubling of Prev Alert: %s", dupe)
+	}
+
+	a.ID = aj.lastID + 1
+	err := aj.write(journalRecord{Alert: &a})
+	if err != nil {
+		return a, err
+	}
+
+	aj.lastID = a.ID
+	aj.remember(a)
+
+	return a, nil
+}
+
+// findDuplicate - Same event ID anywhere in the memory tail, without one the same alert inside DupeWindow
+func (aj *AlertJournal) findDuplicate(a Alert) *Alert {
+	cutoff := a.Time.Add(-aj.DupeWindow)
+
+	for i := len(aj.entries) - 1; i >= 0; i-- {
+		prev := &aj.entries[i]
+		if a.EventID == "" && prev.Time.Before(cutoff) {
+			break
+		}
+
+		if prev.IsDuplicate(&a) {
+			return prev
+		}
+	}
+
+	return nil
+}
+
+// LastID - ID of the most recent alert
+func (aj *AlertJournal) LastID() AlertID {
+	aj.lock.RLock()
+	defer aj.lock.RUnlock()
+	return aj.lastID
+}
+
+// Get - Get single alert by ID
+func (aj *AlertJournal) Get(id AlertID) (Alert, error) {
+	if id > 0 {
+		if found := aj.filter(id-1, 1, func(a Alert) bool { return a.ID == id }); len(found) > 0 {
+			return found[0], nil
+		}
+	}
+
+	return Alert{}, fmt.Errorf("No alert with ID %d", id)
+}
+
+// indexAfter - first entry with ID greater than id, entries are in ID order
+func (aj *AlertJournal) indexAfter(id AlertID) int {
+	lo, hi := 0, len(aj.entries)
+	for lo < hi {
+		mid := (lo + hi) / 2
+		if aj.entries[mid].ID <= id {
+			lo = mid + 1
+		} else {
+			hi = mid
+		}
+	}
+	return lo
+}
+
+// Since - Every alert after ID used to resume after a restart
+func (aj *AlertJournal) Since(id AlertID) []Alert {
+	return aj.Query(AlertQuery{AfterID: id})
+}
+
+// Recent - Most recent alerts oldest first
+func (aj *AlertJournal) Recent(num int) []Alert {
+	if num <= 0 {
+		return []Alert{}
+	}
+	return aj.Query(AlertQuery{Limit: num})
+}
+
+// Matches - Checks if alert passes the query, Limit is ignored
+func (q *AlertQuery) Matches(a Alert) bool {
+	if a.ID <= q.AfterID {
+		return false
+	}
+	if !q.From.IsZero() && a.Time.Before(q.From) {
+		return false
+	}
+	if !q.To.IsZero() && a.Time.After(q.To) {
+		return false
+	}
+	if q.Source != "" && nickKey(a.Source) != nickKey(q.Source) {
+		return false
+	}
+	if len(q.Types) > 0 {
+		for _, t := range q.Types {
+			if t == a.Type {
+				return true
+			}
+		}
+		return false
+	}
+
+	return true
+}
+
+// Query - Alerts matching types and time range oldest first
+func (aj *AlertJournal) Query(q AlertQuery) []Alert {
+	return aj.filter(q.AfterID, q.Limit, q.Matches)
+}
+
+// filter - Matching alerts after ID oldest first, the file is only read when memory has too few
+// match is always called with the read lock held
+func (aj *AlertJournal) filter(afterID AlertID, limit int, match func(Alert) bool) []Alert {
+	aj.lock.RLock()
+	filename, before := aj.filename, aj.firstID()
+	retList := []Alert{}
+	for _, a := range aj.entries[aj.indexAfter(afterID):] {
+		if match(a) {
+			retList = append(retList, a)
+		}
+	}
+	aj.lock.RUnlock()
+
+	if limit <= 0 || len(retList) < limit {
+		olderList := readOlder(filename, afterID, before)
+		if len(olderList) > 0 {
+			matched := []Alert{}
+			aj.lock.RLock()
+			for _, a := range olderList {
+				if match(a) {
+					matched = append(matched, a)
+				}
+			}
+			aj.lock.RUnlock()
+			retList = append(matched, retList...)
+		}
+	}
+
+	if limit > 0 && len(retList) > limit {
+		retList = retList[len(retList)-limit:]
+	}
+
+	return retList
+}
+
+// Ack - Consumer acknowledges it has displayed the alerts
+func (aj *AlertJournal) Ack(consumer string, ids ...AlertID) error {
+	aj.lock.Lock()
+	defer aj.lock.Unlock()
+
+	now := time.Now()
+	for _, id := range ids {
+		if id == 0 || id > aj.lastID {
+			return fmt.Errorf("Cannot ack unknown alert %d", id)
+		}
+
+		rec := journalRecord{Ack: &journalAck{Consumer: consumer, ID: id, Time: now}}
+		err := aj.write(rec)
+		if err != nil {
+			return err
+		}
+		aj.apply(rec)
+	}
+
+	return nil
+}
+
+// IsAcked - Has consumer acknowledged alert
+func (aj *AlertJournal) IsAcked(consumer string, id AlertID) bool {
+	aj.lock.RLock()
+	defer aj.lock.RUnlock()
+
+	_, ok := aj.acks[consumer][id]
+	return ok
+}
+
+// Unacked - Alerts after ID the consumer has not acknowledged
+func (aj *AlertJournal) Unacked(consumer string, since AlertID) []Alert {
+	return aj.filter(since, 0, func(a Alert) bool {
+		_, ok := aj.acks[consumer][a.ID]
+		return !ok
+	})
+}
+
+// Close - Close the journal file
+func (aj *AlertJournal) Close() error {
+	aj.lock.Lock()
+	defer aj.lock.Unlock()
+
+	if aj.file == nil {
+		return nil
+	}
+
+	err := aj.file.Close()
+	aj.file = nil
+	return err
+}
@@ -0,0 +1,498 @@
+package twitch
+
+import (
+	"fmt"
+	"log"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	alertQueueHistory = 50
+)
+
+// AlertDisplay - An alert or coalesced burst of alerts being shown on stream
+type AlertDisplay struct {
+	Type     AlertType     `json:"type"`
+	Alerts   []Alert       `json:"alerts"`
+	Text     string        `json:"text"`
+	Duration time.Duration `json:"duration"`
+	Queued   time.Time     `json:"queued"`
+	Started  time.Time     `json:"started"`
+	Replay   bool          `json:"replay"`
+}
+
+// Count - Number of alerts in display
+func (ad *AlertDisplay) Count() int {
+	return len(ad.Alerts)
+}
+
+func (ad *AlertDisplay) updateText() {
+	if len(ad.Alerts) == 1 {
+		a := ad.Alerts[0]
+		switch a.Type {
+		case AlertFollow:
+			ad.Text = fmt.Sprintf("%s followed", a.Source)
+		case AlertSub:
+			ad.Text = fmt.Sprintf("%s subscribed", a.Source)
+		case AlertHost:
+			if n := a.HostViewers(); n > 0 {
+				ad.Text = fmt.Sprintf("%s is hosting with %d viewers", a.Source, n)
+			} else {
+				ad.Text = fmt.Sprintf("%s is hosting", a.Source)
+			}
+		case AlertBits:
+			ad.Text = fmt.Sprintf("%s cheered %d bits", a.Source, a.Bits())
+		default:
+			ad.Text = a.String()
+		}
+		return
+	}
+
+	switch ad.Type {
+	case AlertFollow:
+		ad.Text = fmt.Sprintf("%d new followers", len(ad.Alerts))
+	case AlertSub:
+		ad.Text = fmt.Sprintf("%d new subs", len(ad.Alerts))
+	case AlertHost:
+		ad.Text = fmt.Sprintf("%d new hosts", len(ad.Alerts))
+	case AlertBits:
+		total := 0
+		for _, a := range ad.Alerts {
+			total += a.Bits()
+		}
+		ad.Text = fmt.Sprintf("%d cheers for %d bits", len(ad.Alerts), total)
+	default:
+		ad.Text = fmt.Sprintf("%d %s alerts", len(ad.Alerts), ad.Alerts[0].NameString())
+	}
+}
+
+// AlertQueueConfig - Pacing rules for the alert queue
+type AlertQueueConfig struct {
+	Durations        map[AlertType]time.Duration // Only types listed here are shown
+	Priority         map[AlertType]int           // Higher is shown first
+	Coalesce         map[AlertType]bool          // Types which merge into a single display
+	CoalesceWindow   time.Duration               // Max gap from first alert of a burst
+	DurationPerExtra time.Duration               // Extra time per coalesced alert
+	MaxDuration      time.Duration               // Cap for coalesced displays
+	AckAs            string                      // Journal consumer to ack once shown, empty disables
+}
+
+// DefaultAlertQueueConfig - Sensible overlay pacing, bits over subs over hosts over follows
+func DefaultAlertQueueConfig() AlertQueueConfig {
+	return AlertQueueConfig{
+		Durations: map[AlertType]time.Duration{
+			AlertBits:   time.Second * 8,
+			AlertSub:    time.Second * 8,
+			AlertHost:   time.Second * 6,
+			AlertFollow: time.Second * 4,
+		},
+		Priority: map[AlertType]int{
+			AlertBits:   40,
+			AlertSub:    30,
+			AlertHost:   20,
+			AlertFollow: 10,
+		},
+		Coalesce: map[AlertType]bool{
+			AlertFollow: true,
+			AlertSub:    true,
+		},
+		CoalesceWindow:   time.Second * 10,
+		DurationPerExtra: time.Second,
+		MaxDuration:      time.Second * 12,
+	}
+}
+
+type alertQueueCmd int
+
+const (
+	alertQueuePause alertQueueCmd = iota
+	alertQueueResume
+	alertQueueSkip
+	alertQueueReplay
+)
+
+type alertQueueCmdMsg struct {
+	cmd alertQueueCmd
+	arg int
+}
+
+type subToAlertQueue struct {
+	Name    string
+	C       chan AlertDisplay
+	Options SubOptions
+
+	counters *subCounters
+}
+
+func (sub *subToAlertQueue) deliver(ad AlertDisplay) bool {
+	return deliverTo(sub.C, ad, sub.Options, sub.counters)
+}
+
+// AlertQueue - Paced one at a time display of alerts for on-stream overlays
+type AlertQueue struct {
+	config   AlertQueueConfig
+	pump     *AlertPump
+	src      chan Alert
+	cmds     chan alertQueueCmdMsg
+	killSubs chan chan AlertDisplay
+
+	lock      sync.Mutex
+	pending   []*AlertDisplay
+	showing   *AlertDisplay
+	remaining time.Duration
+	endsAt    time.Time
+	paused    bool
+	history   []AlertDisplay
+	subs      []*subToAlertQueue
+}
+
+// CreateAlertQueue - Create queue fed from the pump and start pacing
+func CreateAlertQueue(pump *AlertPump, config AlertQueueConfig) *AlertQueue {
+	types := []AlertType{}
+	for t := range config.Durations {
+		types = append(types, t)
+	}
+
+	aq := AlertQueue{
+		config:   config,
+		pump:     pump,
+		cmds:     make(chan alertQueueCmdMsg, 10),
+		killSubs: make(chan chan AlertDisplay, 10),
+		pending:  []*AlertDisplay{},
+		history:  []AlertDisplay{},
+	}
+
+	aq.src = pump.SubFiltered("alert-queue", AlertFilter{Types: types},
+		SubOptions{BufferSize: 100, Overflow: OverflowBlock, BlockTimeout: time.Second})
+
+	go aq.run()
+
+	return &aq
+}
+
+func (aq *AlertQueue) run() {
+	timer := time.NewTimer(time.Hour)
+	stopTimer(timer)
+	timerRunning := false
+
+	for {
+		select {
+		case a, ok := <-aq.src:
+			if !ok {
+				log.Println("Alert Queue source closed")
+				aq.closeSubs()
+				return
+			}
+			aq.add(a)
+
+		case c := <-aq.cmds:
+			aq.handleCmd(c)
+			if c.cmd == alertQueueSkip || c.cmd == alertQueuePause {
+				stopTimer(timer)
+				timerRunning = false
+			}
+
+		case deadChan := <-aq.killSubs:
+			aq.removeSub(deadChan)
+
+		case <-timer.C:
+			timerRunning = false
+			aq.finishShowing()
+		}
+
+		// Advance Queue
+		var started *AlertDisplay
+		aq.lock.Lock()
+		if !aq.paused {
+			if aq.showing == nil && len(aq.pending) > 0 {
+				started = aq.startNext()
+			}
+
+			if aq.showing != nil && !timerRunning {
+				aq.endsAt = time.Now().Add(aq.remaining)
+				timer.Reset(aq.remaining)
+				timerRunning = true
+			}
+		}
+		aq.lock.Unlock()
+
+		if started != nil {
+			aq.emit(*started)
+		}
+	}
+}
+
+func stopTimer(t *time.Timer) {
+	if !t.Stop() {
+		select {
+		case <-t.C:
+		default:
+		}
+	}
+}
+
+func (aq *AlertQueue) add(a Alert) {
+	aq.lock.Lock()
+	defer aq.lock.Unlock()
+
+	if aq.config.Coalesce[a.Type] {
+		for _, ad := range aq.pending {
+			if ad.Type == a.Type && !ad.Replay && a.Time.Sub(ad.Queued) < aq.config.CoalesceWindow {
+				ad.Alerts = append(ad.Alerts, a)
+				ad.Duration = aq.durationFor(a.Type, len(ad.Alerts))
+				ad.updateText()
+				return
+			}
+		}
+	}
+
+	ad := &AlertDisplay{
+		Type:     a.Type,
+		Alerts:   []Alert{a},
+		Duration: aq.durationFor(a.Type, 1),
+		Queued:   a.Time,
+	}
+	if ad.Queued.IsZero() {
+		ad.Queued = time.Now()
+	}
+	ad.updateText()
+
+	aq.pending = append(aq.pending, ad)
+	aq.sortPending()
+}
+
+func (aq *AlertQueue) durationFor(t AlertType, num int) time.Duration {
+	d := aq.config.Durations[t] + time.Duration(num-1)*aq.config.DurationPerExtra
+	if aq.config.MaxDuration > 0 && d > aq.config.MaxDuration {
+		d = aq.config.MaxDuration
+	}
+	return d
+}
+
+func (aq *AlertQueue) sortPending() {
+	sort.SliceStable(aq.pending, func(i, j int) bool {
+		pi := aq.config.Priority[aq.pending[i].Type]
+		pj := aq.config.Priority[aq.pending[j].Type]
+		if pi != pj {
+			return pi > pj
+		}
+		return aq.pending[i].Queued.Before(aq.pending[j].Queued)
+	})
+}
+
+// startNext - Must hold lock, returns a copy of the display to emit once unlocked
+func (aq *AlertQueue) startNext() *AlertDisplay {
+	aq.showing = aq.pending[0]
+	aq.pending = aq.pending[1:]
+	aq.showing.Started = time.Now()
+	aq.remaining = aq.showing.Duration
+
+	ad := *aq.showing
+	return &ad
+}
+
+func (aq *AlertQueue) finishShowing() {
+	aq.lock.Lock()
+	defer aq.lock.Unlock()
+
+	if aq.showing == nil {
+		return
+	}
+
+	done := *aq.showing
+	aq.showing = nil
+
+	aq.history = append(aq.history, done)
+	if len(aq.history) > alertQueueHistory {
+		aq.history = aq.history[1:]
+	}
+
+	if aq.config.AckAs != "" && !done.Replay {
+		ids := []AlertID{}
+		for _, a := range done.Alerts {
+			if a.ID > 0 {
+				ids = append(ids, a.ID)
+			}
+		}
+		if err := aq.pump.Journal.Ack(aq.config.AckAs, ids...); err != nil {
+			log.Printf("Alert Queue failed to ack %v\n%s", ids, err)
+		}
+	}
+}
+
+func (aq *AlertQueue) handleCmd(c alertQueueCmdMsg) {
+	switch c.cmd {
+	case alertQueuePause:
+		aq.lock.Lock()
+		if !aq.paused && aq.showing != nil {
+			aq.remaining = aq.endsAt.Sub(time.Now())
+			if aq.remaining < 0 {
+				aq.remaining = 0
+			}
+		}
+		aq.paused = true
+		aq.lock.Unlock()
+
+	case alertQueueResume:
+		aq.lock.Lock()
+		aq.paused = false
+		aq.lock.Unlock()
+
+	case alertQueueSkip:
+		aq.finishShowing()
+
+	case alertQueueReplay:
+		aq.lock.Lock()
+		i := len(aq.history) - 1 - c.arg
+		if i >= 0 && i < len(aq.history) {
+			ad := aq.history[i]
+			ad.Replay = true
+			ad.Started = time.Time{}
+			aq.pending = append([]*AlertDisplay{&ad}, aq.pending...)
+		}
+		aq.lock.Unlock()
+	}
+}
+
+// Pause - Freeze the current display and stop starting new ones
+func (aq *AlertQueue) Pause() { aq.cmds <- alertQueueCmdMsg{cmd: alertQueuePause} }
+
+// Resume - Continue after Pause
+func (aq *AlertQueue) Resume() { aq.cmds <- alertQueueCmdMsg{cmd: alertQueueResume} }
+
+// Skip - End the current display now
+func (aq *AlertQueue) Skip() { aq.cmds <- alertQueueCmdMsg{cmd: alertQueueSkip} }
+
+// Replay - Show a previous display again next, 0 is the most recent
+func (aq *AlertQueue) Replay(stepsBack int) {
+	aq.cmds <- alertQueueCmdMsg{cmd: alertQueueReplay, arg: stepsBack}
+}
+
+// IsPaused - Is the queue paused
+func (aq *AlertQueue) IsPaused() bool {
+	aq.lock.Lock()
+	defer aq.lock.Unlock()
+	return aq.paused
+}
+
+// Showing - Current display if any
+func (aq *AlertQueue) Showing() *AlertDisplay {
+	aq.lock.Lock()
+	defer aq.lock.Unlock()
+
+	if aq.showing == nil {
+		return nil
+	}
+	ad := *aq.showing
+	return &ad
+}
+
+// Pending - Copy of displays waiting in order
+func (aq *AlertQueue) Pending() []AlertDisplay {
+	aq.lock.Lock()
+	defer aq.lock.Unlock()
+
+	retList := make([]AlertDisplay, len(aq.pending))
+	for i, ad := range aq.pending {
+		retList[i] = *ad
+	}
+	return retList
+}
+
+// History - Copy of finished displays oldest first
+func (aq *AlertQueue) History() []AlertDisplay {
+	aq.lock.Lock()
+	defer aq.lock.Unlock()
+
+	retList := make([]AlertDisplay, len(aq.history))
+	copy(retList, aq.history)
+	return retList
+}
+
+// Stop - Unsubscribe from the pump which ends the queue
+func (aq *AlertQueue) Stop() {
+	aq.pump.Unsub(aq.src)
+}
+
+// Sub - Subscribe to now showing events
+func (aq *AlertQueue) Sub(subName string) chan AlertDisplay {
+	return aq.SubWithOptions(subName, DefaultSubOptions())
+}
+
+// SubWithOptions - Subscribe to now showing events with buffer size and overflow policy
+func (aq *AlertQueue) SubWithOptions(subName string, opts SubOptions) chan AlertDisplay {
+	opts = opts.sanitise()
+	newSub := make(chan AlertDisplay, opts.BufferSize)
+
+	aq.lock.Lock()
+	aq.subs = append(aq.subs, &subToAlertQueue{
+		Name:    subName,
+		C:       newSub,
+		Options: opts,
+
+		counters: newSubCounters(),
+	})
+	aq.lock.Unlock()
+
+	return newSub
+}
+
+// Unsub - Kill a now showing subscription
+func (aq *AlertQueue) Unsub(deadChannel chan AlertDisplay) {
+	aq.killSubs <- deadChannel
+}
+
+// removeSub - Remove and close subscription, only called from run so nothing is sending to it
+func (aq *AlertQueue) removeSub(deadChan chan AlertDisplay) {
+	aq.lock.Lock()
+	defer aq.lock.Unlock()
+
+	subs := []*subToAlertQueue{}
+	for _, sub := range aq.subs {
+		if sub.C == deadChan {
+			close(sub.C)
+		} else {
+			subs = append(subs, sub)
+		}
+	}
+	aq.subs = subs
+}
+
+// emit - Deliver without the lock so a blocking subscriber never holds up the queue
+func (aq *AlertQueue) emit(ad AlertDisplay) {
+	aq.lock.Lock()
+	subs := append([]*subToAlertQueue{}, aq.subs...)
+	aq.lock.Unlock()
+
+	for _, sub := range subs {
+		if !sub.deliver(ad) {
+			log.Printf("Alert Queue subscriber %s overflowed and was disconnected", sub.Name)
+			aq.removeSub(sub.C)
+		}
+	}
+}
+
+// Subscribers - Diagnostic snapshot of now showing subscribers
+func (aq *AlertQueue) Subscribers() []SubStats {
+	aq.lock.Lock()
+	defer aq.lock.Unlock()
+
+	sList := make([]SubStats, 0, len(aq.subs))
+	for _, sub := range aq.subs {
+		sList = append(sList, sub.counters.stats(sub.Name, sub.Options, len(sub.C)))
+	}
+
+	return sList
+}
+
+func (aq *AlertQueue) closeSubs() {
+	aq.lock.Lock()
+	defer aq.lock.Unlock()
+
+	for _, sub := range aq.subs {
+		close(sub.C)
+	}
+	aq.subs = nil
+}
@@ -0,0 +1,213 @@
+package twitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func testAlertQueue(t *testing.T) (*AlertPump, *AlertQueue, chan AlertDisplay) {
+	cfg := DefaultAlertQueueConfig()
+	cfg.Durations = map[AlertType]time.Duration{
+		AlertBits:   time.Millisecond * 150,
+		AlertHost:   time.Millisecond * 100,
+		AlertFollow: time.Millisecond * 50,
+	}
+	cfg.Coalesce = map[AlertType]bool{}
+	cfg.AckAs = "overlay"
+
+	pump := StartAlertPump(nil)
+	aq := CreateAlertQueue(pump, cfg)
+	waitForSubs(pump, 1)
+
+	return pump, aq, aq.Sub("overlay")
+}
+
+func waitFor(t *testing.T, what string, fn func() bool) {
+	for i := 0; i < 200; i++ {
+		if fn() {
+			return
+		}
+		time.Sleep(time.Millisecond * 5)
+	}
+	t.Fatalf("Timed out waiting for %s", what)
+}
+
+func nextDisplay(t *testing.T, c chan AlertDisplay) AlertDisplay {
+	select {
+	case ad := <-c:
+		return ad
+	case <-time.After(time.Second):
+		t.Fatal("No display started")
+	}
+	return AlertDisplay{}
+}
+
+func TestAlertQueuePacing(t *testing.T) {
+	pump, aq, shown := testAlertQueue(t)
+	defer aq.Stop()
+
+	// Queue up while paused so priority decides the order
+	aq.Pause()
+	waitFor(t, "pause", aq.IsPaused)
+	pump.Post("fred", AlertFollow, time.Now())
+	pump.Post("wilma", AlertHost, 10)
+	pump.Post("ronni", AlertBits, MakeLogLineMsg(LogCatMsg, LogLineParsedMsg{Nick: "ronni", Bits: 100, Content: "cheer100"}))
+	pump.Post("dino", AlertWhisper, "not shown")
+	waitFor(t, "pending", func() bool { return len(aq.Pending()) == 3 })
+
+	if ad := aq.Showing(); ad != nil {
+		t.Errorf("Showing while paused: %+v", ad)
+	}
+
+	aq.Resume()
+	order := []AlertType{}
+	starts := []time.Time{}
+	for i := 0; i < 3; i++ {
+		ad := nextDisplay(t, shown)
+		order = append(order, ad.Type)
+		starts = append(starts, time.Now())
+	}
+
+	if fmt.Sprint(order) != fmt.Sprint([]AlertType{AlertBits, AlertHost, AlertFollow}) {
+		t.Errorf("Priority order wrong: %v", order)
+	}
+	if gap := starts[1].Sub(starts[0]); gap < time.Millisecond*120 {
+		t.Errorf("Bits only showed for %s", gap)
+	}
+	if gap := starts[2].Sub(starts[1]); gap < time.Millisecond*70 {
+		t.Errorf("Host only showed for %s", gap)
+	}
+
+	waitFor(t, "history", func() bool { return len(aq.History()) == 3 })
+	for _, ad := range aq.History() {
+		if !pump.Journal.IsAcked("overlay", ad.Alerts[0].ID) {
+			t.Errorf("%s not acked once shown", ad.Text)
+		}
+	}
+}
+
+func TestAlertQueueControl(t *testing.T) {
+	pump, aq, shown := testAlertQueue(t)
+	defer aq.Stop()
+
+	pump.Post("ronni", AlertBits, MakeLogLineMsg(LogCatMsg, LogLineParsedMsg{Nick: "ronni", Bits: 5, Content: "cheer5"}))
+	first := nextDisplay(t, shown)
+
+	// Paused part way the display keeps its remaining time
+	time.Sleep(time.Millisecond * 50)
+	aq.Pause()
+	waitFor(t, "pause", aq.IsPaused)
+	time.Sleep(time.Millisecond * 200)
+	if ad := aq.Showing(); ad == nil || len(aq.History()) != 0 {
+		t.Fatalf("Display ended while paused")
+	}
+
+	resumed := time.Now()
+	aq.Resume()
+	waitFor(t, "finish", func() bool { return len(aq.History()) == 1 })
+	if took := time.Since(resumed); took < time.Millisecond*50 || took > time.Millisecond*140 {
+		t.Errorf("Remaining time after resume was %s", took)
+	}
+
+	// Skip ends the display now
+	pump.Post("wilma", AlertHost, 10)
+	nextDisplay(t, shown)
+	aq.Skip()
+	waitFor(t, "skip", func() bool { return len(aq.History()) == 2 && aq.Showing() == nil })
+
+	// Replay shows the older display again without acking it twice
+	aq.Replay(1)
+	replay := nextDisplay(t, shown)
+	if !replay.Replay || replay.Text != first.Text {
+		t.Errorf("Replay wrong: %+v", replay)
+	}
+	waitFor(t, "replay finish", func() bool { return len(aq.History()) == 3 })
+}
+
+func TestAlertQueueEmit(t *testing.T) {
+	pump, aq, shown := testAlertQueue(t)
+	defer aq.Stop()
+
+	discon := aq.SubWithOptions("discon", SubOptions{BufferSize: 1, Overflow: OverflowDisconnect})
+	blocked := aq.SubWithOptions("blocked", SubOptions{BufferSize: 1, Overflow: OverflowBlock, BlockTimeout: time.Millisecond * 300})
+
+	for i := 0; i < 2; i++ {
+		pump.Post(IrcNick(fmt.Sprintf("follower%d", i)), AlertFollow, time.Now())
+	}
+	nextDisplay(t, shown)
+	nextDisplay(t, shown)
+
+	// Queue state is readable while the second display waits on the blocked subscriber
+	start := time.Now()
+	aq.Pending()
+	if took := time.Since(start); took > time.Millisecond*100 {
+		t.Errorf("Pending waited %s behind a blocked subscriber", took)
+	}
+
+	<-discon
+	waitFor(t, "disconnect", func() bool { return len(aq.Subscribers()) == 2 })
+	if _, ok := <-discon; ok {
+		t.Error("Disconnect policy left channel open")
+	}
+
+	<-blocked
+	aq.Unsub(blocked)
+	waitFor(t, "unsub", func() bool { return len(aq.Subscribers()) == 1 })
+	for range blocked {
+	}
+}
+
+func TestAlertQueueAPI(t *testing.T) {
+	ah := &Client{Config: DefaultConfig(), RoomName: "kimau"}
+	ah.Alerts, ah.AlertQueue, _ = testAlertQueue(t)
+	defer ah.AlertQueue.Stop()
+
+	call := func(method, path string) (int, APIAlertQueue) {
+		req := httptest.NewRequest(method, "/admin/"+path, nil)
+		rec := httptest.NewRecorder()
+		ah.apiRoute(rec, req, req.URL.Path[len("/admin/"):], ViewerData{TwitchID: "1", User: &User{ID: "1", Name: "kimau"}}, RoleMod)
+		res := APIAlertQueue{}
+		json.Unmarshal(rec.Body.Bytes(), &res)
+		return rec.Code, res
+	}
+
+	if code, res := call("POST", "alerts/queue/pause"); code != http.StatusAccepted {
+		t.Errorf("Pause gave %d %+v", code, res)
+	}
+	waitFor(t, "pause", ah.AlertQueue.IsPaused)
+
+	ah.Alerts.Post("fred", AlertFollow, time.Now())
+	waitFor(t, "pending", func() bool { return len(ah.AlertQueue.Pending()) == 1 })
+	if code, res := call("GET", "alerts/queue"); code != http.StatusOK || !res.Paused || len(res.Pending) != 1 || res.Showing != nil {
+		t.Errorf("Queue state wrong %d %+v", code, res)
+	}
+
+	if code, _ := call("POST", "alerts/queue/replay?back=0"); code != http.StatusNotFound {
+		t.Errorf("Replay with no history gave %d", code)
+	}
+
+	call("POST", "alerts/queue/resume")
+	waitFor(t, "showing", func() bool { return ah.AlertQueue.Showing() != nil })
+	call("POST", "alerts/queue/skip")
+	waitFor(t, "skip", func() bool { return len(ah.AlertQueue.History()) == 1 })
+
+	if code, _ := call("POST", "alerts/queue/replay?back=x"); code != http.StatusBadRequest {
+		t.Errorf("Bad replay gave %d", code)
+	}
+	if code, _ := call("POST", "alerts/queue/replay"); code != http.StatusAccepted {
+		t.Errorf("Replay gave %d", code)
+	}
+	waitFor(t, "replay", func() bool {
+		ad := ah.AlertQueue.Showing()
+		return ad != nil && ad.Replay
+	})
+
+	ah.AlertQueue = nil
+	if code, _ := call("GET", "alerts/queue"); code != http.StatusServiceUnavailable {
+		t.Errorf("Disabled queue gave %d", code)
+	}
+}
@@ -0,0 +1,270 @@
+package twitch
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestAlertFilter(t *testing.T) {
+	bitsAlert := Alert{Type: AlertBits, Source: "ronni",
+		Data: MakeLogLineMsg(LogCatMsg, LogLineParsedMsg{Nick: "ronni", Bits: 50, Content: "cheer50"})}
+	hostAlert := Alert{Type: AlertHost, Source: "obezianka", Data: 3}
+	heartHostAlert := Alert{Type: AlertHost, Source: "groundz3r0", Data: HostData{HostLogin: "groundz3r0"}}
+	beatAlert := Alert{Type: AlertNone, Source: "kimau", Data: HeartbeatData{}}
+
+	tests := []struct {
+		name   string
+		filter AlertFilter
+		alert  Alert
+		want   bool
+	}{
+		{"empty", AlertFilter{}, beatAlert, true},
+		{"type", AlertFilter{Types: []AlertType{AlertBits, AlertHost}}, beatAlert, false},
+		{"source", AlertFilter{Sources: []IrcNick{"Ronni"}}, bitsAlert, true},
+		{"min bits", AlertFilter{MinBits: 100}, bitsAlert, false},
+		{"min host", AlertFilter{MinHostViewers: 2}, hostAlert, true},
+		{"small host", AlertFilter{MinHostViewers: 5}, hostAlert, false},
+		{"heartbeat host", AlertFilter{MinHostViewers: 5}, heartHostAlert, true},
+		{"predicate", AlertFilter{Match: func(a Alert) bool { return a.Source == "fred" }}, hostAlert, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.filter.Allows(tt.alert); got != tt.want {
+			t.Errorf("%s: Allows = %t want %t", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestChatFilter(t *testing.T) {
+	llp := MakeLogLineMsg(LogCatMsg, LogLineParsedMsg{
+		Nick:    "vishthemexican",
+		Content: "i never knew",
+		Badges:  ChatBadges{"moderator": "1"},
+	})
+
+	tests := []struct {
+		name   string
+		filter ChatFilter
+		want   bool
+	}{
+		{"empty", ChatFilter{}, true},
+		{"user", ChatFilter{Users: []IrcNick{"ronni"}}, false},
+		{"badge", ChatFilter{Badges: []string{"subscriber", "moderator"}}, true},
+		{"pattern", ChatFilter{Pattern: regexp.MustCompile("^!")}, false},
+		{"bits", ChatFilter{MinBits: 1}, false},
+	}
+
+	for _, tt := range tests {
+		if got := tt.filter.Allows(llp); got != tt.want {
+			t.Errorf("%s: Allows = %t want %t", tt.name, got, tt.want)
+		}
+	}
+
+	system := MakeLogLine(LogCatSystem, "Join ronni")
+	if (&ChatFilter{Users: []IrcNick{"ronni"}}).Allows(system) {
+		t.Error("User filter should not match system lines")
+	}
+}
+
+func TestAlertJournal(t *testing.T) {
+	filename := "./data/journal_test.log"
+	os.Remove(filename)
+
+	aj, err := OpenAlertJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bits := MakeLogLineMsg(LogCatMsg, LogLineParsedMsg{Nick: "ronni", Bits: 100, Content: "cheer100"})
+	first, err := aj.Append(Alert{Type: AlertBits, Source: "ronni", Data: bits})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = aj.Append(Alert{Type: AlertFollow, Source: "fred", Data: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = aj.Append(Alert{Type: AlertBits, Source: "ronni", Data: bits}); err == nil {
+		t.Error("Duplicate bits alert was accepted")
+	}
+	if err = aj.Ack("overlay", first.ID); err != nil {
+		t.Fatal(err)
+	}
+	aj.Close()
+
+	if fi, err := os.Stat(filename); err != nil || fi.Mode().Perm() != secretFileMode {
+		t.Errorf("Journal should be private: %v", fi)
+	}
+
+	// Reload and resume
+	aj, err = OpenAlertJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aj.Close()
+
+	if aj.LastID() != 2 {
+		t.Errorf("LastID %d expected 2", aj.LastID())
+	}
+
+	unacked := aj.Unacked("overlay", 0)
+	if len(unacked) != 1 || unacked[0].Type != AlertFollow {
+		t.Errorf("Unacked after reload %v", unacked)
+	}
+
+	reloaded, err := aj.Get(first.ID)
+	if err != nil || reloaded.Bits() != 100 {
+		t.Errorf("Reloaded bits alert lost data: %v %s", reloaded, err)
+	}
+
+	if _, err = aj.Append(Alert{Type: AlertBits, Source: "ronni", Data: bits}); err == nil {
+		t.Error("Duplicate detection did not survive reload")
+	}
+
+	// Repeat cheers are separate events, redelivery of the same event is not
+	if _, err = aj.Append(Alert{Type: AlertBits, Source: "ronni", EventID: "a1", Data: bits}); err != nil {
+		t.Error(err)
+	}
+	if _, err = aj.Append(Alert{Type: AlertBits, Source: "ronni", EventID: "a2", Data: bits}); err != nil {
+		t.Errorf("Repeat cheer rejected: %s", err)
+	}
+	if _, err = aj.Append(Alert{Type: AlertBits, Source: "ronni", EventID: "a1", Data: bits}); err == nil {
+		t.Error("Same event accepted twice")
+	}
+	if _, err = aj.Append(Alert{Type: AlertFollow, Source: "fred", Time: time.Now().Add(aj.DupeWindow * 2), Data: time.Now()}); err != nil {
+		t.Errorf("Alert outside the dupe window rejected: %s", err)
+	}
+
+	if q := aj.Query(AlertQuery{Types: []AlertType{AlertFollow}}); len(q) != 2 {
+		t.Errorf("Query by type returned %d", len(q))
+	}
+	if s := aj.Since(first.ID); len(s) != 4 || s[0].ID != 2 {
+		t.Errorf("Since returned %v", s)
+	}
+}
+
+func TestAlertJournalHistory(t *testing.T) {
+	filename := "./data/journal_history_test.log"
+	os.Remove(filename)
+	defer os.Remove(filename)
+
+	aj, err := OpenAlertJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aj.Close()
+	aj.keep = 3
+
+	for i := 0; i < 10; i++ {
+		src := IrcNick(fmt.Sprintf("viewer%d", i%2))
+		if _, err = aj.Append(Alert{Type: AlertFollow, Source: src, EventID: fmt.Sprint(i), Data: time.Now()}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if len(aj.entries) != 3 {
+		t.Errorf("Memory holds %d alerts expected 3", len(aj.entries))
+	}
+	if err = aj.Ack("overlay", 2, 9); err != nil {
+		t.Fatal(err)
+	}
+
+	if a, err := aj.Get(1); err != nil || a.EventID != "0" {
+		t.Errorf("Old alert not read from file: %v %s", a, err)
+	}
+	if s := aj.Since(4); len(s) != 6 || s[0].ID != 5 || s[5].ID != 10 {
+		t.Errorf("Since across file and memory %v", s)
+	}
+	if r := aj.Recent(5); len(r) != 5 || r[0].ID != 6 {
+		t.Errorf("Recent across file and memory %v", r)
+	}
+	if q := aj.Query(AlertQuery{Source: "viewer1", Limit: 4}); len(q) != 4 || q[0].ID != 4 || q[3].ID != 10 {
+		t.Errorf("Query across file and memory %v", q)
+	}
+	if u := aj.Unacked("overlay", 0); len(u) != 8 {
+		t.Errorf("Unacked across file and memory %d", len(u))
+	}
+
+	// Memory only journals forget
+	mj, _ := OpenAlertJournal("")
+	mj.keep = 2
+	for i := 0; i < 4; i++ {
+		mj.Append(Alert{Type: AlertFollow, Source: "fred", EventID: fmt.Sprint(i)})
+	}
+	if s := mj.Since(0); len(s) != 2 || s[0].ID != 3 {
+		t.Errorf("Memory journal history %v", s)
+	}
+}
+
+func TestAlertJournalTornLine(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "journal.log")
+
+	aj, err := OpenAlertJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = aj.Append(Alert{Type: AlertFollow, Source: "fred", EventID: "f1", Data: time.Now()}); err != nil {
+		t.Fatal(err)
+	}
+	aj.Close()
+
+	// Crash part way through writing the second alert
+	f, err := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, secretFileMode)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"alert":{"id":2,"type":`)
+	f.Close()
+
+	aj, err = OpenAlertJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	posted, err := aj.Append(Alert{Type: AlertHost, Source: "wilma", EventID: "h1", Data: 5})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = aj.Ack("overlay", posted.ID); err != nil {
+		t.Fatal(err)
+	}
+	aj.Close()
+
+	aj, err = OpenAlertJournal(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer aj.Close()
+
+	if s := aj.Since(0); len(s) != 2 || s[1].Source != "wilma" || s[1].HostViewers() != 5 {
+		t.Errorf("Alert after a torn line lost: %v", s)
+	}
+	if !aj.IsAcked("overlay", posted.ID) {
+		t.Errorf("Ack after a torn line lost")
+	}
+}
+
+func TestAlertQueueCoalesce(t *testing.T) {
+	aq := &AlertQueue{config: DefaultAlertQueueConfig()}
+	now := time.Now()
+
+	for i, nick := range []IrcNick{"ronni", "fred", "wilma"} {
+		aq.add(Alert{Type: AlertFollow, Source: nick, Time: now.Add(time.Duration(i) * time.Second)})
+	}
+	aq.add(Alert{Type: AlertBits, Source: "pickles", Time: now.Add(time.Second * 3),
+		Data: MakeLogLineMsg(LogCatMsg, LogLineParsedMsg{Nick: "pickles", Bits: 100, Content: "cheer100"})})
+
+	pending := aq.Pending()
+	if len(pending) != 2 {
+		t.Fatalf("Expected 2 displays got %d", len(pending))
+	}
+
+	if pending[0].Type != AlertBits {
+		t.Errorf("Bits should be shown before follows got %s", pending[0].Text)
+	}
+
+	if pending[1].Count() != 3 || pending[1].Text != "3 new followers" {
+		t.Errorf("Follows not coalesced: %d %s", pending[1].Count(), pending[1].Text)
+	}
+}
@@ -3,13 +3,11 @@ package twitch
 import (
 	"encoding/json"
 	"fmt"
-	"log"
 	"net/http"
 	"net/url"
 	"regexp"
 	"strconv"
 	"strings"
-	"time"
 )
 
 var (
@@ -45,91 +43,100 @@ func (ah *Client) getRootToken(ua *UserAuth) error {
 }
 
 func (ah *Client) handleOAuthAdminStart(w http.ResponseWriter, req *http.Request) {
-
-	fullRedirStr := fmt.Sprintf(twitchAuthURL,
-		ah.ClientID,
-		fmt.Sprintf(redirStringURL, ah.domain),
-		mergeScopeString(DefaultStreamerScope),
-		ah.AdminAuth.InteralState)
-
-	http.Redirect(w, req, fullRedirStr, http.StatusSeeOther)
+	ah.beginOAuth(w, req, ah.requestedAdminScopes(req), true)
 }
 
 func (ah *Client) handleOAuthStart(w http.ResponseWriter, req *http.Request) {
-	myState := GenerateRandomString(16)
-	ah.PendingLogins[authInternalState(myState)] = time.Now()
-
-	fullRedirStr := fmt.Sprintf(twitchAuthURL,
-		ah.ClientID,
-		fmt.Sprintf(redirStringURL, ah.domain),
-		mergeScopeString(DefaultViewerScope),
-		myState)
-	http.Redirect(w, req, fullRedirStr, http.StatusSeeOther)
+	ah.beginOAuth(w, req, DefaultViewerScope, false)
 }
 
 func (ah *Client) handlePublicOAuthResult(w http.ResponseWriter, req *http.Request) {
 	qList := req.URL.Query()
 
-	c, ok := qList["code"]
+	stateList, ok := qList["state"]
 	if !ok {
-		s := fmt.Sprintf("Hello your auth was cancelled")
+		ah.oauthErrorPage(w, http.StatusBadRequest, "Invalid State", nil)
+		return
+	}
 
-		for k, v := range qList {
-			s += fmt.Sprintf("%s:%s\n", k, v)
-		}
-		http.Error(w, s, 400)
+	stateVal := authInternalState(stateList[0])
+	pl, err := ah.claimOAuthState(req, stateVal)
+	if err != nil {
+		ah.logf("OAuth state rejected: %s", err)
+		ah.oauthErrorPage(w, http.StatusBadRequest, "Login could not be verified", []string{err.Error()})
 		return
 	}
 
-	stateList, ok := qList["state"]
+	c, ok := qList["code"]
 	if !ok {
-		http.Error(w, "Invalid State", 400)
+		ah.oauthErrorPage(w, http.StatusBadRequest, "Hello your auth was cancelled",
+			[]string{qList.Get("error"), qList.Get("error_description")})
 		return
 	}
 
 	var authU *UserAuth
 	isAdmin := false
-	stateVal := authInternalState(stateList[0])
+	isReconsent := false
 	// Check if Admin Login
-	if (ah.AdminAuth.Token == nil) && stateVal == ah.AdminAuth.InteralState {
+	if pl.Admin && ah.AdminAuth.Token == nil {
 		authU = ah.AdminAuth
 		isAdmin = true
 	} else { // Normal user do logic
+		isReconsent = pl.Admin
 		authU = &UserAuth{
-			InteralState: stateVal,
-			Scopes:       make(map[string]bool),
+			Scopes: make(map[string]bool),
 		}
-
-		delete(ah.PendingLogins, stateVal)
 	}
+	authU.InteralState = stateVal
 
-	if authU == nil {
-		http.Error(w, "Invalid Auth State", 400)
-		return
-	}
-
-	scopeList, ok := qList["scope"]
-	if !ok {
-		http.Error(w, "Invalid Scope", 400)
-		return
-	}
-	scopeList = strings.Split(scopeList[0], " ")
-	log.Println(strings.Split(scopeList[0], "\n\t"))
+	scopeList := strings.Split(qList.Get("scope"), " ")
 
 	// Save State
 	authU.Token = nil
 	authU.updateScope(scopeList)
 	authU.IrcCode = c[0]
 
-	err := ah.handleOAuthResult(authU)
+	err = ah.handleOAuthResult(authU, pl.verifier)
 	if err != nil {
-		log.Println(err, authU)
-		http.Error(w, err.Error(), http.StatusInternalServerError)
+		ah.logf("%s %v", err, authU)
+		ah.oauthErrorPage(w, http.StatusBadGateway, "Twitch login failed", []string{err.Error()})
 		return
 	}
 
+	// Token scopes are authoritative when twitch returns them
+	if granted := authU.Token.AuthToken.ScopeList; len(granted) > 0 {
+		scopeList = granted
+		authU.updateScope(scopeList)
+	}
+
+	if missing := missingScopes(pl.Scopes, scopeList); len(missing) > 0 {
+		ah.logf("OAuth login %s missing scopes %v", authU.Token.Username, missing)
+		if isAdmin {
+			authU.Token = nil
+		}
+		details := []string{}
+		for _, m := range missing {
+			details = append(details, "Permission not granted: "+m)
+		}
+		ah.oauthErrorPage(w, http.StatusForbidden, "Some permissions were not granted", details)
+		return
+	}
+
+	http.SetCookie(w, ah.clearCookie(OAuthStateCookieName))
+
 	tID := authU.Token.UserID
-	if isAdmin {
+	if isReconsent {
+		err = ah.applyReconsent(authU)
+		if err != nil {
+			ah.logf("Re-consent rejected: %s", err)
+			ah.oauthErrorPage(w, http.StatusForbidden, "Re-consent failed", []string{err.Error()})
+			return
+		}
+
+		fmt.Fprintf(w, "Admin scopes updated %s #%s\n---Scope---\n\t%s\n---------\n",
+			authU.Token.Username, tID,
+			strings.Join(scopeList, "\n\t"))
+	} else if isAdmin {
 		if ah.AdminAuth.Token != nil {
 			err := ah.saveToken()
 			if err != nil {
@@ -138,6 +145,11 @@ func (ah *Client) handlePublicOAuthResult(w http.ResponseWriter, req *http.Reque
 
 			ah.adminHasAuthed()
 
+			// Admin is also a viewer so the session cookie opens the admin pages
+			if _, err = ah.loginViewer(w, req, tID, *authU); err != nil {
+				ah.logf("Admin session not started: %s", err)
+			}
+
 			fmt.Fprintf(w, "Admin logged in %s #%s\n---Scope---\n\t%s\n---------\n",
 				authU.Token.Username, tID,
 				strings.Join(scopeList, "\n\t"))
@@ -145,17 +157,18 @@ func (ah *Client) handlePublicOAuthResult(w http.ResponseWriter, req *http.Reque
 			http.Error(w, "Admin Auth has no token", 400)
 		}
 	} else {
-		v := ah.Viewers.GetPtr(tID)
-		v.SetAuth(*authU)
+		v, err := ah.loginViewer(w, req, tID, *authU)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
 
 		vd := v.GetData()
-
-		http.SetCookie(w, vd.Auth.createSessionCookie(ah.domain))
 		fmt.Fprintf(w, "Logged in %s #%s", vd.GetNick(), tID)
 	}
 }
 
-func (ah *Client) handleOAuthResult(authU *UserAuth) error {
+func (ah *Client) handleOAuthResult(authU *UserAuth, verifier string) error {
 
 	// Setup Payload
 	data := url.Values{}
@@ -165,12 +178,15 @@ func (ah *Client) handleOAuthResult(authU *UserAuth) error {
 	data.Set("redirect_uri", fmt.Sprintf(redirStringURL, ah.domain))
 	data.Set("code", authU.IrcCode)
 	data.Set("state", string(authU.InteralState))
+	if verifier != "" {
+		data.Set("code_verifier", verifier)
+	}
 	payload := strings.NewReader(data.Encode())
 
 	// Server get Auth Code
 	req, err := http.NewRequest("POST", "https://api.twitch.tv/kraken/oauth2/token", payload)
 	if err != nil {
-		log.Println("Failed to Build Request")
+		ah.logf("Failed to Build Request")
 		return err
 	}
 
@@ -182,7 +198,7 @@ func (ah *Client) handleOAuthResult(authU *UserAuth) error {
 
 	resp, err := ah.httpClient.Do(req)
 	if err != nil || resp.StatusCode >= 400 {
-		log.Printf("---\n %#v \n---\n %#v \n---\n %#v \n---\n", req, resp, payload)
+		ah.logf("---\n %#v \n---\n %#v \n---\n %#v \n---\n", req, resp, payload)
 		if err != nil {
 			return err
 		}
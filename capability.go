@@ -0,0 +1,185 @@
+package twitch
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Capability - Feature of the client and whether the admin scopes allow it
+type Capability struct {
+	Name    string   `json:"name"`
+	Scopes  []string `json:"scopes"`
+	Enabled bool     `json:"enabled"`
+	Missing []string `json:"missing,omitempty"`
+}
+
+type capabilityDef struct {
+	name   string
+	scopes []string
+}
+
+// Features which need more than the public API
+var capabilityTable = []capabilityDef{
+	{"chat", []string{scopeChatLogin}},
+	{"whispers", []string{scopeChatLogin}},
+	{"subscribers", []string{scopeChannelSubscriptions, scopeChannelCheckSubscription}},
+	{"editors", []string{scopeChannelRead}},
+	{"commercials", []string{scopeChannelCommercial}},
+	{"pubsub:" + psChanBits, []string{}}, // Any scope on the channel owner's token
+	{"pubsub:" + psChanSubs, []string{scopeChannelSubscriptions}},
+	{"pubsub:" + psVideoPlayback, []string{}},
+	{"pubsub:" + psChatModActions, []string{scopeChannelModerate}},
+	{"pubsub:" + psUserWhispers, []string{scopeChatLogin}},
+}
+
+// Capabilities - What the admin login can and cannot do with its granted scopes
+func (ah *Client) Capabilities() []Capability {
+	granted := []string{}
+	if ah.AdminAuth != nil {
+		for k, v := range ah.AdminAuth.Scopes {
+			if v {
+				granted = append(granted, k)
+			}
+		}
+	}
+
+	cList := []Capability{}
+	for _, cd := range capabilityTable {
+		missing := missingScopes(cd.scopes, granted)
+		cList = append(cList, Capability{
+			Name:    cd.name,
+			Scopes:  cd.scopes,
+			Enabled: len(missing) == 0,
+			Missing: missing,
+		})
+	}
+
+	return cList
+}
+
+// HasCapability - Is the named feature enabled
+func (ah *Client) HasCapability(name string) bool {
+	for _, c := range ah.Capabilities() {
+		if c.Name == name {
+			return c.Enabled
+		}
+	}
+	return false
+}
+
+// MissingScopes - Scopes needed by disabled capabilities
+func (ah *Client) MissingScopes() []string {
+	seen := make(map[string]bool)
+	missing := []string{}
+	for _, c := range ah.Capabilities() {
+		for _, s := range c.Missing {
+			if !seen[s] {
+				seen[s] = true
+				missing = append(missing, s)
+			}
+		}
+	}
+	return missing
+}
+
+// ReconsentURL - Admin path asking only for the missing scopes, empty if nothing is missing
+// Relative to the admin root and served by handleOAuthAdminStart
+func (ah *Client) ReconsentURL() string {
+	missing := ah.MissingScopes()
+	if len(missing) == 0 {
+		return ""
+	}
+
+	return "reconsent?scope=" + url.QueryEscape(mergeScopeString(missing))
+}
+
+// logCapabilities - Startup report of enabled and disabled features
+func (ah *Client) logCapabilities() {
+	enabled := []string{}
+	disabled := []string{}
+	for _, c := range ah.Capabilities() {
+		if c.Enabled {
+			enabled = append(enabled, c.Name)
+		} else {
+			disabled = append(disabled, c.Name+" ("+strings.Join(c.Missing, ", ")+")")
+		}
+	}
+
+	ah.logf("Capabilities enabled: %s", strings.Join(enabled, ", "))
+	if len(disabled) > 0 {
+		ah.logf("Capabilities disabled: %s\n\tRe-consent at %s", strings.Join(disabled, ", "), ah.ReconsentURL())
+	}
+}
+
+// pubSubTopics - Configured topics the admin scopes allow
+func (ah *Client) pubSubTopics() PubSubTopicList {
+	topics := PubSubTopicList{}
+	for _, subject := range ah.Config.PubSubTopics {
+		if !ah.HasCapability("pubsub:" + subject) {
+			continue
+		}
+
+		target := ah.RoomID
+		switch subject {
+		case psUserWhispers:
+			target = ah.AdminID
+		case psChatModActions:
+			target = ah.AdminID + "." + ah.RoomID
+		}
+		topics = append(topics, PubSubTopic{Subject: subject, Target: target})
+	}
+	return topics
+}
+
+// requestedAdminScopes - Scopes asked for by an admin sign in
+// Re-consent adds ?scope= to what is already granted as a twitch token only carries what was asked for
+func (ah *Client) requestedAdminScopes(req *http.Request) []string {
+	extra := []string{}
+	for _, s := range strings.FieldsFunc(req.URL.Query().Get("scope"), func(r rune) bool { return r == '+' || r == ' ' }) {
+		for _, v := range ValidScopes {
+			if s == v {
+				extra = append(extra, s)
+			}
+		}
+	}
+
+	if len(extra) == 0 || ah.AdminAuth.Token == nil {
+		return DefaultStreamerScope
+	}
+
+	scopes := []string{}
+	for k, v := range ah.AdminAuth.Scopes {
+		if v {
+			scopes = append(scopes, k)
+		}
+	}
+	return append(scopes, missingScopes(extra, scopes)...)
+}
+
+// applyReconsent - Swap in the admin token from a re-consent login and start anything it enables
+func (ah *Client) applyReconsent(authU *UserAuth) error {
+	if authU.Token == nil || authU.Token.UserID != ah.AdminID {
+		return fmt.Errorf("Re-consent must use the admin account")
+	}
+
+	hadChat := ah.HasCapability("chat")
+
+	ah.AdminAuth.AuthCode = authU.AuthCode
+	ah.AdminAuth.Scopes = authU.Scopes
+	ah.AdminAuth.Token = authU.Token
+
+	err := ah.saveToken()
+	if err != nil {
+		return err
+	}
+
+	ah.logCapabilities()
+
+	if !hadChat && ah.HasCapability("chat") && ah.Chat == nil {
+		go ah.startNewChat()
+	}
+
+	return nil
+}
@@ -0,0 +1,67 @@
+package twitch
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestCapabilities(t *testing.T) {
+	ah := &Client{
+		Config: DefaultConfig(),
+		AdminAuth: &UserAuth{
+			Scopes: map[string]bool{scopeChatLogin: true, scopeChannelRead: true},
+			Token:  &authToken{UserID: "100"},
+		},
+	}
+
+	if !ah.HasCapability("chat") || !ah.HasCapability("editors") {
+		t.Errorf("Chat and editors should be enabled: %v", ah.Capabilities())
+	}
+	if ah.HasCapability("commercials") || ah.HasCapability("subscribers") {
+		t.Errorf("Commercials and subscribers should be disabled: %v", ah.Capabilities())
+	}
+
+	topics := ah.pubSubTopics()
+	if len(topics) != 1 || topics[0].Subject != psUserWhispers {
+		t.Errorf("Expected whisper topic: %s", topics)
+	}
+
+	ah.AdminID, ah.RoomID = "100", "200"
+	ah.Config.PubSubTopics = []string{psChanBits, psChanSubs, psChatModActions, psUserWhispers}
+	if topics = ah.pubSubTopics(); topics.String() != "channel-bits-events-v1.200, whispers.100" {
+		t.Errorf("Subscriber and moderator topics should need their scopes: %s", topics)
+	}
+	ah.AdminAuth.Scopes[scopeChannelSubscriptions] = true
+	ah.AdminAuth.Scopes[scopeChannelModerate] = true
+	if topics = ah.pubSubTopics(); len(topics) != 4 || topics[2].String() != "chat_moderator_actions.100.200" {
+		t.Errorf("Expected every topic once granted: %s", topics)
+	}
+	delete(ah.AdminAuth.Scopes, scopeChannelSubscriptions)
+	delete(ah.AdminAuth.Scopes, scopeChannelModerate)
+	ah.Config.PubSubTopics = DefaultConfig().PubSubTopics
+
+	rURL := ah.ReconsentURL()
+	if !strings.Contains(rURL, scopeChannelCommercial) || strings.Contains(rURL, scopeChatLogin) {
+		t.Errorf("Re-consent should only ask for missing scopes: %s", rURL)
+	}
+
+	req := httptest.NewRequest("GET", "/twitch/admin/"+rURL+"+bogus", nil)
+	scopes := ah.requestedAdminScopes(req)
+	if missing := missingScopes(append(ah.MissingScopes(), scopeChatLogin, scopeChannelRead), scopes); len(missing) > 0 {
+		t.Errorf("Re-consent request missing %v from %v", missing, scopes)
+	}
+	for _, s := range scopes {
+		if s == "bogus" {
+			t.Errorf("Invalid scope requested: %v", scopes)
+		}
+	}
+
+	ah.AdminAuth.Scopes = make(map[string]bool)
+	for _, s := range scopes {
+		ah.AdminAuth.Scopes[s] = true
+	}
+	if ah.ReconsentURL() != "" {
+		t.Errorf("Nothing should be missing: %v", ah.MissingScopes())
+	}
+}
@@ -2,6 +2,8 @@ package twitch
 
 import (
 	"fmt"
+	"strings"
+	"time"
 )
 
 type chatMode struct {
@@ -46,3 +48,106 @@ func (cm chatMode) String() string {
 
 	return s
 }
+
+// ChatModeState - Exported snapshot of the room mode
+type ChatModeState struct {
+	SubsOnly      bool    `json:"subs_only"`
+	EmoteOnly     bool    `json:"emote_only"`
+	FollowersOnly bool    `json:"followers_only"`
+	SlowMode      bool    `json:"slow"`
+	R9k           bool    `json:"r9k"`
+	Lang          string  `json:"lang,omitempty"`
+	Hosting       IrcNick `json:"hosting,omitempty"`
+}
+
+// Chat Mode Names used by SetMode
+const (
+	ChatModeSubsOnly      = "subs"
+	ChatModeEmoteOnly     = "emote"
+	ChatModeFollowersOnly = "followers"
+	ChatModeSlow          = "slow"
+	ChatModeR9k           = "r9k"
+)
+
+// Mode - Current room mode as reported by ROOMSTATE
+func (c *Chat) Mode() ChatModeState {
+	cms := ChatModeState{
+		SubsOnly:      c.mode.subsOnly,
+		EmoteOnly:     c.mode.emoteOnly,
+		FollowersOnly: c.mode.followersOnly,
+		SlowMode:      c.mode.slowMode,
+		R9k:           c.mode.r9k,
+		Lang:          c.mode.lang,
+	}
+
+	if c.mode.hosting != nil {
+		cms.Hosting = c.mode.hosting.GetNick()
+	}
+
+	return cms
+}
+
+// SetMode - Ask twitch to change a room mode, arg is seconds for slow and minutes for followers
+func (c *Chat) SetMode(mode string, on bool, arg int) error {
+	var cmd string
+
+	switch mode {
+	case ChatModeSubsOnly:
+		cmd = "/subscribers"
+	case ChatModeEmoteOnly:
+		cmd = "/emoteonly"
+	case ChatModeFollowersOnly:
+		cmd = "/followers"
+	case ChatModeSlow:
+		cmd = "/slow"
+	case ChatModeR9k:
+		cmd = "/r9kbeta"
+	default:
+		return fmt.Errorf("Unknown chat mode: %s", mode)
+	}
+
+	if !on {
+		return c.WriteSayMsg(cmd + "off")
+	} else if arg > 0 && (mode == ChatModeSlow || mode == ChatModeFollowersOnly) {
+		return c.WriteSayMsg(fmt.Sprintf("%s %d", cmd, arg))
+	}
+
+	return c.WriteSayMsg(cmd)
+}
+
+// Timeout - Timeout a chatter
+func (c *Chat) Timeout(nick IrcNick, dur time.Duration, reason string) error {
+	if !nick.IsValid() {
+		return fmt.Errorf("Invalid Nick")
+	}
+
+	secs := int(dur / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+
+	return c.WriteSayMsg(strings.TrimSpace(fmt.Sprintf("/timeout %s %d %s", nick, secs, reason)))
+}
+
+// Ban - Ban a chatter
+func (c *Chat) Ban(nick IrcNick, reason string) error {
+	if !nick.IsValid() {
+		return fmt.Errorf("Invalid Nick")
+	}
+
+	return c.WriteSayMsg(strings.TrimSpace(fmt.Sprintf("/ban %s %s", nick, reason)))
+}
+
+// Unban - Lift a ban or timeout
+func (c *Chat) Unban(nick IrcNick) error {
+	if !nick.IsValid() {
+		return fmt.Errorf("Invalid Nick")
+	}
+
+	return c.WriteSayMsg(fmt.Sprintf("/unban %s", nick))
+}
+
+// ClearChat - Clear the room chat history for everyone
+func (c *Chat) ClearChat() error {
+	return c.WriteSayMsg("/clear")
+}
@@ -3,9 +3,12 @@ package twitch
 import (
 	"fmt"
 	"io"
+	"log"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 )
 
@@ -53,6 +56,7 @@ const (
 	LogCatUnknown  LogCat = '?'
 
 	numInteralLogLines int = 1024
+	chatLogPumpBacklog int = 256
 )
 
 // FriendlyName - Produce a friendly name for Cat
@@ -91,19 +95,107 @@ type LogLineParsedMsg struct {
 	Bits    int                      `json:"bits"`
 	Content string                   `json:"content"`
 	Emotes  EmoteReplaceListFromBack `json:"emotes"`
+	Badges  ChatBadges               `json:"badges,omitempty"` // Not stored in the log file
+}
+
+// ChatFilter - Restricts which lines a subscriber gets, zero values match everything
+type ChatFilter struct {
+	Users   []IrcNick                // Only lines from these nicks
+	Badges  []string                 // Only lines from chatters with one of these badges
+	Pattern *regexp.Regexp           // Only lines whose content or body matches
+	MinBits int                      // Only messages with at least this many bits
+	Match   func(LogLineParsed) bool // Custom predicate run after the other checks
+}
+
+// Allows - Checks if line passes the filter
+func (cf *ChatFilter) Allows(llp LogLineParsed) bool {
+	if cf == nil {
+		return true
+	}
+
+	needsMsg := len(cf.Users) > 0 || len(cf.Badges) > 0 || cf.MinBits > 0
+	if needsMsg && llp.Msg == nil {
+		return false
+	}
+
+	if len(cf.Users) > 0 {
+		found := false
+		for _, n := range cf.Users {
+			if strings.EqualFold(string(n), string(llp.Msg.Nick)) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(cf.Badges) > 0 {
+		found := false
+		for _, b := range cf.Badges {
+			if _, ok := llp.Msg.Badges[b]; ok {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if cf.MinBits > 0 && llp.Msg.Bits < cf.MinBits {
+		return false
+	}
+
+	if cf.Pattern != nil {
+		content := llp.Body
+		if llp.Msg != nil {
+			content = llp.Msg.Content
+		}
+		if !cf.Pattern.MatchString(content) {
+			return false
+		}
+	}
+
+	if cf.Match != nil {
+		return cf.Match(llp)
+	}
+
+	return true
 }
 
 type subToChatPump struct {
-	Name   string
-	Subbed []LogCat
-	C      chan LogLineParsed
+	Name    string
+	Subbed  []LogCat
+	C       chan LogLineParsed
+	Options SubOptions
+	Filter  *ChatFilter
+
+	counters *subCounters
+}
+
+// isSubbed - Check if Channel is Subbed to this Topic
+func (sub *subToChatPump) isSubbed(cat LogCat) bool {
+	for _, t := range sub.Subbed {
+		if t == cat {
+			return true
+		}
+	}
+	return false
+}
+
+func (sub *subToChatPump) deliver(llp LogLineParsed) bool {
+	return deliverTo(sub.C, llp, sub.Options, sub.counters)
 }
 
 type chatLogInteral struct {
-	subbedToChat []subToChatPump
-	newSubs      chan subToChatPump
-	killSubs     chan subToChatPump
+	subbedToChat []*subToChatPump
+	subLock      sync.Mutex
+	newSubs      chan *subToChatPump
+	killSubs     chan chan LogLineParsed
 	newLines     chan LogLineParsed
+	droppedLines uint64
 
 	ChatLines [numInteralLogLines]LogLineParsed
 	ChatFile  io.Writer
@@ -115,8 +207,12 @@ type chatLogInteral struct {
 
 // LogLine - Log Line
 func (cli *chatLogInteral) LogLine(llp LogLineParsed) {
-	// Write to Subs
-	cli.newLines <- llp
+	// Write to Subs - never stall the IRC handler on a slow pump
+	select {
+	case cli.newLines <- llp:
+	default:
+		atomic.AddUint64(&cli.droppedLines, 1)
+	}
 
 	// To avoid reusing memory
 	safeLine := llp
@@ -408,13 +504,13 @@ func (llp *LogLineParsed) UpdateBody() {
 }
 
 // startChatLogPump - Start the internal go routine and create the pump
-func startChatLogPump(room IrcNick) *chatLogInteral {
+func startChatLogPump(room IrcNick, dataDir string) *chatLogInteral {
 
 	cli := chatLogInteral{
-		newSubs:  make(chan subToChatPump, 10),
-		killSubs: make(chan subToChatPump, 10),
-		newLines: make(chan LogLineParsed, 10),
-		ChatFile: getChatLogWriter(room),
+		newSubs:  make(chan *subToChatPump, 10),
+		killSubs: make(chan chan LogLineParsed, 10),
+		newLines: make(chan LogLineParsed, chatLogPumpBacklog),
+		ChatFile: getChatLogWriter(dataDir, room),
 	}
 
 	go cli.run()
@@ -428,27 +524,21 @@ func (cli *chatLogInteral) run() {
 pumpLoop:
 	for {
 		select {
-		case deadSub, ok := <-cli.killSubs:
+		case deadChan, ok := <-cli.killSubs:
 			if !ok {
 				break pumpLoop
 			}
 
-			subs := []subToChatPump{}
-			for _, sub := range cli.subbedToChat {
-				if sub.C == deadSub.C {
-					close(deadSub.C)
-				} else {
-					subs = append(subs, sub)
-				}
-			}
-			cli.subbedToChat = subs
+			cli.removeSub(deadChan)
 
 		case newSub, ok := <-cli.newSubs:
 			if !ok {
 				break pumpLoop
 			}
 
+			cli.subLock.Lock()
 			cli.subbedToChat = append(cli.subbedToChat, newSub)
+			cli.subLock.Unlock()
 
 		case llp := <-cli.newLines:
 			cli.postInternal(llp)
@@ -456,28 +546,59 @@ pumpLoop:
 	}
 
 	// Close all alert Channels
+	cli.subLock.Lock()
 	for _, sub := range cli.subbedToChat {
 		close(sub.C)
 	}
+	cli.subbedToChat = nil
+	cli.subLock.Unlock()
+}
+
+// removeSub - Remove and close subscription, safe to call for already removed channels
+func (cli *chatLogInteral) removeSub(deadChan chan LogLineParsed) {
+	cli.subLock.Lock()
+	defer cli.subLock.Unlock()
+
+	subs := []*subToChatPump{}
+	for _, sub := range cli.subbedToChat {
+		if sub.C == deadChan {
+			close(sub.C)
+		} else {
+			subs = append(subs, sub)
+		}
+	}
+	cli.subbedToChat = subs
 }
 
 // postInternal - Manages the Actual Posting of the Line
+// Lines are delivered without the lock, subscribers are only removed on this goroutine
 func (cli *chatLogInteral) postInternal(llp LogLineParsed) {
+	cli.subLock.Lock()
+	subs := append([]*subToChatPump{}, cli.subbedToChat...)
+	cli.subLock.Unlock()
 
 	// Forward Alert
-SubLoop:
-	for _, subs := range cli.subbedToChat {
-		// Check if Channel is Subbed to this Topic
-		for _, t := range subs.Subbed {
-			if t == llp.Cat {
-				select {
-				case subs.C <- llp:
-				default: // Non blocking
-				}
-				continue SubLoop
-			}
+	for _, sub := range subs {
+		if !sub.isSubbed(llp.Cat) || !sub.Filter.Allows(llp) {
+			continue
+		}
+
+		if !sub.deliver(llp) {
+			log.Printf("Chat subscriber %s overflowed and was disconnected", sub.Name)
+			cli.removeSub(sub.C)
 		}
+	}
+}
 
-		// Not Subbed to Topic
+// subscribers - Diagnostic snapshot of every current subscriber
+func (cli *chatLogInteral) subscribers() []SubStats {
+	cli.subLock.Lock()
+	defer cli.subLock.Unlock()
+
+	sList := make([]SubStats, 0, len(cli.subbedToChat))
+	for _, sub := range cli.subbedToChat {
+		sList = append(sList, sub.counters.stats(sub.Name, sub.Options, len(sub.C)))
 	}
+
+	return sList
 }
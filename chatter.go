@@ -18,6 +18,7 @@ type Chatter struct {
 	Bits        int        `json:"bits"`
 
 	Mod      bool       `json:"mod"`
+	VIP      bool       `json:"vip"`
 	Sub      int        `json:"sub"`
 	UserType string     `json:"user_type"`
 	Badges   ChatBadges `json:"badges"`
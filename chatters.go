@@ -0,0 +1,159 @@
+package twitch
+
+import (
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	tmiChattersURL     = "https://tmi.twitch.tv/group/user/%s/chatters"
+	tmiChattersTimeout = time.Second * 20 // Polled from the heartbeat which must not hang on it
+)
+
+// Chatters categories
+const (
+	ChattersBroadcaster = "broadcaster"
+	ChattersVIP         = "vips"
+	ChattersModerator   = "moderators"
+	ChattersStaff       = "staff"
+	ChattersAdmin       = "admins"
+	ChattersGlobalMod   = "global_mods"
+	ChattersViewer      = "viewers"
+)
+
+// ChattersList - Nicks in chat by category
+type ChattersList struct {
+	Broadcaster []IrcNick `json:"broadcaster"`
+	VIPs        []IrcNick `json:"vips"`
+	Moderators  []IrcNick `json:"moderators"`
+	Staff       []IrcNick `json:"staff"`
+	Admins      []IrcNick `json:"admins"`
+	GlobalMods  []IrcNick `json:"global_mods"`
+	Viewers     []IrcNick `json:"viewers"`
+}
+
+// ChattersResponse - Body of the tmi chatters endpoint
+type ChattersResponse struct {
+	ChatterCount int          `json:"chatter_count"`
+	Chatters     ChattersList `json:"chatters"`
+}
+
+// ChattersSnapshot - Result of a chatters poll
+type ChattersSnapshot struct {
+	Time     time.Time    `json:"time"`
+	Count    int          `json:"count"`    // As reported by twitch, can be more than listed
+	Resolved int          `json:"resolved"` // Listed nicks matched to viewers
+	Complete bool         `json:"complete"` // Everyone was listed and resolved so absent viewers left
+	Chatters ChattersList `json:"chatters"`
+}
+
+// chattersPoll - Most recent snapshot, polled alongside the heartbeat
+type chattersPoll struct {
+	lock sync.RWMutex
+	last *ChattersSnapshot
+}
+
+func (cl *ChattersList) categories() map[string][]IrcNick {
+	return map[string][]IrcNick{
+		ChattersBroadcaster: cl.Broadcaster,
+		ChattersVIP:         cl.VIPs,
+		ChattersModerator:   cl.Moderators,
+		ChattersStaff:       cl.Staff,
+		ChattersAdmin:       cl.Admins,
+		ChattersGlobalMod:   cl.GlobalMods,
+		ChattersViewer:      cl.Viewers,
+	}
+}
+
+// Roles - Category of every listed nick
+func (cl *ChattersList) Roles() map[IrcNick]string {
+	roles := make(map[IrcNick]string)
+	for cat, nList := range cl.categories() {
+		for _, n := range nList {
+			roles[nickKey(n)] = cat
+		}
+	}
+	return roles
+}
+
+// All - Every listed nick sorted
+func (cl *ChattersList) All() []IrcNick {
+	nList := []IrcNick{}
+	for n := range cl.Roles() {
+		nList = append(nList, n)
+	}
+	sort.Slice(nList, func(i, j int) bool { return nList[i] < nList[j] })
+	return nList
+}
+
+// applyChattersRole - Mod, VIP and user type as the chatters list sees them
+// Staff, admins and global mods are not channel mods so only the user type is recorded
+func (ch *Chatter) applyChattersRole(role string) {
+	ch.VIP = role == ChattersVIP
+	ch.Mod = role == ChattersModerator
+	switch role {
+	case ChattersStaff:
+		ch.UserType = TwitchTypeStaff
+	case ChattersAdmin:
+		ch.UserType = TwitchTypeAdmin
+	case ChattersGlobalMod:
+		ch.UserType = TwitchTypeGlobalMod
+	}
+}
+
+// mergeChatters - Resolve the listed nicks, update their roles and reconcile who is in the room
+func (ah *Client) mergeChatters(cr *ChattersResponse) *ChattersSnapshot {
+	roles := cr.Chatters.Roles()
+	nList := cr.Chatters.All()
+	vList := ah.Viewers.UpdateViewers(nList)
+
+	for _, v := range vList {
+		chatter := v.CreateChatter()
+		role, ok := roles[nickKey(chatter.Nick)]
+		if !ok {
+			continue
+		}
+		chatter.applyChattersRole(role)
+		v.SetChatter(chatter)
+	}
+
+	snap := ChattersSnapshot{
+		Time:     time.Now(),
+		Count:    cr.ChatterCount,
+		Resolved: len(vList),
+		Complete: len(vList) == len(nList) && len(nList) >= cr.ChatterCount,
+		Chatters: cr.Chatters,
+	}
+
+	if ah.Chat != nil {
+		ah.Chat.reconcileRoom(vList, PresenceChatters, snap.Complete)
+	}
+	return &snap
+}
+
+// pollChatters - Fetch and merge the chatters list, errors leave the last snapshot in place
+func (heart *Heartbeat) pollChatters() {
+	cr, err := heart.client.Stream.GetChatters(heart.client.RoomName)
+	if err != nil {
+		heart.client.logf("Chatters Check failed: %s", err)
+		return
+	}
+
+	snap := heart.client.mergeChatters(cr)
+
+	heart.chatters.lock.Lock()
+	heart.chatters.last = snap
+	heart.chatters.lock.Unlock()
+
+	if !snap.Complete {
+		heart.client.logf("Chatters: resolved %d of %d listed, %d in chat", snap.Resolved, len(cr.Chatters.All()), snap.Count)
+	}
+}
+
+// GetChatters - Most recent chatters snapshot, nil before the first poll
+func (heart *Heartbeat) GetChatters() *ChattersSnapshot {
+	heart.chatters.lock.RLock()
+	defer heart.chatters.lock.RUnlock()
+	return heart.chatters.last
+}
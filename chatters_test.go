@@ -0,0 +1,114 @@
+package twitch
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestChattersPoll(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/group/user/kimau/chatters" {
+			http.NotFound(w, req)
+			return
+		}
+		fmt.Fprint(w, `{"_links":{},"chatter_count":5,"chatters":{"broadcaster":["kimau"],"vips":["wilma"],
+			"moderators":["Ronni"],"staff":["dino"],"admins":[],"global_mods":[],"viewers":["fred"]}}`)
+	}))
+	defer ts.Close()
+
+	ah := &Client{Config: DefaultConfig(), RoomName: "kimau", httpClient: ts.Client()}
+	ah.Viewers = CreateViewerMethod(ah)
+	ah.Stream = &StreamsMethod{client: ah, chattersURL: ts.URL + "/group/user/%s/chatters"}
+	ah.Presence, _ = OpenPresenceLog("")
+	ah.Heart = &Heartbeat{client: ah}
+
+	var err error
+	ah.Chat, err = createIrcClient(&DummyAuth{}, ah.Viewers, "", DefaultDataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ah.Chat.weakClientRef = ah
+
+	for i, n := range []IrcNick{"kimau", "ronni", "wilma", "fred", "barney", "dino"} {
+		ah.Viewers.GetFromUser(User{ID: ID(fmt.Sprint(i + 1)), Name: n})
+	}
+	barney, _ := ah.Viewers.Find("barney")
+	ah.Chat.activeInRoom(barney, PresenceJoin)
+
+	wilma, _ := ah.Viewers.Find("wilma")
+	wc := wilma.CreateChatter()
+	wc.Mod = true
+	wilma.SetChatter(wc)
+
+	if ah.Heart.GetChatters() != nil {
+		t.Errorf("Snapshot before polling")
+	}
+	ah.Heart.pollChatters()
+
+	snap := ah.Heart.GetChatters()
+	if snap == nil || snap.Count != 5 || snap.Resolved != 5 || !snap.Complete {
+		t.Fatalf("Snapshot wrong: %+v", snap)
+	}
+	if len(snap.Chatters.Moderators) != 1 || len(snap.Chatters.All()) != 5 {
+		t.Errorf("Categories wrong: %+v", snap.Chatters)
+	}
+
+	if ah.Chat.IsInRoom("barney") || !ah.Chat.IsInRoom("fred") || !ah.Presence.IsPresent("kimau") {
+		t.Errorf("Presence not reconciled: %v", ah.Chat.InRoomNicks())
+	}
+	if h := ah.Presence.History("fred"); len(h) != 1 || h[0].JoinedBy != PresenceChatters {
+		t.Errorf("Fred should have arrived from chatters: %+v", h)
+	}
+
+	ronni, _ := ah.Viewers.Find("ronni")
+	if ch := ronni.GetData().Chatter; ch == nil || !ch.Mod {
+		t.Errorf("Moderator role not applied")
+	}
+	if ch := wilma.GetData().Chatter; ch.Mod || !ch.VIP {
+		t.Errorf("VIP role not applied: %+v", ch)
+	}
+
+	// Staff are not channel mods
+	dino, _ := ah.Viewers.Find("dino")
+	if ch := dino.GetData().Chatter; ch == nil || ch.Mod || ch.UserType != TwitchTypeStaff {
+		t.Errorf("Staff role wrong: %+v", ch)
+	}
+	ac := CreateAccessControl(ah, "")
+	if r := ac.RoleFor(dino.GetData()); r != RoleViewer {
+		t.Errorf("Staff got role %v", r)
+	}
+	if r := ac.RoleFor(ronni.GetData()); r != RoleMod {
+		t.Errorf("Moderator got role %v", r)
+	}
+
+	// Failed poll keeps the last snapshot
+	ah.Stream.chattersURL = ts.URL + "/missing/%s"
+	ah.Heart.pollChatters()
+	if ah.Heart.GetChatters() != snap {
+		t.Errorf("Snapshot replaced by a failed poll")
+	}
+}
+
+type chattersDeadlineClient struct {
+	deadline bool
+}
+
+func (dc *chattersDeadlineClient) Do(req *http.Request) (*http.Response, error) {
+	_, dc.deadline = req.Context().Deadline()
+	return nil, fmt.Errorf("Offline")
+}
+
+func TestChattersTimeout(t *testing.T) {
+	dc := &chattersDeadlineClient{}
+	ah := &Client{Config: DefaultConfig(), httpClient: dc}
+	ah.Stream = &StreamsMethod{client: ah}
+
+	if _, err := ah.Stream.GetChatters("kimau"); err == nil {
+		t.Errorf("Expected the client error")
+	}
+	if !dc.deadline {
+		t.Errorf("Chatters request has no deadline so a hung endpoint blocks the heartbeat")
+	}
+}
@@ -6,10 +6,10 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
-	"net"
 	"net/http"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -31,6 +31,7 @@ var (
 		scopeChannelEditor,
 		scopeChannelFeedEdit,
 		scopeChannelFeedRead,
+		scopeChannelModerate,
 		scopeChannelRead,
 		scopeChannelStream,
 		scopeChannelSubscriptions,
@@ -71,6 +72,7 @@ type authInternalState string
 // Client - Twitch OAuth Client
 type Client struct {
 	*tokenData
+	Config Config
 
 	httpClient WebClient
 	domain     string
@@ -84,57 +86,97 @@ type Client struct {
 	RoomID     ID
 	RoomStream *StreamBody
 
-	PendingLogins map[authInternalState]time.Time
-
-	Alerts  *AlertPump
-	Badges  *BadgeMethod
-	Channel *ChannelsMethod
-	Chat    *Chat
-	Heart   *Heartbeat
-	PubSub  *PubSubConn
-	Stream  *StreamsMethod
-	User    *UsersMethod
-	Viewers *ViewerMethod
+	PendingLogins map[authInternalState]PendingLogin
+	pendingLock   sync.Mutex
+
+	Access     *AccessControl
+	Alerts     *AlertPump
+	AlertQueue *AlertQueue
+	Badges     *BadgeMethod
+	Channel    *ChannelsMethod
+	Chat       *Chat
+	Feed       *LiveFeed
+	Heart      *Heartbeat
+	Presence   *PresenceLog
+	PubSub     *PubSubConn
+	Stream     *StreamsMethod
+	User       *UsersMethod
+	Viewers    *ViewerMethod
+	Webhooks   *WebhookDispatcher
 }
 
 // CreateTwitchClient -
 func CreateTwitchClient(servingFromDomain string, reqScopes []string, roomToJoin string, forceAuth bool) (*Client, error) {
+	cfg := DefaultConfig()
+	cfg.Domain = servingFromDomain
+	cfg.Scopes = reqScopes
+	cfg.Room = IrcNick(roomToJoin)
+	cfg.ForceAuth = forceAuth
+
+	return NewClient(cfg)
+}
+
+// NewClient - Validate the config and start the enabled subsystems
+func NewClient(cfg Config) (*Client, error) {
+	err := cfg.Validate()
+	if err != nil {
+		return nil, err
+	}
+
 	kb := Client{
-		domain:    servingFromDomain,
-		servePath: servingFromDomain[strings.Index(servingFromDomain, "/"):],
+		Config:    cfg,
+		domain:    cfg.Domain,
+		servePath: cfg.Domain[strings.Index(cfg.Domain, "/"):],
 
-		RoomName: IrcNick(roomToJoin),
+		RoomName: cfg.Room,
 
-		httpClient:   &http.Client{},
+		httpClient:   cfg.HTTPClient,
 		AdminChannel: make(chan int, 3),
 
-		PendingLogins: make(map[authInternalState]time.Time),
+		PendingLogins: make(map[authInternalState]PendingLogin),
 	}
 
-	kb.loadSecrets()
+	err = kb.loadSecrets()
+	if err != nil {
+		return nil, err
+	}
+	if kb.Config.IrcServer == "" && kb.Config.IrcTransport == IrcTransportTCP {
+		kb.Config.IrcServer = kb.IrcServerAddr
+	}
+	if kb.Config.IrcServer == "" {
+		kb.Config.IrcServer = defaultIrcServerFor(kb.Config.IrcTransport)
+	}
 
 	kb.Viewers = CreateViewerMethod(&kb)
-	hvd, err := LoadMostRecentViewerDump(kb.RoomName)
-	if err == nil {
-		if hvd != nil {
-			for k := range hvd.ViewerData {
-				kb.Viewers.Set(hvd.ViewerData[k])
-			}
+	if cfg.Enable.Database {
+		if err := kb.openViewerDB(); err != nil {
+			return nil, err
 		}
+	} else if cfg.Enable.Dumps {
+		hvd, err := loadMostRecentViewerDump(cfg.DataDir, kb.RoomName)
+		if err == nil {
+			if hvd != nil {
+				for k := range hvd.ViewerData {
+					kb.Viewers.Set(hvd.ViewerData[k])
+				}
+			}
 
-		err = kb.Viewers.SanityScan()
-		if err != nil {
-			for k, v := range kb.Viewers.viewers {
-				b, _ := json.Marshal(v)
-				fmt.Println(k, string(b))
+			err = kb.Viewers.SanityScan()
+			if err != nil {
+				kb.Viewers.forEach(func(v *Viewer) bool {
+					vd := v.GetData()
+					b, _ := json.Marshal(vd)
+					fmt.Println(vd.TwitchID, string(b))
+					return true
+				})
+
+				return nil, err
 			}
+			fmt.Println("===== LOADED VIEWERS FROM FILES ====")
 
-			panic(err)
+		} else {
+			log.Printf("Unable to load old user data: %s", err)
 		}
-		fmt.Println("===== LOADED VIEWERS FROM FILES ====")
-
-	} else {
-		log.Printf("Unable to load old user data: %s", err)
 	}
 
 	// Creat Admin Auth Temp
@@ -143,19 +185,46 @@ func CreateTwitchClient(servingFromDomain string, reqScopes []string, roomToJoin
 		InteralState: authInternalState(GenerateRandomString(16)),
 		Scopes:       make(map[string]bool),
 	}
-	kb.AdminAuth.updateScope(reqScopes)
+	kb.AdminAuth.updateScope(cfg.Scopes)
 
 	kb.User = &UsersMethod{client: &kb, au: kb.AdminAuth}
 	kb.Channel = &ChannelsMethod{client: &kb, au: kb.AdminAuth}
 	kb.Stream = &StreamsMethod{client: &kb, au: kb.AdminAuth}
-	kb.Heart = &Heartbeat{client: &kb}
+	kb.Access = CreateAccessControl(&kb, cfg.dataPath(accessGrantsPattern, kb.RoomName))
 	kb.Alerts = StartAlertPump(&kb)
+	if cfg.Enable.AlertQueue {
+		kb.AlertQueue = CreateAlertQueue(kb.Alerts, DefaultAlertQueueConfig())
+	}
 
+	presenceFile := cfg.dataPath(presenceLogPattern, kb.RoomName)
+	kb.Presence, err = OpenPresenceLog(presenceFile)
 	if err != nil {
-		panic(err)
+		log.Printf("Unable to open presence log %s using memory only\n%s", presenceFile, err)
+		kb.Presence, _ = OpenPresenceLog("")
+	}
+
+	if cfg.Enable.Heartbeat {
+		kb.Heart = &Heartbeat{client: &kb}
+	}
+
+	if cfg.Enable.LiveFeed {
+		kb.Feed = CreateLiveFeed(&kb)
+	}
+
+	if cfg.Enable.Webhooks {
+		kb.Webhooks = CreateWebhookDispatcher(kb.Alerts, cfg.dataPath(webhookDeadPattern, kb.RoomName))
+		for _, wt := range kb.tokenData.Webhooks {
+			if whErr := kb.Webhooks.AddTarget(wt); whErr != nil {
+				log.Printf("Webhook %s not started: %s", wt.Name, whErr)
+			}
+		}
 	}
 
-	if !forceAuth {
+	if cfg.Enable.Chat && cfg.AnonymousChat {
+		go kb.startNewChat()
+	}
+
+	if !cfg.ForceAuth {
 		kb.loadToken()
 	}
 
@@ -184,39 +253,36 @@ func (ah *Client) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 		return
 	}
 
-	// User isn't Auth start login
-	c, err := req.Cookie(UserAuthSessionCookieName)
-	if err != nil {
-		log.Printf("Session Error: %s - %s", err, req.URL)
-		ah.handleOAuthStart(w, req)
+	if strings.HasPrefix(relPath, "logout") {
+		ah.logout(w, req)
 		return
 	}
 
-	cList := strings.Split(c.Value, ":")
-	tid := ID(cList[0])
-
-	// Try Find User
-	vwr, err := ah.Viewers.GetData(tid)
+	// User isn't Auth start login
+	v, err := ah.sessionViewer(w, req)
 	if err != nil {
-		log.Println("Invalid User: ", tid, err)
+		ah.logf("Session Error: %s - %s", err, req.URL)
+		ah.handleOAuthStart(w, req)
 		return
 	}
 
-	// User isn't Auth start login
-	if vwr.Auth.checkCookie(c) == false {
-		log.Println("Cookie Failed", vwr.Auth, c)
-		ah.handleOAuthStart(w, req)
+	vd := v.GetData()
+	fmt.Fprintf(w, "You are logged in %s", vd.GetNick())
+}
+
+// logf - Log through Config.Logger when set otherwise the standard logger, safe on a nil client
+func (ah *Client) logf(format string, v ...interface{}) {
+	if ah != nil && ah.Config.Logger != nil {
+		ah.Config.Logger.Printf(format, v...)
 		return
 	}
-
-	http.SetCookie(w, vwr.Auth.SessionCookie)
-	fmt.Fprintf(w, "You are logged in %s", vwr.GetNick())
+	log.Printf(format, v...)
 }
 
 // Get will make Twitch API request with correct headers then attempt to decode JSON into jsonStruct
 // If you call it with a nil user or user without a token it will do request without auth
 func (ah *Client) Get(au *UserAuth, path string, jsonStruct interface{}) (string, error) {
-	log.Printf("Twitch Get: %s", path)
+	ah.logf("Twitch Get: %s", path)
 	path = twitchBase + path
 
 	req, err := http.NewRequest("GET", path, nil)
@@ -245,7 +311,7 @@ func (ah *Client) Get(au *UserAuth, path string, jsonStruct interface{}) (string
 	if jsonStruct != nil {
 		err = json.NewDecoder(resp.Body).Decode(jsonStruct)
 		if err != nil {
-			log.Printf("JSON ERROR %s\n %s", path, err.Error())
+			ah.logf("JSON ERROR %s\n %s", path, err.Error())
 		}
 		return "", err
 	}
@@ -273,30 +339,31 @@ func (ah *Client) adminHasAuthed() {
 
 	// Get All Followers slowly
 	// for a big channel with a million follows this will take 3 hours
-	go func() {
-		followChan := ah.Channel.GetAllFollowersSlow(ah.RoomID, time.Second, true)
-		for fList, ok := <-followChan; ok; fList, ok = <-followChan {
-			ah.Viewers.UpdateFollowers(fList)
-		}
-	}()
+	if ah.Config.Enable.Followers {
+		go func() {
+			followChan := ah.Channel.GetAllFollowersSlow(ah.RoomID, time.Second, true)
+			for fList, ok := <-followChan; ok; fList, ok = <-followChan {
+				ah.Viewers.UpdateFollowers(fList)
+			}
+		}()
+	}
+
+	ah.logCapabilities()
 
-	// Start up IRC Chat
-	if ah.AdminAuth.Scopes[scopeChatLogin] {
+	// Start up IRC Chat, anonymous chat started without waiting for the admin
+	if ah.Config.Enable.Chat && !ah.Config.AnonymousChat && ah.HasCapability("chat") {
 		go ah.startNewChat()
 	}
 
-	go ah.Heart.StartBeat()
+	if ah.Heart != nil {
+		go ah.Heart.StartBeat()
+	}
 
 	// PubSub
-	ah.PubSub, err = CreatePubSub(ah, PubSubTopicList{
-		//{Subject: psChanBits, Target: ah.RoomID},
-		//{Subject: psChanSubs, Target: ah.RoomID},
-		//{Subject: psVideoPlayback, Target: ah.RoomID},
-		//{Subject: psChatModActions, Target: ah.RoomID},
-		{Subject: psUserWhispers, Target: ah.AdminID},
-	})
-
-	go ah.PubSub.runningLoop()
+	if ah.Config.Enable.PubSub {
+		ah.PubSub, err = CreatePubSub(ah, ah.pubSubTopics())
+		go ah.PubSub.runningLoop()
+	}
 
 	// HACK :: Filthy Hack
 	// Allow a brief startup gap for responses ect...
@@ -307,29 +374,38 @@ func (ah *Client) adminHasAuthed() {
 
 func (ah *Client) startNewChat() {
 
-	c, err := createIrcClient(ah.AdminAuth, ah.Viewers, ah.IrcServerAddr)
+	var auth ircAuthProvider = ah.AdminAuth
+	if ah.Config.AnonymousChat {
+		auth = CreateAnonymousIrcAuth()
+	}
+
+	c, err := createIrcClient(auth, ah.Viewers, ah.Config.IrcServer, ah.Config.DataDir)
 	if err != nil {
-		log.Printf("Failed to Start New Chat %s", err.Error())
+		ah.logf("Failed to Start New Chat %s", err.Error())
 		return
 	}
 	ah.Chat = c
 	ah.Chat.weakClientRef = ah
 
 	// Make Connection
-	conn, err := net.Dial("tcp", c.Server)
+	conn, err := dialIrc(&ah.Config, c.Server)
 	if err != nil {
-		log.Printf("Chat Shutdown %s", err.Error())
+		ah.logf("Chat Shutdown %s", err.Error())
 		return
 	}
+	defer conn.Close()
 
 	err = ah.Chat.StartRunLoop(conn)
 	if err != nil {
-		log.Printf("Chat Shutdown %s", err.Error())
+		ah.logf("Chat Shutdown %s", err.Error())
 		return
 	}
 }
 
 // SayMsg - Say IRC Message
-func (ah *Client) SayMsg(line string) {
-	ah.Chat.WriteSayMsg(line)
+func (ah *Client) SayMsg(line string) error {
+	if ah.Chat == nil {
+		return fmt.Errorf("Chat has not started")
+	}
+	return ah.Chat.WriteSayMsg(line)
 }
@@ -43,6 +43,7 @@ const (
 	scopeChannelEditor            = "channel_editor"             // "channel_editor"             - Write channel metadata (game, status, etc).
 	scopeChannelFeedEdit          = "channel_feed_edit"          // "channel_feed_edit"          - Add posts and reactions to a channel feed.
 	scopeChannelFeedRead          = "channel_feed_read"          // "channel_feed_read"          - View a channel feed.
+	scopeChannelModerate          = "channel_moderate"           // "channel_moderate"           - Read moderator actions taken in your channel.
 	scopeChannelRead              = "channel_read"               // "channel_read"               - Read nonpublic channel information, including email address and stream key.
 	scopeChannelStream            = "channel_stream"             // "channel_stream"             - Reset a channel’s stream key.
 	scopeChannelSubscriptions     = "channel_subscriptions"      // "channel_subscriptions"      - Read all subscribers to your channel.
@@ -0,0 +1,391 @@
+package twitch
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultDataDir - Where secrets, logs and dumps live unless configured
+	DefaultDataDir = "./data"
+
+	defaultIrcServer = "irc.chat.twitch.tv:6667"
+)
+
+// IRC Transports
+const (
+	IrcTransportTCP       = "tcp"
+	IrcTransportTLS       = "tls"
+	IrcTransportWebSocket = "websocket"
+)
+
+// Config Environment Variables
+const (
+	ConfigEnvDomain       = "TWITCH_DOMAIN"
+	ConfigEnvRoom         = "TWITCH_ROOM"
+	ConfigEnvScopes       = "TWITCH_SCOPES"
+	ConfigEnvDataDir      = "TWITCH_DATA_DIR"
+	ConfigEnvIrcServer    = "TWITCH_IRC_SERVER"
+	ConfigEnvIrcTransport = "TWITCH_IRC_TRANSPORT"
+	ConfigEnvIrcBind      = "TWITCH_IRC_BIND"
+	ConfigEnvAnonymous    = "TWITCH_ANONYMOUS_CHAT"
+	ConfigEnvInsecureHTTP = "TWITCH_INSECURE_HTTP"
+	ConfigEnvHeartbeat    = "TWITCH_HEARTBEAT_RATE"
+	ConfigEnvDumpEvery    = "TWITCH_DUMP_EVERY"
+	ConfigEnvDumpKeep     = "TWITCH_DUMP_KEEP"
+	ConfigEnvDumpMaxAge   = "TWITCH_DUMP_MAX_AGE"
+	ConfigEnvPubSubTopics = "TWITCH_PUBSUB_TOPICS"
+	ConfigEnvViewerCache  = "TWITCH_VIEWER_CACHE"
+	ConfigEnvDisable      = "TWITCH_DISABLE" // Comma separated subsystem names
+)
+
+// ConfigDuration - time.Duration which reads "90s" style strings or seconds from JSON
+type ConfigDuration time.Duration
+
+// MarshalJSON - JSON Helper
+func (cd ConfigDuration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(time.Duration(cd).String())
+}
+
+// UnmarshalJSON - JSON Helper
+func (cd *ConfigDuration) UnmarshalJSON(b []byte) error {
+	var secs float64
+	if err := json.Unmarshal(b, &secs); err == nil {
+		*cd = ConfigDuration(secs * float64(time.Second))
+		return nil
+	}
+
+	s := ""
+	if err := json.Unmarshal(b, &s); err != nil {
+		return err
+	}
+
+	d, err := time.ParseDuration(s)
+	*cd = ConfigDuration(d)
+	return err
+}
+
+// ConfigSubsystems - Parts of the client which can be switched off when embedding
+type ConfigSubsystems struct {
+	Chat       bool `json:"chat"`
+	PubSub     bool `json:"pubsub"`
+	Heartbeat  bool `json:"heartbeat"`
+	Followers  bool `json:"followers"` // Slow background fetch of every follower
+	Webhooks   bool `json:"webhooks"`
+	LiveFeed   bool `json:"live_feed"`
+	Dumps      bool `json:"dumps"`       // Dump viewers every DumpEvery, without the database they are also loaded on start
+	Database   bool `json:"database"`    // Save viewers as they change, required for the viewer cache to spill to disk
+	Points     bool `json:"points"`      // Loyalty points and their chat commands
+	Chatters   bool `json:"chatters"`    // Poll the chatters list with the heartbeat, catches who IRC misses in big rooms
+	AlertQueue bool `json:"alert_queue"` // Paced one at a time alerts for overlays, controlled from the admin API
+}
+
+func (cs *ConfigSubsystems) set(name string, on bool) error {
+	switch strings.ToLower(strings.TrimSpace(name)) {
+	case "chat":
+		cs.Chat = on
+	case "pubsub":
+		cs.PubSub = on
+	case "heartbeat":
+		cs.Heartbeat = on
+	case "followers":
+		cs.Followers = on
+	case "webhooks":
+		cs.Webhooks = on
+	case "live_feed", "feed":
+		cs.LiveFeed = on
+	case "dumps":
+		cs.Dumps = on
+	case "database":
+		cs.Database = on
+	case "points":
+		cs.Points = on
+	case "chatters":
+		cs.Chatters = on
+	case "alert_queue", "queue":
+		cs.AlertQueue = on
+	default:
+		return fmt.Errorf("Unknown subsystem: %s", name)
+	}
+	return nil
+}
+
+// ConfigPoints - Loyalty points rates and bonuses
+type ConfigPoints struct {
+	PerMinute          Currency `json:"per_minute"`          // For every minute in the room
+	SubMultiplier      float64  `json:"sub_multiplier"`      // Applied to PerMinute for subs
+	FollowerMultiplier float64  `json:"follower_multiplier"` // Applied to PerMinute for followers, stacks with subs
+	PerHundredBits     Currency `json:"per_hundred_bits"`
+	SubBonus           Currency `json:"sub_bonus"` // Subs, resubs and gifting a sub
+	RaidBonus          Currency `json:"raid_bonus"`
+}
+
+// Config - Everything needed to create a Client
+type Config struct {
+	Domain    string   `json:"domain"` // Host and path we are served from "example.com/twitch/"
+	Room      IrcNick  `json:"room"`
+	Scopes    []string `json:"scopes"`
+	ForceAuth bool     `json:"force_auth"` // Ignore the saved admin token
+
+	DataDir      string      `json:"data_dir"`
+	IrcServer    string      `json:"irc_server"` // Empty uses irc_server from the secrets file for tcp or the twitch default
+	IrcTransport string      `json:"irc_transport"`
+	IrcBindAddr  string      `json:"irc_bind"` // Local IP to dial chat from, for VPNs
+	TLSConfig    *tls.Config `json:"-"`        // Used by the tls and websocket transports
+
+	AnonymousChat bool `json:"anonymous_chat"` // Read only justinfan login, chat starts without the admin
+	InsecureHTTP  bool `json:"insecure_http"`  // Served over plain http so cookies are sent without the Secure flag
+
+	HeartbeatRate ConfigDuration `json:"heartbeat_rate"`
+	DumpEvery     ConfigDuration `json:"dump_every"`
+	DumpKeep      int            `json:"dump_keep"`     // Newest dumps kept, 0 keeps them all
+	DumpMaxAge    ConfigDuration `json:"dump_max_age"`  // Older dumps are removed, 0 never expires, the newest always stays
+	PubSubTopics  []string       `json:"pubsub_topics"` // Subjects, the target is filled in from the room or admin
+
+	ViewerCacheSize int `json:"viewer_cache_size"` // Viewers kept in memory, the rest stay in the database, 0 keeps everyone
+
+	Points ConfigPoints `json:"points"`
+
+	Enable ConfigSubsystems `json:"enable"`
+
+	HTTPClient WebClient   `json:"-"`
+	Logger     *log.Logger `json:"-"` // Client, chat, pubsub and viewer logs, nil uses the standard logger which is never changed
+}
+
+// DefaultConfig - Everything on with the same timings as before Config existed
+func DefaultConfig() Config {
+	return Config{
+		Scopes:        DefaultStreamerScope,
+		DataDir:       DefaultDataDir,
+		IrcTransport:  IrcTransportTCP,
+		HeartbeatRate: ConfigDuration(heartBeatRate),
+		DumpEvery:     ConfigDuration(heartDumpEvery),
+		DumpKeep:      DefaultDumpKeep,
+		DumpMaxAge:    ConfigDuration(DefaultDumpMaxAge),
+		PubSubTopics:  []string{psUserWhispers},
+
+		ViewerCacheSize: DefaultViewerCacheSize,
+		Points: ConfigPoints{
+			PerMinute:          DefaultPointsPerMinute,
+			SubMultiplier:      2,
+			FollowerMultiplier: 1.5,
+			PerHundredBits:     100,
+			SubBonus:           500,
+			RaidBonus:          250,
+		},
+		Enable: ConfigSubsystems{
+			Chat:       true,
+			PubSub:     true,
+			Heartbeat:  true,
+			Followers:  true,
+			Webhooks:   true,
+			LiveFeed:   true,
+			Dumps:      true,
+			Database:   true,
+			Points:     true,
+			Chatters:   true,
+			AlertQueue: true,
+		},
+	}
+}
+
+// LoadConfig - DefaultConfig overlaid with a JSON file and then the environment, empty filename skips the file
+func LoadConfig(filename string) (Config, error) {
+	cfg := DefaultConfig()
+
+	if filename != "" {
+		fileData, err := ioutil.ReadFile(filename)
+		if err != nil {
+			return cfg, err
+		}
+
+		err = json.Unmarshal(fileData, &cfg)
+		if err != nil {
+			return cfg, fmt.Errorf("Unable to parse config %s: %s", filename, err)
+		}
+	}
+
+	err := cfg.ApplyEnv()
+	if err != nil {
+		return cfg, err
+	}
+
+	return cfg, cfg.Validate()
+}
+
+// ApplyEnv - Override with any TWITCH_ environment variables which are set
+func (cfg *Config) ApplyEnv() error {
+	if v := os.Getenv(ConfigEnvDomain); v != "" {
+		cfg.Domain = v
+	}
+	if v := os.Getenv(ConfigEnvRoom); v != "" {
+		cfg.Room = IrcNick(v)
+	}
+	if v := os.Getenv(ConfigEnvScopes); v != "" {
+		cfg.Scopes = strings.Split(v, ",")
+	}
+	if v := os.Getenv(ConfigEnvDataDir); v != "" {
+		cfg.DataDir = v
+	}
+	if v := os.Getenv(ConfigEnvIrcServer); v != "" {
+		cfg.IrcServer = v
+	}
+	if v := os.Getenv(ConfigEnvIrcTransport); v != "" {
+		cfg.IrcTransport = v
+	}
+	if v := os.Getenv(ConfigEnvIrcBind); v != "" {
+		cfg.IrcBindAddr = v
+	}
+	if v := os.Getenv(ConfigEnvAnonymous); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s", ConfigEnvAnonymous, err)
+		}
+		cfg.AnonymousChat = on
+	}
+	if v := os.Getenv(ConfigEnvInsecureHTTP); v != "" {
+		on, err := strconv.ParseBool(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s", ConfigEnvInsecureHTTP, err)
+		}
+		cfg.InsecureHTTP = on
+	}
+	if v := os.Getenv(ConfigEnvPubSubTopics); v != "" {
+		cfg.PubSubTopics = strings.Split(v, ",")
+	}
+	if v := os.Getenv(ConfigEnvViewerCache); v != "" {
+		size, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s", ConfigEnvViewerCache, err)
+		}
+		cfg.ViewerCacheSize = size
+	}
+
+	if v := os.Getenv(ConfigEnvDumpKeep); v != "" {
+		keep, err := strconv.Atoi(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s", ConfigEnvDumpKeep, err)
+		}
+		cfg.DumpKeep = keep
+	}
+
+	for env, dst := range map[string]*ConfigDuration{
+		ConfigEnvHeartbeat:  &cfg.HeartbeatRate,
+		ConfigEnvDumpEvery:  &cfg.DumpEvery,
+		ConfigEnvDumpMaxAge: &cfg.DumpMaxAge,
+	} {
+		v := os.Getenv(env)
+		if v == "" {
+			continue
+		}
+		if err := dst.UnmarshalJSON([]byte(strconv.Quote(v))); err != nil {
+			return fmt.Errorf("%s: %s", env, err)
+		}
+	}
+
+	if v := os.Getenv(ConfigEnvDisable); v != "" {
+		for _, name := range strings.Split(v, ",") {
+			if err := cfg.Enable.set(name, false); err != nil {
+				return fmt.Errorf("%s: %s", ConfigEnvDisable, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// Validate - Check the config can start a client and fill in empty defaults
+func (cfg *Config) Validate() error {
+	if strings.Index(cfg.Domain, "/") < 0 {
+		return fmt.Errorf("Domain must include the serving path [%s]", cfg.Domain)
+	}
+
+	if cfg.Room == "" {
+		return fmt.Errorf("Config needs a room to join")
+	}
+
+	for _, s := range cfg.Scopes {
+		valid := false
+		for _, v := range ValidScopes {
+			valid = valid || s == v
+		}
+		if !valid {
+			return fmt.Errorf("Unknown scope: %s", s)
+		}
+	}
+
+	if cfg.DataDir == "" {
+		cfg.DataDir = DefaultDataDir
+	}
+
+	switch cfg.IrcTransport {
+	case "":
+		cfg.IrcTransport = IrcTransportTCP
+	case IrcTransportTCP, IrcTransportTLS:
+	case IrcTransportWebSocket:
+		if cfg.IrcServer != "" && !strings.HasPrefix(cfg.IrcServer, "wss://") && !strings.HasPrefix(cfg.IrcServer, "ws://") {
+			return fmt.Errorf("WebSocket IRC server must be a ws:// or wss:// url [%s]", cfg.IrcServer)
+		}
+	default:
+		return fmt.Errorf("Unknown IRC transport: %s", cfg.IrcTransport)
+	}
+
+	if _, err := parseBindAddr(cfg.IrcBindAddr); err != nil {
+		return fmt.Errorf("Invalid IRC bind address %s: %s", cfg.IrcBindAddr, err)
+	}
+
+	if cfg.Enable.Heartbeat && cfg.HeartbeatRate <= 0 {
+		return fmt.Errorf("Heartbeat rate must be positive")
+	}
+
+	if cfg.Enable.Dumps && cfg.DumpEvery < cfg.HeartbeatRate {
+		return fmt.Errorf("Dump interval %s is shorter than the heartbeat %s",
+			time.Duration(cfg.DumpEvery), time.Duration(cfg.HeartbeatRate))
+	}
+
+	for _, t := range cfg.PubSubTopics {
+		switch t {
+		case psChanBits, psChanSubs, psVideoPlayback, psChatModActions, psUserWhispers:
+		default:
+			return fmt.Errorf("Unknown PubSub topic: %s", t)
+		}
+	}
+
+	if cfg.DumpKeep < 0 || cfg.DumpMaxAge < 0 {
+		return fmt.Errorf("Dump retention cannot be negative")
+	}
+
+	if cfg.ViewerCacheSize < 0 {
+		return fmt.Errorf("Viewer cache size cannot be negative")
+	}
+
+	p := cfg.Points
+	if p.PerMinute < 0 || p.SubMultiplier < 0 || p.FollowerMultiplier < 0 ||
+		p.PerHundredBits < 0 || p.SubBonus < 0 || p.RaidBonus < 0 {
+		return fmt.Errorf("Points rates cannot be negative")
+	}
+
+	if cfg.HTTPClient == nil {
+		cfg.HTTPClient = &http.Client{}
+	}
+
+	return nil
+}
+
+// dataPath - File in the data directory
+func (cfg *Config) dataPath(pattern string, args ...interface{}) string {
+	dir := cfg.DataDir
+	if dir == "" {
+		dir = DefaultDataDir
+	}
+	return filepath.Join(dir, fmt.Sprintf(pattern, args...))
+}
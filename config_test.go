@@ -0,0 +1,84 @@
+package twitch
+
+import (
+	"bytes"
+	"log"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestConfig(t *testing.T) {
+	f, err := os.Create("config_test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString(`{"domain":"example.com/twitch/","room":"kimau","heartbeat_rate":"30s","dump_every":600,
+		"pubsub_topics":["whispers","video-playback"],"enable":{"chat":true,"pubsub":false}}`)
+	f.Close()
+	defer os.Remove("config_test.json")
+
+	os.Setenv(ConfigEnvDisable, "chat")
+	os.Setenv(ConfigEnvIrcTransport, IrcTransportTLS)
+	defer os.Setenv(ConfigEnvDisable, "")
+	defer os.Setenv(ConfigEnvIrcTransport, "")
+
+	cfg, err := LoadConfig("config_test.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if cfg.Room != "kimau" || cfg.DataDir != DefaultDataDir || cfg.HTTPClient == nil {
+		t.Errorf("Defaults not filled: %#v", cfg)
+	}
+	if time.Duration(cfg.HeartbeatRate) != time.Second*30 || time.Duration(cfg.DumpEvery) != time.Minute*10 {
+		t.Errorf("Durations wrong: %s %s", time.Duration(cfg.HeartbeatRate), time.Duration(cfg.DumpEvery))
+	}
+	if cfg.Enable.Chat || !cfg.Enable.Heartbeat || cfg.Enable.PubSub {
+		t.Errorf("Subsystems wrong: %#v", cfg.Enable)
+	}
+	if cfg.IrcTransport != IrcTransportTLS {
+		t.Errorf("Env transport not applied: %s", cfg.IrcTransport)
+	}
+	if !strings.HasSuffix(cfg.dataPath(dumpFilePattern, "kimau", 1500000000), "dump_kimau_1500000000.bin") {
+		t.Errorf("Bad data path %s", cfg.dataPath(dumpFilePattern, "kimau", 1500000000))
+	}
+
+	bad := []func(c *Config){
+		func(c *Config) { c.Domain = "example.com" },
+		func(c *Config) { c.Room = "" },
+		func(c *Config) { c.Scopes = []string{"bogus"} },
+		func(c *Config) { c.IrcTransport = "carrier-pigeon" },
+		func(c *Config) { c.PubSubTopics = []string{"bogus"} },
+		func(c *Config) { c.DumpEvery = ConfigDuration(time.Second) },
+	}
+	for i, mod := range bad {
+		c := cfg
+		mod(&c)
+		if err := c.Validate(); err == nil {
+			t.Errorf("Bad config %d passed validation", i)
+		}
+	}
+}
+
+func TestConfigLogger(t *testing.T) {
+	std := log.Writer()
+
+	var buf bytes.Buffer
+	cfg := DefaultConfig()
+	cfg.Logger = log.New(&buf, "twitch ", 0)
+	ah := &Client{Config: cfg}
+
+	ah.logf("Hello %s", "kimau")
+	if buf.String() != "twitch Hello kimau\n" {
+		t.Errorf("Client log not sent to the config logger: %q", buf.String())
+	}
+	if log.Writer() != std {
+		t.Errorf("Standard logger was changed")
+	}
+
+	// Chat without a client falls back to the standard logger
+	var none *Client
+	none.logf("No client")
+}
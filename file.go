@@ -2,95 +2,144 @@ package twitch
 
 import (
 	"bufio"
-	"encoding/gob"
 	"encoding/json"
 	"fmt"
-	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
 	"regexp"
 	"strconv"
 	"time"
 )
 
 const (
-	dumpFilePattern = "./data/dump_%s.bin"
-	chatFilePattern = "./data/%s_chat.log"
+	dumpFilePattern       = "dump_%s_%d.bin" // Room and unix time
+	legacyDumpFilePattern = "dump_%s.bin"
+	chatFilePattern       = "%s_chat.log"
+	ircLogFile            = "_irc.log"
+	alertJournalPattern   = "%s_alerts.log"
+	presenceLogPattern    = "%s_presence.log"
+	webhookDeadPattern    = "%s_webhook_dead.log"
+	accessGrantsPattern   = "%s_roles.json"
 )
 
 var (
 	regexChatLogFileMatch = regexp.MustCompile("([[:word:]]*)_chat.log")
-	regexDumpFileMatch    = regexp.MustCompile("dump_([[:word:]]*).bin")
+	regexDumpFileMatch    = regexp.MustCompile("^dump_([[:word:]]+)_([0-9]+)\\.bin$")
+	regexLegacyDumpMatch  = regexp.MustCompile("^dump_([[:word:]]+)\\.bin$")
 	regexChatNewLog       = regexp.MustCompile("[\\+\\-]* New Log \\[([[:word:]]*)\\] [\\+\\-]* ([0-9].*)")
 )
 
 type tokenData struct {
-	ClientID      string `json:"client_id"`
-	ClientSecret  string `json:"client_secret"`
-	IrcServerAddr string `json:"irc_server"`
+	ClientID      string          `json:"client_id"`
+	ClientSecret  string          `json:"client_secret"`
+	IrcServerAddr string          `json:"irc_server"`
+	Webhooks      []WebhookTarget `json:"webhooks"`
 }
 
-func (ah *Client) loadSecrets() {
-	fileData, err := ioutil.ReadFile("./data/twitch_secret.json")
+func (ah *Client) loadSecrets() error {
+	sb, err := secretBox(ah.Config.DataDir)
 	if err != nil {
-		panic(fmt.Sprintf("Failed to load token data from ./data/secret.json: \n%s", err))
+		return fmt.Errorf("Failed to load secret key: \n%s", err)
+	}
+
+	filename := ah.Config.dataPath(secretsFile)
+	fileData, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return fmt.Errorf("Failed to load token data from %s: \n%s", filename, err)
 	}
 
 	sd := tokenData{}
 	json.Unmarshal(fileData, &sd)
 
+	wasPlain := !IsSealed(sd.ClientSecret)
+	sd.ClientSecret, err = sb.openString(sd.ClientSecret)
+	if err != nil {
+		return fmt.Errorf("Failed to decrypt client secret: \n%s", err)
+	}
+
+	for i, wt := range sd.Webhooks {
+		wasPlain = wasPlain || (wt.Secret != "" && !IsSealed(wt.Secret))
+		sd.Webhooks[i].Secret, err = sb.openString(wt.Secret)
+		if err != nil {
+			ah.logf("Failed to decrypt webhook secret %s: %s", wt.Name, err)
+		}
+	}
+
 	ah.tokenData = &sd
+
+	// Seal anything still in plain text
+	if wasPlain && sd.ClientSecret != "" {
+		err = ah.saveSecrets(sb)
+		if err != nil {
+			ah.logf("Unable to seal %s: %s", filename, err)
+		} else {
+			ah.logf("Sealed plain text secrets in %s", filename)
+		}
+	}
+
+	return nil
 }
 
 func (ah *Client) loadToken() {
-	fileData, err := ioutil.ReadFile("./data/twitch_secret_token.json")
+	sb, err := secretBox(ah.Config.DataDir)
 	if err != nil {
-		log.Printf("Failed to load saved auth token")
+		ah.logf("Failed to load secret key: %s", err)
+		return
+	}
+
+	fileData, wasSealed, err := sb.readSealedFile(ah.Config.dataPath(adminTokenFile))
+	if err != nil {
+		ah.logf("Failed to load saved auth token: %s", err)
 		return
 	}
 
 	userAuthTemp := UserAuth{}
 	err = json.Unmarshal(fileData, &userAuthTemp)
 	if err != nil {
-		log.Printf("-------------------\nFailed to Unmarshall auth token.\n %s", err)
+		ah.logf("-------------------\nFailed to Unmarshall auth token.\n %s", err)
 		return
 	}
 
 	// Check Token
 	err = ah.getRootToken(&userAuthTemp)
 	if err != nil {
-		log.Printf("--------FAIL TOKEN-----------\n%s\n---\n%v", err, ah.AdminAuth)
+		ah.logf("--------FAIL TOKEN-----------\n%s\n---\n%v", err, ah.AdminAuth)
 		return
 	}
 
 	// Token is Valid
 	if userAuthTemp.Token != nil {
 		*ah.AdminAuth = userAuthTemp
+		if !wasSealed {
+			if err = ah.saveToken(); err != nil {
+				ah.logf("Unable to seal auth token: %s", err)
+			}
+		}
 		ah.adminHasAuthed()
 	}
 }
 
 func (ah *Client) saveToken() error {
-	b, err := json.Marshal(*ah.AdminAuth)
-
+	sb, err := secretBox(ah.Config.DataDir)
 	if err != nil {
 		return err
 	}
 
-	err = ioutil.WriteFile("./data/twitch_secret_token.json", b, os.ModePerm)
+	b, err := json.Marshal(*ah.AdminAuth)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	return sb.writeSealedFile(ah.Config.dataPath(adminTokenFile), b)
 }
 
 // getChatLogWriter - Get Permant Chat Log File
-func getChatLogWriter(roomName IrcNick) *os.File {
+func getChatLogWriter(dataDir string, roomName IrcNick) *os.File {
 	// Messy that we don't close this
 
-	filename := fmt.Sprintf("./data/%s_chat.log", roomName)
+	filename := filepath.Join(dataDir, fmt.Sprintf(chatFilePattern, roomName))
 
 	w, e := os.OpenFile(
 		filename,
@@ -106,51 +155,59 @@ func getChatLogWriter(roomName IrcNick) *os.File {
 var localIrcMsgStoreFile *os.File
 
 // localIrcMsgStore - Simply file Write for raw messages
-func localIrcMsgStore() *os.File {
+func localIrcMsgStore(dataDir string) *os.File {
 	if localIrcMsgStoreFile != nil {
 		return localIrcMsgStoreFile
 	}
 
 	// Messy that we don't close this
 	var err error
+	filename := filepath.Join(dataDir, ircLogFile)
 	localIrcMsgStoreFile, err = os.OpenFile(
-		"./data/_irc.log",
+		filename,
 		os.O_CREATE|os.O_APPEND, os.ModePerm)
 
 	if err != nil {
-		panic(fmt.Sprintf("Unable to create irc log: %s\n%s", "./data/_irc.log", err))
+		panic(fmt.Sprintf("Unable to create irc log: %s\n%s", filename, err))
 	}
 
 	return localIrcMsgStoreFile
 }
 
-// DumpViewers - Dump the Internal State to File
+// DumpViewers - Dump the Internal State to a new timestamped file and prune old dumps
 func (ah *Client) DumpViewers() error {
-	f, err := os.Create(fmt.Sprintf(dumpFilePattern, ah.RoomName))
+	sb, err := secretBox(ah.Config.DataDir)
 	if err != nil {
 		return err
 	}
 
-	enc := gob.NewEncoder(f)
-	for _, vid := range ah.Viewers.AllKeys() {
-		v, _ := ah.Viewers.GetData(vid)
-		err = enc.Encode(v)
-		if err != nil {
-			f.Close()
-			return err
-		}
+	hdr := dumpHeader{
+		Version: dumpFormatVersion,
+		Room:    ah.RoomName,
+		RoomID:  ah.RoomID,
+		Created: time.Now(),
 	}
+	filename := ah.Config.dataPath(dumpFilePattern, ah.RoomName, hdr.Created.Unix())
 
-	log.Printf("Dumped data to file: %s", f.Name())
-	f.Close()
-	return nil
+	err = writeViewerDump(filename, hdr, sb, ah.Viewers.forEachData)
+	if err != nil {
+		return err
+	}
+	ah.logf("Dumped data to file: %s", filename)
+
+	_, err = pruneViewerDumps(ah.Config.DataDir, ah.RoomName, ah.Config.DumpKeep, time.Duration(ah.Config.DumpMaxAge))
+	return err
 }
 
 // GetDumpListing - Listing of All Fumps in this folder
 func GetDumpListing(chanName IrcNick) [][]string {
+	return getDumpListing(DefaultDataDir, chanName)
+}
+
+func getDumpListing(dataDir string, chanName IrcNick) [][]string {
 	sList := [][]string{}
 
-	files, err := ioutil.ReadDir("./data/")
+	files, err := ioutil.ReadDir(dataDir)
 	if err != nil {
 		log.Printf("Unable to ReadDir: %s", err)
 		return nil
@@ -174,7 +231,7 @@ func GetDumpListing(chanName IrcNick) [][]string {
 func GetChatLogListing() []string {
 	sList := []string{}
 
-	files, err := ioutil.ReadDir("./data/")
+	files, err := ioutil.ReadDir(DefaultDataDir)
 	if err != nil {
 		log.Printf("Unable to ReadDir: %s", err)
 		return nil
@@ -192,27 +249,15 @@ func GetChatLogListing() []string {
 
 // LoadMostRecentViewerDump - Load the most recent User Data for User
 func LoadMostRecentViewerDump(chanName IrcNick) (*HistoricViewerData, error) {
-	listings := GetDumpListing(chanName)
-	bigNum := 0
-
-	// Get Highest Num
-	for _, item := range listings {
-		v, err := strconv.Atoi(item[2])
-		if err != nil {
-			log.Printf("Cannot Convert: %s", err)
-			continue
-		}
-
-		if v > bigNum {
-			bigNum = v
-		}
-	}
+	return loadMostRecentViewerDump(DefaultDataDir, chanName)
+}
 
-	if bigNum == 0 {
+func loadMostRecentViewerDump(dataDir string, chanName IrcNick) (*HistoricViewerData, error) {
+	fileName := mostRecentDumpFile(dataDir, chanName)
+	if fileName == "" {
 		return nil, nil
 	}
 
-	fileName := fmt.Sprintf(dumpFilePattern, chanName, bigNum)
 	return LoadViewerDumpForAnalysis(fileName)
 }
 
@@ -220,51 +265,45 @@ func LoadMostRecentViewerDump(chanName IrcNick) (*HistoricViewerData, error) {
 func LoadViewerDumpForAnalysis(filename string) (*HistoricViewerData, error) {
 	var hvd HistoricViewerData
 
-	res := regexDumpFileMatch.FindStringSubmatch(filename)
-	if len(res) != 3 {
-		return nil, fmt.Errorf("Filename is invalid format lazy I know: [%s]", filename)
-	}
+	base := filepath.Base(filename)
+	if res := regexDumpFileMatch.FindStringSubmatch(base); len(res) == 3 {
+		hvd.Name = IrcNick(res[1])
 
-	// Name
-	hvd.Name = IrcNick(res[1])
+		unixTime, err := strconv.ParseInt(res[2], 10, 64)
+		if err != nil {
+			return nil, err
+		}
+		hvd.Timestamp = time.Unix(unixTime, 0)
+	} else if res := regexLegacyDumpMatch.FindStringSubmatch(base); len(res) == 2 {
+		hvd.Name = IrcNick(res[1])
 
-	// Time
-	unixTime, err := strconv.ParseInt(res[2], 10, 64)
-	if err != nil {
-		return nil, err
+		info, err := os.Stat(filename)
+		if err != nil {
+			return nil, err
+		}
+		hvd.Timestamp = info.ModTime()
+	} else {
+		return nil, fmt.Errorf("Filename is invalid format lazy I know: [%s]", filename)
 	}
-	hvd.Timestamp = time.Unix(unixTime, 0)
-	hvd.ViewerData = make(map[ID]ViewerData)
 
-	// Open file for Decoding
-	f, err := os.Open(filename)
+	hdr, vdMap, err := readViewerDump(filename)
 	if err != nil {
 		return nil, err
 	}
-	defer f.Close()
-	dec := gob.NewDecoder(f)
-
-	// Pull out all Viewers
-	for {
-		v := ViewerData{}
-		err := dec.Decode(&v)
-
-		if err != nil {
-			if err == io.EOF {
-				return &hvd, nil
-			}
-			return nil, err
-		}
 
-		hvd.ViewerData[v.TwitchID] = v
+	hvd.ViewerData = vdMap
+	if hdr.Version >= 2 {
+		hvd.RoomID = hdr.RoomID
+		hvd.Timestamp = hdr.Created
 	}
+	return &hvd, nil
 }
 
 // LoadChatForAnalysis - Load Chat Log for Analysis
 func LoadChatForAnalysis(room IrcNick) (*HistoricChatLog, error) {
 	var hc HistoricChatLog
 
-	filename := fmt.Sprintf(chatFilePattern, room)
+	filename := filepath.Join(DefaultDataDir, fmt.Sprintf(chatFilePattern, room))
 
 	res := regexChatLogFileMatch.FindStringSubmatch(filename)
 	if len(res) != 2 {
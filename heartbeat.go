@@ -2,7 +2,6 @@ package twitch
 
 import (
 	"fmt"
-	"log"
 	"sync"
 	"time"
 )
@@ -34,6 +33,8 @@ type Heartbeat struct {
 	prevFollowCount int
 	followers       []ChannelFollow
 
+	chatters chattersPoll
+
 	internalBeat *time.Ticker
 	client       *Client
 }
@@ -45,23 +46,41 @@ func (heart *Heartbeat) StartBeat() {
 
 	// First Beat
 	heart.beat(time.Now())
+	if heart.client.Config.Enable.Chatters {
+		heart.pollChatters()
+	}
+
+	rate := time.Duration(heart.client.Config.HeartbeatRate)
+	if rate <= 0 {
+		rate = heartBeatRate
+	}
+	dumpEvery := time.Duration(heart.client.Config.DumpEvery)
 
 	timeSinceDump := time.Duration(0)
-	heart.internalBeat = time.NewTicker(heartBeatRate)
+	heart.internalBeat = time.NewTicker(rate)
 
 	// Beat every X minutes
 	for ts := range heart.internalBeat.C {
 		heart.beat(ts)
+		if heart.client.Config.Enable.Chatters {
+			heart.pollChatters()
+		}
 
 		// Dumping to File
-		timeSinceDump += heartBeatRate
-		if timeSinceDump > heartDumpEvery {
+		timeSinceDump += rate
+		if timeSinceDump > dumpEvery {
 			timeSinceDump = 0
-			err := heart.client.DumpViewers()
-			if err != nil {
-				fmt.Printf("DUMP ERROR: %s", err)
+			if heart.client.Config.Enable.Dumps {
+				err := heart.client.DumpViewers()
+				if err != nil {
+					fmt.Printf("DUMP ERROR: %s", err)
+				}
 			}
 
+			err := heart.client.Viewers.maintainDB()
+			if err != nil {
+				heart.client.logf("Viewer database: %s", err)
+			}
 		}
 	}
 }
@@ -78,7 +97,7 @@ func (heart *Heartbeat) GetAllBeats() []HeartbeatData {
 	heart.heartLock.RLock()
 	defer heart.heartLock.RUnlock()
 
-	log.Printf("Beats Source %d", len(heart.beats))
+	heart.client.logf("Beats Source %d", len(heart.beats))
 	return heart.beats[0:]
 }
 
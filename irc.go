@@ -1,11 +1,14 @@
 package twitch
 
 import (
+	"errors"
 	"fmt"
 	"log"
 	"regexp"
 	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"io"
@@ -20,8 +23,10 @@ const (
 	defaultNickPadLength = 14
 )
 
-// needs to bind to real address for VPN
 var (
+	// ErrChatBusy - Returned by TrySayMsg when the send queue is full
+	ErrChatBusy = errors.New("Chat send queue is full")
+
 	IrcVerboseMode = false
 	regexHostMatch = regexp.MustCompile("([[:word:]]+) ([0-9]+|-)")
 
@@ -34,19 +39,24 @@ var (
 
 // Chat - IRC Chat interface
 type Chat struct {
-	Server  string
-	config  irc.ClientConfig
-	limiter *rate.Limiter
+	Server   string
+	ReadOnly bool // Anonymous login, sending returns ErrChatReadOnly
+	config   irc.ClientConfig
+	limiter  *rate.Limiter
 
-	mode   chatMode
-	InRoom map[IrcNick]*Viewer
+	mode     chatMode
+	roomLock sync.RWMutex
+	inRoom   map[IrcNick]*Viewer
 
 	messageOfTheDay []string
 	nameReplyList   []IrcNick
 
-	logger *chatLogInteral
+	logger  *chatLogInteral
+	dataDir string
 
-	sayMsgPipe chan string
+	sayMsgPipe  chan string
+	pointsTop   pointsCooldown
+	pointsUsers pointsCooldowns
 
 	viewers       viewerProvider
 	irc           *irc.Client
@@ -57,7 +67,7 @@ func init() {
 
 }
 
-func createIrcClient(auth ircAuthProvider, vp viewerProvider, serverAddr string) (*Chat, error) {
+func createIrcClient(auth ircAuthProvider, vp viewerProvider, serverAddr string, dataDir string) (*Chat, error) {
 
 	log.Println("Creating IRC Client")
 
@@ -76,10 +86,12 @@ func createIrcClient(auth ircAuthProvider, vp viewerProvider, serverAddr string)
 			User: "Username",
 			Name: "Full Name",
 		},
-		viewers: vp,
-		InRoom:  make(map[IrcNick]*Viewer),
+		ReadOnly: isAnonymousNick(nick),
+		viewers:  vp,
+		inRoom:   make(map[IrcNick]*Viewer),
+		dataDir:  dataDir,
 
-		logger: startChatLogPump(roomNick),
+		logger: startChatLogPump(roomNick, dataDir),
 	}
 
 	chat.Logf(LogCatSilent, "+------------ New Log [%s] ------------+ %s",
@@ -101,26 +113,26 @@ func (c *Chat) ircOutMsgPump() {
 		select {
 		case msg, ok := <-c.sayMsgPipe:
 			if !ok {
-				log.Println("IRC Out Msg Pump Closed")
+				c.weakClientRef.logf("IRC Out Msg Pump Closed")
 				return
 			}
 
 			err := c.irc.Write(msg)
 			if err != nil {
-				log.Printf("Write Raw Failed: %s\n %s", msg, err.Error())
+				c.weakClientRef.logf("Write Raw Failed: %s\n %s", msg, err.Error())
 			}
 
 			numMsgs++
 
 			if numMsgs >= maxLimitInPeriod {
 				// Block on Limiter
-				log.Printf("-- IRC RATE LIMIT HIT --")
+				c.weakClientRef.logf("-- IRC RATE LIMIT HIT --")
 				<-limiter
 				numMsgs = 0
 			}
 		case _, ok := <-limiter:
 			if !ok {
-				log.Println("IRC Out Msg Pump Limiter Closed")
+				c.weakClientRef.logf("IRC Out Msg Pump Limiter Closed")
 				return
 			}
 			numMsgs = 0
@@ -130,31 +142,95 @@ func (c *Chat) ircOutMsgPump() {
 }
 
 func (c *Chat) tickRoomActive() {
-	log.Println("Tick room Active")
-	for _, v := range c.InRoom {
-		c.activeInRoom(v)
+	c.weakClientRef.logf("Tick room Active")
+	c.roomLock.RLock()
+	vList := make([]*Viewer, 0, len(c.inRoom))
+	for _, v := range c.inRoom {
+		vList = append(vList, v)
+	}
+	c.roomLock.RUnlock()
+
+	for _, v := range vList {
+		c.activeInRoom(v, "")
 	}
 }
 
-func (c *Chat) partRoom(v *Viewer) {
-	chatter := c.activeInRoom(v)
+// IsInRoom - Is the nick currently in the channel
+func (c *Chat) IsInRoom(nick IrcNick) bool {
+	c.roomLock.RLock()
+	defer c.roomLock.RUnlock()
+
+	_, ok := c.inRoom[nick]
+	return ok
+}
+
+// InRoomNicks - Everyone currently in the channel
+func (c *Chat) InRoomNicks() []IrcNick {
+	c.roomLock.RLock()
+	defer c.roomLock.RUnlock()
+
+	nList := make([]IrcNick, 0, len(c.inRoom))
+	for n := range c.inRoom {
+		nList = append(nList, n)
+	}
+	return nList
+}
+
+func (c *Chat) roomViewer(nick IrcNick) (*Viewer, bool) {
+	c.roomLock.RLock()
+	defer c.roomLock.RUnlock()
+
+	v, ok := c.inRoom[nick]
+	return v, ok
+}
+
+func (c *Chat) partRoom(v *Viewer, source string) {
+	chatter := c.activeInRoom(v, source)
 
 	c.Logf(LogCatSystem, "Part %s", chatter.Nick)
-	delete(c.InRoom, chatter.Nick)
+	c.roomLock.Lock()
+	delete(c.inRoom, chatter.Nick)
+	c.roomLock.Unlock()
+
+	if pl := c.presenceLog(); pl != nil {
+		pl.Leave(chatter.Nick, source, time.Now())
+	}
 }
 
-func (c *Chat) activeInRoom(v *Viewer) Chatter {
+// activeInRoom - Credit time in the room, source is the evidence if this is an arrival
+func (c *Chat) activeInRoom(v *Viewer, source string) Chatter {
+	v.touch()
 	chatter := v.CreateChatter()
 
-	_, ok := c.InRoom[chatter.Nick]
+	c.roomLock.Lock()
+	_, ok := c.inRoom[chatter.Nick]
 	if !ok {
-		c.InRoom[chatter.Nick] = v
+		c.inRoom[chatter.Nick] = v
+	}
+	c.roomLock.Unlock()
+
+	now := time.Now()
+	v.recordSeen(now)
+	pc, pointsOn := c.pointsConfig()
+	if !ok {
+		// Time away is not time in channel
+		chatter.LastActive = now
+		v.SetChatter(chatter)
+		if pointsOn {
+			v.startWatch(now)
+		}
+		if pl := c.presenceLog(); pl != nil {
+			pl.Arrive(v.GetData().TwitchID, chatter.Nick, source, now)
+		}
 		return chatter
 	}
 
+	chatter.updateTime()
 	v.SetChatter(chatter)
 
-	// log.Printf("Chat: ++Awarded++ %s : %s for total of %s", nick, timeSince, v.Chatter.TimeInChannel)
+	if pointsOn {
+		v.awardWatchTime(now, pc)
+	}
 
 	return chatter
 }
@@ -170,12 +246,12 @@ func (c *Chat) StartRunLoop(ircConn io.ReadWriter) error {
 	if IrcVerboseMode {
 		// In Verbose mode log all messages
 		c.irc.Reader.DebugCallback = func(m string) {
-			log.Printf("IRC (V) >> %s", m)
+			c.weakClientRef.logf("IRC (V) >> %s", m)
 		}
 
 		c.irc.Writer.DebugCallback = func(m string) {
 			c.limiter.Limit()
-			log.Printf("IRC (V) << %s", m)
+			c.weakClientRef.logf("IRC (V) << %s", m)
 		}
 
 	} else {
@@ -185,39 +261,67 @@ func (c *Chat) StartRunLoop(ircConn io.ReadWriter) error {
 		}
 	}
 
-	log.Println("IRC Connected")
+	c.weakClientRef.logf("IRC Connected")
 
 	err := c.irc.Run()
 	if err != nil {
 		c.irc = nil
 	}
 
+	// Whoever is here now will be listed again after the reconnect
+	c.leaveRoom(PresenceDisconnect)
+
 	return err
 }
 
 // WriteRawIrcMsg - Writes a raw IRC message
-func (c *Chat) WriteRawIrcMsg(msg string) {
+func (c *Chat) WriteRawIrcMsg(msg string) error {
+	if c.ReadOnly {
+		return ErrChatReadOnly
+	}
+
 	c.sayMsgPipe <- msg
+	return nil
 }
 
 // WriteSayMsg - Writes a PRIVMSG the normal type of Irc chat msg
-func (c *Chat) WriteSayMsg(msg string) {
+func (c *Chat) WriteSayMsg(msg string) error {
+	if c.ReadOnly {
+		return ErrChatReadOnly
+	}
+
 	c.sayMsgPipe <- fmt.Sprintf("PRIVMSG #%s :%s", c.viewers.GetRoomName(), msg)
+	return nil
+}
+
+// TrySayMsg - Like WriteSayMsg but drops the message rather than wait when the send queue is full
+func (c *Chat) TrySayMsg(msg string) error {
+	if c.ReadOnly {
+		return ErrChatReadOnly
+	}
+
+	select {
+	case c.sayMsgPipe <- fmt.Sprintf("PRIVMSG #%s :%s", c.viewers.GetRoomName(), msg):
+		return nil
+	default:
+		return ErrChatBusy
+	}
 }
 
 func (c *Chat) respondToWelcome(m *irc.Message) {
-	c.WriteRawIrcMsg("CAP REQ :twitch.tv/membership")
-	c.WriteRawIrcMsg("CAP REQ :twitch.tv/tags")
-	c.WriteRawIrcMsg("CAP REQ :twitch.tv/commands")
-	c.WriteRawIrcMsg(fmt.Sprintf("JOIN #%s", c.viewers.GetRoomName()))
+	// Anonymous logins may still ask for capabilities and join to read the room
+	c.sayMsgPipe <- "CAP REQ :twitch.tv/membership"
+	c.sayMsgPipe <- "CAP REQ :twitch.tv/tags"
+	c.sayMsgPipe <- "CAP REQ :twitch.tv/commands"
+	c.sayMsgPipe <- fmt.Sprintf("JOIN #%s", c.viewers.GetRoomName())
 }
 
-func (c *Chat) forwardAlert(aType AlertType, src IrcNick, extraData interface{}) error {
+func (c *Chat) forwardAlert(aType AlertType, src IrcNick, eventID string, extraData interface{}) error {
 	if c.weakClientRef == nil {
 		return fmt.Errorf("Weak Client Ref Missing")
 	}
 
-	c.weakClientRef.Alerts.Post(src, aType, extraData)
+	c.weakClientRef.Alerts.PostEvent(src, aType, eventID, extraData)
 	return nil
 }
 
@@ -231,7 +335,7 @@ func (c *Chat) hostUpdate(src IrcNick, target IrcNick, numViewers int) error {
 	} else if target == roomNick {
 		// You are being hosted by Src
 		c.Logf(LogCatSystem, "%s is now hosting you with %d viewers", src, numViewers)
-		c.forwardAlert(AlertHost, src, numViewers)
+		c.forwardAlert(AlertHost, src, "", numViewers)
 
 	} else if target == "-" {
 		// Src is no longer hosting you
@@ -266,6 +370,27 @@ func (c *Chat) clearChat(m *irc.Message) {
 	} else {
 		c.Logf(LogCatSilent, "Cleared %s from chat", nickToClear)
 	}
+
+	// Whole chat cleared
+	if !IrcNick(nickToClear).IsValid() {
+		return
+	}
+
+	ma := ModAction{At: time.Now(), Action: ModActionBan, Reason: string(r)}
+	if d, ok := m.Tags[TwitchTagBanDuration]; ok {
+		secs, err := strconv.Atoi(string(d))
+		if err == nil {
+			ma.Action = ModActionTimeout
+			ma.Duration = time.Duration(secs) * time.Second
+		}
+	}
+
+	v, err := c.viewers.Find(IrcNick(nickToClear))
+	if err != nil {
+		c.weakClientRef.logf("CLEARCHAT [%s] not found\n%s", nickToClear, err)
+		return
+	}
+	v.recordModeration(ma)
 }
 
 func printDebugTag(m *irc.Message) {
@@ -325,14 +450,14 @@ func JoinNicks(nl []IrcNick, columns int, nickPadLength int) string {
 func (c *Chat) processNameList() {
 	vList := c.viewers.UpdateViewers(c.nameReplyList)
 
-	for _, v := range vList {
-		c.activeInRoom(v)
-	}
+	// A failed or partial lookup says nothing about who has left
+	complete := len(c.nameReplyList) < presenceNamesCap && len(vList) == len(c.nameReplyList)
+	c.reconcileRoom(vList, PresenceNames, complete)
 }
 
 // Handle - IRC Message
 func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
-	fmt.Fprintln(localIrcMsgStore(), m)
+	fmt.Fprintln(localIrcMsgStore(c.dataDir), m)
 
 	printOut, ok := ignoreMsgCmd[m.Command]
 	if ok {
@@ -379,7 +504,7 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 			return
 		}
 
-		log.Printf("IRC Unhandled CAP MSG [%s] %s",
+		c.weakClientRef.logf("IRC Unhandled CAP MSG [%s] %s",
 			strings.Join(m.Params, "]["),
 			m.Trailing())
 
@@ -389,17 +514,17 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 
 		v, err := c.viewers.Find(nick)
 		if err != nil {
-			log.Printf("JOIN ERROR [%s] not found\n%s", nick, err)
+			c.weakClientRef.logf("JOIN ERROR [%s] not found\n%s", nick, err)
 			return
 		}
 
-		c.activeInRoom(v)
+		c.activeInRoom(v, PresenceJoin)
 
 	case IrcCmdPart: // User Parted Channel
 		nick := IrcNick(m.Name)
-		v, ok := c.InRoom[nick]
+		v, ok := c.roomViewer(nick)
 		if ok {
-			c.partRoom(v)
+			c.partRoom(v, PresencePart)
 		}
 
 	case TwitchCmdClearChat:
@@ -408,7 +533,7 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 	case TwitchCmdGlobalUserState:
 		nick := IrcNick(m.Name)
 		if nick.IsValid() == false {
-			log.Printf("Global User State: Ignoring %s", nick)
+			c.weakClientRef.logf("Global User State: Ignoring %s", nick)
 			return
 		}
 
@@ -429,7 +554,7 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 				c.mode.followersOnly = false
 				intVal, err := strconv.Atoi(string(tagVal))
 				if err != nil {
-					log.Printf("%s:%s \n%s", tagName, tagVal, err)
+					c.weakClientRef.logf("%s:%s \n%s", tagName, tagVal, err)
 				} else if intVal > 0 {
 					c.mode.followersOnly = true
 				}
@@ -438,7 +563,7 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 				c.mode.r9k = false
 				intVal, err := strconv.Atoi(string(tagVal))
 				if err != nil {
-					log.Printf("%s:%s \n%s", tagName, tagVal, err)
+					c.weakClientRef.logf("%s:%s \n%s", tagName, tagVal, err)
 				} else if intVal > 0 {
 					c.mode.r9k = true
 				}
@@ -446,7 +571,7 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 				c.mode.slowMode = false
 				intVal, err := strconv.Atoi(string(tagVal))
 				if err != nil {
-					log.Printf("%s:%s \n%s", tagName, tagVal, err)
+					c.weakClientRef.logf("%s:%s \n%s", tagName, tagVal, err)
 				} else if intVal > 0 {
 					c.mode.slowMode = true
 				}
@@ -454,7 +579,7 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 				c.mode.subsOnly = false
 				intVal, err := strconv.Atoi(string(tagVal))
 				if err != nil {
-					log.Printf("%s:%s \n%s", tagName, tagVal, err)
+					c.weakClientRef.logf("%s:%s \n%s", tagName, tagVal, err)
 				} else if intVal > 0 {
 					c.mode.subsOnly = true
 				}
@@ -466,7 +591,7 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 				c.mode.emoteOnly = false
 				intVal, err := strconv.Atoi(string(tagVal))
 				if err != nil {
-					log.Printf("%s:%s \n%s", tagName, tagVal, err)
+					c.weakClientRef.logf("%s:%s \n%s", tagName, tagVal, err)
 				} else if intVal > 0 {
 					c.mode.emoteOnly = true
 				}
@@ -495,7 +620,7 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 		if ok {
 			emoList, err = emoteTagToList(emoteList)
 			if err != nil {
-				log.Printf("Unable to parse Emote Tag [%s]\n%s", emoteList, err)
+				c.weakClientRef.logf("Unable to parse Emote Tag [%s]\n%s", emoteList, err)
 				emoList = []EmoteReplace{}
 			}
 		}
@@ -508,10 +633,27 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 				Bits:    0,
 				Content: m.Trailing(),
 				Emotes:  emoList,
+				Badges:  chatter.Badges,
 			})
 
 		c.LogLine(llp)
-		c.forwardAlert(AlertSub, chatter.Nick, struct {
+
+		switch m.Tags[TwitchTagMsgID] {
+		case TwitchUserNoticeSub, TwitchUserNoticeReSub:
+			months, _ := strconv.Atoi(string(m.Tags[TwitchTagMsgParamMonths]))
+			v.recordSub(months, string(m.Tags[TwitchTagSubPlan]))
+		}
+
+		if pc, ok := c.pointsConfig(); ok {
+			switch m.Tags[TwitchTagMsgID] {
+			case TwitchUserNoticeSub, TwitchUserNoticeReSub, TwitchUserNoticeSubGift:
+				c.awardPoints(v, pc.SubBonus, PointsReasonSub)
+			case TwitchUserNoticeRaid:
+				c.awardPoints(v, pc.RaidBonus, PointsReasonRaid)
+			}
+		}
+
+		c.forwardAlert(AlertSub, chatter.Nick, string(m.Tags[TwitchTagUniqueID]), struct {
 			Msg         LogLineParsed `json:"msg"`
 			MsgID       string        `json:"msg-id"`
 			Months      string        `json:"months"`
@@ -528,7 +670,7 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 	case TwitchCmdUserState:
 		nick := IrcNick(m.Name)
 		if nick.IsValid() == false {
-			log.Printf("User State: Ignoring %s", nick)
+			c.weakClientRef.logf("User State: Ignoring %s", nick)
 			return
 		}
 
@@ -539,7 +681,6 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 
 		chatter := v.CreateChatter()
 		chatter.updateChatterFromTags(m)
-		chatter.updateTime()
 
 		v.SetChatter(chatter)
 		c.Logf(LogCatSystem, "User State updated from %s in %s", nick, m.Trailing())
@@ -548,7 +689,7 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 		channelDoingTheHost := m.Params[0]
 		subs := regexHostMatch.FindStringSubmatch(m.Trailing())
 		if len(subs) != 3 {
-			log.Printf("HOST ERROR 1 - Parsing [%s]\n%s", subs, m)
+			c.weakClientRef.logf("HOST ERROR 1 - Parsing [%s]\n%s", subs, m)
 			return
 		}
 
@@ -557,7 +698,7 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 			var err error
 			viewerNum, err = strconv.Atoi(subs[2])
 			if err != nil {
-				log.Printf("HOST ERROR 2 - Parsing [%s]\n%s", subs[2], m)
+				c.weakClientRef.logf("HOST ERROR 2 - Parsing [%s]\n%s", subs[2], m)
 			}
 		}
 		c.hostUpdate(IrcNick(channelDoingTheHost), IrcNick(subs[1]), viewerNum)
@@ -577,7 +718,7 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 
 		v, err := c.viewers.Find(nick)
 		if err != nil {
-			log.Printf("PRIV MSG ERROR [%s] not found\n%s", nick, err)
+			c.weakClientRef.logf("PRIV MSG ERROR [%s] not found\n%s", nick, err)
 			return
 		}
 
@@ -594,7 +735,7 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 		if ok {
 			emoList, err = emoteTagToList(emoteList)
 			if err != nil {
-				log.Printf("Unable to parse Emote Tag [%s]\n%s", emoteList, err)
+				c.weakClientRef.logf("Unable to parse Emote Tag [%s]\n%s", emoteList, err)
 				emoList = []EmoteReplace{}
 			}
 		}
@@ -607,10 +748,11 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 				Bits:    bVal,
 				Content: m.Trailing(),
 				Emotes:  emoList,
+				Badges:  chatter.Badges,
 			})
 
 		c.LogLine(llp)
-		c.forwardAlert(AlertWhisper, chatter.Nick, llp)
+		c.forwardAlert(AlertWhisper, chatter.Nick, "", llp)
 
 	case IrcCmdAction:
 		fallthrough
@@ -638,16 +780,15 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 
 		v, err := c.viewers.Find(nick)
 		if err != nil {
-			log.Printf("PRIV MSG ERROR [%s] not found\n%s", nick, err)
+			c.weakClientRef.logf("PRIV MSG ERROR [%s] not found\n%s", nick, err)
 			return
 		}
 
 		chatter := v.CreateChatter()
 		chatter.updateChatterFromTags(m)
-		chatter.updateTime()
 		v.SetChatter(chatter)
 
-		c.activeInRoom(v)
+		chatter = c.activeInRoom(v, PresenceMessage)
 
 		// Handle Bits
 		bVal := 0
@@ -655,10 +796,11 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 		if ok {
 			bVal, err = strconv.Atoi(string(bits))
 			if err != nil {
-				log.Print("Bits error -", m, err)
+				c.weakClientRef.logf("Bits error - %s %s", m, err)
 				bVal = 0
 			}
 		}
+		v.recordMessage(time.Now(), bVal)
 
 		// Handle Emotes
 		var emoList EmoteReplaceListFromBack
@@ -666,7 +808,7 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 		if ok {
 			emoList, err = emoteTagToList(emoteList)
 			if err != nil {
-				log.Printf("Unable to parse Emote Tag [%s]\n%s", emoteList, err)
+				c.weakClientRef.logf("Unable to parse Emote Tag [%s]\n%s", emoteList, err)
 				emoList = []EmoteReplace{}
 			}
 		}
@@ -681,6 +823,7 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 				Bits:    bVal,
 				Content: msgBody,
 				Emotes:  emoList,
+				Badges:  chatter.Badges,
 			})
 		if strings.HasPrefix(msgBody, "ACTION") {
 			llp.Msg.Content = strings.TrimLeft(msgBody, "ACTION")
@@ -688,9 +831,14 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 		c.LogLine(llp)
 
 		if bVal > 0 {
-			c.forwardAlert(AlertBits, chatter.Nick, llp)
+			c.forwardAlert(AlertBits, chatter.Nick, string(m.Tags[TwitchTagUniqueID]), llp)
+			if pc, ok := c.pointsConfig(); ok {
+				c.awardPoints(v, pc.PerHundredBits*Currency(bVal)/100, PointsReasonBits)
+			}
 		}
 
+		c.pointsCommand(v, msgBody)
+
 	case IrcCmdNotice:
 		msgID, ok := m.Tags[TwitchTagMsgID]
 		if !ok {
@@ -773,7 +921,7 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 
 		*/
 		default:
-			log.Printf("UNKNOWN MSG ID: [%s]", msgID)
+			c.weakClientRef.logf("UNKNOWN MSG ID: [%s]", msgID)
 			printDebugTag(m)
 		}
 
@@ -781,17 +929,32 @@ func (c *Chat) Handle(irc *irc.Client, m *irc.Message) {
 		c.tickRoomActive()
 
 	default:
-		log.Printf("IRC ???[%s] \t %+v", m.Command, m)
+		c.weakClientRef.logf("IRC ???[%s] \t %+v", m.Command, m)
 	}
 }
 
 // Sub - Create a Subsciption to Alerts
 func (c *Chat) Sub(subName string, topics []LogCat) chan LogLineParsed {
-	newSub := make(chan LogLineParsed, 10)
-	c.logger.newSubs <- subToChatPump{
-		Name:   subName,
-		Subbed: topics,
-		C:      newSub,
+	return c.SubWithOptions(subName, topics, DefaultSubOptions())
+}
+
+// SubWithOptions - Create a Subsciption to Chat with buffer size and overflow policy
+func (c *Chat) SubWithOptions(subName string, topics []LogCat, opts SubOptions) chan LogLineParsed {
+	return c.SubFiltered(subName, topics, ChatFilter{}, opts)
+}
+
+// SubFiltered - Create a Subsciption to Chat topics which only gets lines passing the filter
+func (c *Chat) SubFiltered(subName string, topics []LogCat, filter ChatFilter, opts SubOptions) chan LogLineParsed {
+	opts = opts.sanitise()
+	newSub := make(chan LogLineParsed, opts.BufferSize)
+	c.logger.newSubs <- &subToChatPump{
+		Name:    subName,
+		Subbed:  topics,
+		C:       newSub,
+		Options: opts,
+		Filter:  &filter,
+
+		counters: newSubCounters(),
 	}
 
 	return newSub
@@ -799,8 +962,15 @@ func (c *Chat) Sub(subName string, topics []LogCat) chan LogLineParsed {
 
 // Unsub - Kill a Subscription Channel
 func (c *Chat) Unsub(deadChannel chan LogLineParsed) {
-	c.logger.killSubs <- subToChatPump{
-		Name: "dead",
-		C:    deadChannel,
-	}
+	c.logger.killSubs <- deadChannel
+}
+
+// Subscribers - Diagnostic snapshot of every current chat subscriber
+func (c *Chat) Subscribers() []SubStats {
+	return c.logger.subscribers()
+}
+
+// DroppedLines - Lines the chat pump discarded because it fell behind the IRC handler
+func (c *Chat) DroppedLines() uint64 {
+	return atomic.LoadUint64(&c.logger.droppedLines)
 }
@@ -0,0 +1,38 @@
+package twitch
+
+import (
+	"errors"
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+const (
+	anonNickPrefix = "justinfan"
+	anonIrcPass    = "SCHMOOPIIE" // Twitch ignores the password for anonymous logins
+)
+
+var (
+	// ErrChatReadOnly - Returned by sending APIs when chat is anonymous
+	ErrChatReadOnly = errors.New("Chat is anonymous and read only")
+)
+
+// AnonymousIrcAuth - Read only justinfanNNNN login which needs no account
+type AnonymousIrcAuth struct {
+	Nick IrcNick
+}
+
+// CreateAnonymousIrcAuth - Random justinfan nick
+func CreateAnonymousIrcAuth() *AnonymousIrcAuth {
+	return &AnonymousIrcAuth{Nick: IrcNick(fmt.Sprintf("%s%d", anonNickPrefix, 1000+rand.Intn(89000)))}
+}
+
+// GetIrcAuth - returns the stuff needed for IRC
+func (aa *AnonymousIrcAuth) GetIrcAuth() (hasauth bool, name string, pass string) {
+	return true, string(aa.Nick), anonIrcPass
+}
+
+// isAnonymousNick - justinfan logins cannot send
+func isAnonymousNick(nick string) bool {
+	return strings.HasPrefix(strings.ToLower(nick), anonNickPrefix)
+}
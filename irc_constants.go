@@ -341,6 +341,7 @@ const (
 // Twitch Tags
 const (
 	TwitchTagBanReason         = "ban-reason"
+	TwitchTagBanDuration       = "ban-duration"
 	TwitchTagBits              = "bits"
 	TwitchTagLogin             = "login"
 	TwitchTagMsgID             = "msg-id"
@@ -377,6 +378,8 @@ const (
 const (
 	TwitchUserNoticeSub     = "sub"
 	TwitchUserNoticeReSub   = "resub"
+	TwitchUserNoticeSubGift = "subgift"
+	TwitchUserNoticeRaid    = "raid"
 	TwitchUserNoticeCharity = "charity"
 )
 
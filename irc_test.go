@@ -2,6 +2,7 @@ package twitch
 
 import (
 	"fmt"
+	"strings"
 	"testing"
 
 	"os"
@@ -115,7 +116,7 @@ func (dh *DummyHandler) Handle(c *irc.Client, m *irc.Message) {
 
 func TestIrcMessage(t *testing.T) {
 
-	chat, err := createIrcClient(&DummyAuth{}, &DummyViewProvider{}, "")
+	chat, err := createIrcClient(&DummyAuth{}, &DummyViewProvider{}, "", DefaultDataDir)
 	chat.config.Handler = chat
 
 	if err != nil {
@@ -186,6 +187,48 @@ func TestIrcMessage(t *testing.T) {
 	}
 }
 
+func TestAnonymousChat(t *testing.T) {
+	auth := CreateAnonymousIrcAuth()
+	_, nick, _ := auth.GetIrcAuth()
+	if !strings.HasPrefix(nick, "justinfan") {
+		t.Errorf("Anonymous nick %s", nick)
+	}
+
+	chat, err := createIrcClient(auth, &DummyViewProvider{}, "", DefaultDataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !chat.ReadOnly {
+		t.Errorf("Anonymous chat should be read only")
+	}
+
+	if err = chat.WriteSayMsg("hello"); err != ErrChatReadOnly {
+		t.Errorf("Say should fail read only: %v", err)
+	}
+	if err = chat.Ban("ronni", "spam"); err != ErrChatReadOnly {
+		t.Errorf("Ban should fail read only: %v", err)
+	}
+	if err = chat.SetMode(ChatModeSlow, true, 10); err != ErrChatReadOnly {
+		t.Errorf("SetMode should fail read only: %v", err)
+	}
+	if err = chat.WriteRawIrcMsg("PRIVMSG #kimau :hello"); err != ErrChatReadOnly {
+		t.Errorf("Raw write should fail read only: %v", err)
+	}
+
+	// Joining the room to read it still works
+	chat.sayMsgPipe = make(chan string, 4)
+	chat.respondToWelcome(nil)
+	if len(chat.sayMsgPipe) != 4 {
+		t.Errorf("Anonymous welcome sent %d of 4 messages", len(chat.sayMsgPipe))
+	}
+
+	authed, _ := createIrcClient(&DummyAuth{}, &DummyViewProvider{}, "", DefaultDataDir)
+	if authed.ReadOnly {
+		t.Errorf("Authed chat should not be read only")
+	}
+}
+
 func TestEmoteTagProcessor(t *testing.T) {
 
 	emoteTagTest := []struct {
@@ -0,0 +1,112 @@
+package twitch
+
+import (
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"github.com/gorilla/websocket"
+)
+
+const (
+	ircServerTLS       = "irc.chat.twitch.tv:6697"
+	ircServerWebSocket = "wss://irc-ws.chat.twitch.tv:443"
+)
+
+// defaultIrcServerFor - Twitch endpoint for the transport
+func defaultIrcServerFor(transport string) string {
+	switch transport {
+	case IrcTransportTLS:
+		return ircServerTLS
+	case IrcTransportWebSocket:
+		return ircServerWebSocket
+	}
+	return defaultIrcServer
+}
+
+// parseBindAddr - Local address to dial from, a bare IP gets any port
+func parseBindAddr(bind string) (*net.TCPAddr, error) {
+	if bind == "" {
+		return nil, nil
+	}
+
+	if ip := net.ParseIP(strings.Trim(bind, "[]")); ip != nil {
+		bind = net.JoinHostPort(ip.String(), "0")
+	}
+
+	return net.ResolveTCPAddr("tcp", bind)
+}
+
+// dialIrc - Connect to chat with the configured transport and bind address
+func dialIrc(cfg *Config, server string) (io.ReadWriteCloser, error) {
+	dialer := &net.Dialer{}
+
+	bind, err := parseBindAddr(cfg.IrcBindAddr)
+	if err != nil {
+		return nil, fmt.Errorf("Invalid IRC bind address %s: %s", cfg.IrcBindAddr, err)
+	}
+	if bind != nil {
+		dialer.LocalAddr = bind
+	}
+
+	switch cfg.IrcTransport {
+	case IrcTransportTLS:
+		return tls.DialWithDialer(dialer, "tcp", server, cfg.TLSConfig)
+
+	case IrcTransportWebSocket:
+		wsDialer := websocket.Dialer{
+			NetDial:         dialer.Dial,
+			TLSClientConfig: cfg.TLSConfig,
+		}
+
+		ws, _, err := wsDialer.Dial(server, nil)
+		if err != nil {
+			return nil, err
+		}
+		return &wsIrcConn{ws: ws}, nil
+	}
+
+	return dialer.Dial("tcp", server)
+}
+
+// wsIrcConn - IRC lines over websocket text frames as a stream
+// Twitch may pack several lines into one frame, each still ends in \r\n
+type wsIrcConn struct {
+	ws      *websocket.Conn
+	pending []byte
+	wLock   sync.Mutex
+}
+
+func (wic *wsIrcConn) Read(p []byte) (int, error) {
+	for len(wic.pending) == 0 {
+		mType, msg, err := wic.ws.ReadMessage()
+		if err != nil {
+			return 0, err
+		}
+		if mType == websocket.TextMessage {
+			wic.pending = msg
+		}
+	}
+
+	n := copy(p, wic.pending)
+	wic.pending = wic.pending[n:]
+	return n, nil
+}
+
+func (wic *wsIrcConn) Write(p []byte) (int, error) {
+	wic.wLock.Lock()
+	defer wic.wLock.Unlock()
+
+	err := wic.ws.WriteMessage(websocket.TextMessage, p)
+	if err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+func (wic *wsIrcConn) Close() error {
+	return wic.ws.Close()
+}
@@ -0,0 +1,61 @@
+package twitch
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestIrcWebSocketTransport(t *testing.T) {
+	upgrader := websocket.Upgrader{}
+	got := make(chan string, 1)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		ws, err := upgrader.Upgrade(w, req, nil)
+		if err != nil {
+			return
+		}
+		defer ws.Close()
+
+		// Two lines packed in one frame like twitch does
+		ws.WriteMessage(websocket.TextMessage, []byte(":tmi.twitch.tv 001 kimau :Welcome\r\n:tmi.twitch.tv 002 kimau :Host\r\n"))
+		_, msg, _ := ws.ReadMessage()
+		got <- string(msg)
+	}))
+	defer server.Close()
+
+	cfg := DefaultConfig()
+	cfg.IrcTransport = IrcTransportWebSocket
+	cfg.IrcBindAddr = "127.0.0.1"
+
+	conn, err := dialIrc(&cfg, "ws"+strings.TrimPrefix(server.URL, "http"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	r := bufio.NewReader(conn)
+	for _, want := range []string{"001", "002"} {
+		line, err := r.ReadString('\n')
+		if err != nil || !strings.Contains(line, want) || !strings.HasSuffix(line, "\r\n") {
+			t.Errorf("Bad line %q want %s: %v", line, want, err)
+		}
+	}
+
+	conn.Write([]byte("PING :tmi.twitch.tv\r\n"))
+	if msg := <-got; msg != "PING :tmi.twitch.tv\r\n" {
+		t.Errorf("Server got %q", msg)
+	}
+
+	for _, bind := range []string{"", "127.0.0.1", "127.0.0.1:0", "[::1]", "::1"} {
+		if _, err := parseBindAddr(bind); err != nil {
+			t.Errorf("Bind %s: %s", bind, err)
+		}
+	}
+	if _, err := parseBindAddr("not an ip:port:x"); err == nil {
+		t.Errorf("Bad bind address accepted")
+	}
+}
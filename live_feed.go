@@ -0,0 +1,273 @@
+package twitch
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const (
+	liveFeedBuffer      = 200
+	liveFeedMaxBackfill = numInteralLogLines
+)
+
+// Live Feed Event Types
+const (
+	LiveFeedChat       = "chat"
+	LiveFeedAlert      = "alert"
+	LiveFeedSubscribed = "subscribed"
+	LiveFeedError      = "error"
+)
+
+var (
+	allLogCats = []LogCat{LogCatSystem, LogCatSilent, LogCatFiltered, LogCatMsg, LogCatAction, LogCatWhisper, LogCatUnknown}
+
+	// DefaultLiveFeedSub - What a new connection gets before it sends a subscribe
+	DefaultLiveFeedSub = LiveFeedSub{
+		Chat:     string([]rune{rune(LogCatMsg), rune(LogCatAction)}),
+		Alerts:   []AlertType{AlertHost, AlertSub, AlertFollow, AlertBits},
+		HTML:     true,
+		Backfill: 50,
+	}
+)
+
+// LiveFeedCmd - Message sent by a websocket client
+// {"cmd":"subscribe","chat":"#!","alerts":[300],"html":true,"backfill":20}
+type LiveFeedCmd struct {
+	Cmd      string      `json:"cmd"`  // subscribe or unsubscribe
+	Chat     string      `json:"chat"` // LogCat characters
+	Alerts   []AlertType `json:"alerts"`
+	HTML     *bool       `json:"html"`
+	Backfill int         `json:"backfill"` // Chat lines to resend after subscribing
+}
+
+// LiveFeedSub - Current subscription of a websocket client
+type LiveFeedSub struct {
+	Chat     string      `json:"chat"`
+	Alerts   []AlertType `json:"alerts"`
+	HTML     bool        `json:"html"`
+	Backfill int         `json:"backfill,omitempty"`
+}
+
+func (lfs *LiveFeedSub) wantsChat(cat LogCat) bool {
+	return strings.ContainsRune(lfs.Chat, rune(cat))
+}
+
+func (lfs *LiveFeedSub) wantsAlert(at AlertType) bool {
+	for _, t := range lfs.Alerts {
+		if t == at {
+			return true
+		}
+	}
+	return false
+}
+
+func (lfs *LiveFeedSub) apply(cmd LiveFeedCmd) error {
+	switch cmd.Cmd {
+	case "subscribe":
+		for _, c := range cmd.Chat {
+			if !lfs.wantsChat(LogCat(c)) {
+				lfs.Chat += string(c)
+			}
+		}
+		for _, t := range cmd.Alerts {
+			if !lfs.wantsAlert(t) {
+				lfs.Alerts = append(lfs.Alerts, t)
+			}
+		}
+
+	case "unsubscribe":
+		lfs.Chat = strings.Map(func(c rune) rune {
+			if strings.ContainsRune(cmd.Chat, c) {
+				return -1
+			}
+			return c
+		}, lfs.Chat)
+
+		alerts := []AlertType{}
+		for _, t := range lfs.Alerts {
+			keep := true
+			for _, dt := range cmd.Alerts {
+				keep = keep && dt != t
+			}
+			if keep {
+				alerts = append(alerts, t)
+			}
+		}
+		lfs.Alerts = alerts
+
+	default:
+		return fmt.Errorf("Unknown feed command: %s", cmd.Cmd)
+	}
+
+	if cmd.HTML != nil {
+		lfs.HTML = *cmd.HTML
+	}
+
+	return nil
+}
+
+// LiveFeedEvent - Message sent to a websocket client
+type LiveFeedEvent struct {
+	Type  string         `json:"type"`
+	Chat  *LogLineParsed `json:"chat,omitempty"`
+	HTML  string         `json:"html,omitempty"`
+	Alert *Alert         `json:"alert,omitempty"`
+	Sub   *LiveFeedSub   `json:"sub,omitempty"`
+	Error string         `json:"error,omitempty"`
+}
+
+// LiveFeed - Websocket endpoint streaming chat and alerts to overlays
+type LiveFeed struct {
+	*WebsocketHelper
+	client *Client
+}
+
+// CreateLiveFeed - Feed for the client, serve it with ServeHTTP
+func CreateLiveFeed(ah *Client) *LiveFeed {
+	lf := LiveFeed{client: ah}
+	lf.WebsocketHelper = CreateWebsocketHelper(lf.handleConn)
+	return &lf
+}
+
+// ParseLiveFeedSub - Initial subscription from query values, anything missing uses DefaultLiveFeedSub
+// ?chat=#!&alerts=100,300&html=0&backfill=20
+func ParseLiveFeedSub(q url.Values) LiveFeedSub {
+	lfs := DefaultLiveFeedSub
+	lfs.Alerts = append([]AlertType{}, DefaultLiveFeedSub.Alerts...)
+
+	if _, ok := q["chat"]; ok {
+		lfs.Chat = q.Get("chat")
+	}
+
+	if _, ok := q["alerts"]; ok {
+		lfs.Alerts = []AlertType{}
+		for _, s := range strings.Split(q.Get("alerts"), ",") {
+			if n, err := strconv.Atoi(strings.TrimSpace(s)); err == nil {
+				lfs.Alerts = append(lfs.Alerts, AlertType(n))
+			}
+		}
+	}
+
+	if h := q.Get("html"); h != "" {
+		lfs.HTML = h != "0" && h != "false"
+	}
+
+	if b, err := strconv.Atoi(q.Get("backfill")); err == nil {
+		lfs.Backfill = b
+	}
+
+	return lfs
+}
+
+func (lf *LiveFeed) handleConn(wc *WebsocketConn) {
+	lfs := ParseLiveFeedSub(wc.Query)
+	name := fmt.Sprintf("feed:%s", wc.ws.RemoteAddr())
+	feedOpts := SubOptions{BufferSize: liveFeedBuffer, Overflow: OverflowDropOldest}
+
+	alerts := lf.client.Alerts.SubFiltered(name,
+		AlertFilter{Match: func(a Alert) bool { return a.Type != AlertNone }}, feedOpts)
+	defer lf.client.Alerts.Unsub(alerts)
+
+	// Chat may not have started yet, nil channel never fires
+	var chat chan LogLineParsed
+	if lf.client.Chat != nil {
+		chat = lf.client.Chat.SubWithOptions(name, allLogCats, feedOpts)
+		defer lf.client.Chat.Unsub(chat)
+	}
+
+	if err := lf.sendSubscribed(wc, &lfs); err != nil {
+		log.Printf("Live Feed %s: %s", name, err)
+		return
+	}
+
+	for {
+		var err error
+
+		select {
+		case cmdStr, ok := <-wc.CmdChan:
+			if !ok {
+				return
+			}
+
+			cmd := LiveFeedCmd{}
+			err = json.Unmarshal([]byte(cmdStr), &cmd)
+			if err == nil {
+				err = lfs.apply(cmd)
+			}
+			if err != nil {
+				err = wc.WriteJSON(LiveFeedEvent{Type: LiveFeedError, Error: err.Error()})
+			} else {
+				lfs.Backfill = cmd.Backfill
+				err = lf.sendSubscribed(wc, &lfs)
+			}
+
+		case a, ok := <-alerts:
+			if !ok {
+				return
+			}
+			if lfs.wantsAlert(a.Type) {
+				err = wc.WriteJSON(LiveFeedEvent{Type: LiveFeedAlert, Alert: &a})
+			}
+
+		case llp, ok := <-chat:
+			if !ok {
+				return
+			}
+			if lfs.wantsChat(llp.Cat) {
+				err = lf.writeChat(wc, &lfs, llp)
+			}
+		}
+
+		if err != nil {
+			log.Printf("Live Feed %s: %s", name, err)
+			return
+		}
+	}
+}
+
+// sendSubscribed - Confirm subscription then backfill from the chat ring buffer
+func (lf *LiveFeed) sendSubscribed(wc *WebsocketConn, lfs *LiveFeedSub) error {
+	sub := *lfs
+	err := wc.WriteJSON(LiveFeedEvent{Type: LiveFeedSubscribed, Sub: &sub})
+	if err != nil || lfs.Backfill <= 0 || lf.client.Chat == nil {
+		return err
+	}
+
+	num := lfs.Backfill
+	if num > liveFeedMaxBackfill {
+		num = liveFeedMaxBackfill
+	}
+
+	lines := lf.client.Chat.ReadChatFull()
+	start := len(lines)
+	for start > 0 && num > 0 {
+		start--
+		if lfs.wantsChat(lines[start].Cat) {
+			num--
+		}
+	}
+
+	for _, llp := range lines[start:] {
+		if !lfs.wantsChat(llp.Cat) {
+			continue
+		}
+		if err = lf.writeChat(wc, lfs, llp); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (lf *LiveFeed) writeChat(wc *WebsocketConn, lfs *LiveFeedSub, llp LogLineParsed) error {
+	ev := LiveFeedEvent{Type: LiveFeedChat, Chat: &llp}
+	if lfs.HTML && lf.client.Viewers != nil {
+		ev.HTML = llp.HTML(lf.client.Viewers)
+	}
+
+	return wc.WriteJSON(ev)
+}
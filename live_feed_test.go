@@ -0,0 +1,52 @@
+package twitch
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+func TestLiveFeed(t *testing.T) {
+	ah := &Client{Alerts: StartAlertPump(nil), Chat: &Chat{logger: startChatLogPump("feedtest", DefaultDataDir)}}
+	ah.Chat.Log(LogCatSystem, "Join ronni")
+	ah.Chat.LogLine(MakeLogLineMsg(LogCatMsg, LogLineParsedMsg{Nick: "ronni", Content: "hello"}))
+
+	srv := httptest.NewServer(CreateLiveFeed(ah))
+	defer srv.Close()
+
+	ws, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"?html=0&backfill=5", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ws.Close()
+	ws.SetReadDeadline(time.Now().Add(time.Second * 5))
+
+	ev := LiveFeedEvent{}
+	if err = ws.ReadJSON(&ev); err != nil || ev.Type != LiveFeedSubscribed {
+		t.Fatalf("Expected subscribed got %v %s", ev, err)
+	}
+
+	// Backfill skips the system line
+	ev = LiveFeedEvent{}
+	if err = ws.ReadJSON(&ev); err != nil || ev.Type != LiveFeedChat || ev.Chat.Msg.Content != "hello" {
+		t.Fatalf("Expected backfill chat got %v %s", ev, err)
+	}
+
+	ws.WriteJSON(LiveFeedCmd{Cmd: "unsubscribe", Alerts: []AlertType{AlertFollow}})
+	ev = LiveFeedEvent{}
+	if err = ws.ReadJSON(&ev); err != nil || ev.Type != LiveFeedSubscribed || ev.Sub.wantsAlert(AlertFollow) {
+		t.Fatalf("Expected follows unsubscribed got %v %s", ev.Sub, err)
+	}
+
+	waitForSubs(ah.Alerts, 1)
+	ah.Alerts.postInternal(Alert{Type: AlertFollow, Source: "fred"})
+	ah.Alerts.postInternal(Alert{Type: AlertHost, Source: "obezianka", Data: 3})
+
+	ev = LiveFeedEvent{}
+	if err = ws.ReadJSON(&ev); err != nil || ev.Type != LiveFeedAlert || ev.Alert.Type != AlertHost {
+		t.Fatalf("Expected host alert got %v %s", ev, err)
+	}
+}
@@ -0,0 +1,429 @@
+package twitch
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	pointsLedgerSize    = 50
+	pointsMaxGap        = time.Minute * 10 // Longer than two PING ticks means they left without a PART
+	pointsCommandPrefix = "!"
+	pointsTopSize       = 5
+	pointsTopCooldown   = time.Second * 30 // !top visits every viewer so chat can only ask this often
+	pointsUserCooldown  = time.Second * 10 // Between commands from one viewer
+	pointsUserPrune     = 1000             // Cooldowns held before expired ones are dropped
+
+	// DefaultPointsPerMinute - Points for every minute in the room
+	DefaultPointsPerMinute = 1
+)
+
+// Ledger reasons
+const (
+	PointsReasonWatch   = "watch"
+	PointsReasonBits    = "bits"
+	PointsReasonSub     = "sub"
+	PointsReasonRaid    = "raid"
+	PointsReasonGive    = "give"
+	PointsReasonReceive = "receive"
+	PointsReasonRefund  = "refund" // Give returned after the receiver could not be paid
+	PointsReasonAdjust  = "adjust"
+)
+
+// Leaderboard orders
+const (
+	PointsByBalance = "balance"
+	PointsByEarned  = "earned"
+	PointsByTime    = "time"
+)
+
+var (
+	// ErrPointsInsufficient - Spending more than the balance
+	ErrPointsInsufficient = errors.New("Not enough points")
+	// ErrPointsInvalid - Zero, negative or self transfers
+	ErrPointsInvalid = errors.New("Invalid points amount")
+)
+
+// pointsCooldown - Last time a chat command ran
+type pointsCooldown struct {
+	lock sync.Mutex
+	last time.Time
+}
+
+// ready - True and restarts the cooldown if it has passed
+func (pc *pointsCooldown) ready(now time.Time, every time.Duration) bool {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	if !pc.last.IsZero() && now.Sub(pc.last) < every {
+		return false
+	}
+	pc.last = now
+	return true
+}
+
+// pointsCooldowns - Last time each viewer ran a chat command
+type pointsCooldowns struct {
+	lock sync.Mutex
+	last map[ID]time.Time
+}
+
+// ready - True and restarts the viewer's cooldown if it has passed
+func (pc *pointsCooldowns) ready(tid ID, now time.Time, every time.Duration) bool {
+	pc.lock.Lock()
+	defer pc.lock.Unlock()
+
+	if last, ok := pc.last[tid]; ok && now.Sub(last) < every {
+		return false
+	}
+
+	if pc.last == nil {
+		pc.last = make(map[ID]time.Time)
+	} else if len(pc.last) >= pointsUserPrune {
+		for k, last := range pc.last {
+			if now.Sub(last) >= every {
+				delete(pc.last, k)
+			}
+		}
+	}
+	pc.last[tid] = now
+	return true
+}
+
+// PointsTx - One change to a balance
+type PointsTx struct {
+	At      time.Time `json:"at"`
+	Amount  Currency  `json:"amount"`
+	Balance Currency  `json:"balance"` // After this change
+	Reason  string    `json:"reason"`
+	Other   ID        `json:"other,omitempty"` // Other side of a transfer
+}
+
+// ViewerPoints - Loyalty balance, saved with the rest of the viewer
+type ViewerPoints struct {
+	Balance   Currency   `json:"balance"`
+	Earned    Currency   `json:"earned"` // Everything awarded, transfers and adjustments excluded
+	LastAward time.Time  `json:"last_award"`
+	Ledger    []PointsTx `json:"ledger,omitempty"` // Oldest first, consecutive watch awards are merged
+}
+
+// PointsRank - Leaderboard entry
+type PointsRank struct {
+	Rank          int           `json:"rank"`
+	ID            ID            `json:"id"`
+	Nick          IrcNick       `json:"nick"`
+	Balance       Currency      `json:"balance"`
+	Earned        Currency      `json:"earned"`
+	TimeInChannel time.Duration `json:"time_in_channel"`
+}
+
+// clone - Points are swapped whole so copies handed out by GetData never change
+func (vp *ViewerPoints) clone() *ViewerPoints {
+	if vp == nil {
+		return &ViewerPoints{}
+	}
+	np := *vp
+	np.Ledger = append(make([]PointsTx, 0, len(vp.Ledger)+1), vp.Ledger...)
+	return &np
+}
+
+func (vp *ViewerPoints) record(at time.Time, amount Currency, reason string, other ID) {
+	vp.Balance += amount
+	switch reason {
+	case PointsReasonGive, PointsReasonReceive, PointsReasonRefund, PointsReasonAdjust:
+	default:
+		vp.Earned += amount
+	}
+
+	last := len(vp.Ledger) - 1
+	if reason == PointsReasonWatch && last >= 0 && vp.Ledger[last].Reason == PointsReasonWatch {
+		vp.Ledger[last].At = at
+		vp.Ledger[last].Amount += amount
+		vp.Ledger[last].Balance = vp.Balance
+		return
+	}
+
+	vp.Ledger = append(vp.Ledger, PointsTx{At: at, Amount: amount, Balance: vp.Balance, Reason: reason, Other: other})
+	if len(vp.Ledger) > pointsLedgerSize {
+		vp.Ledger = vp.Ledger[len(vp.Ledger)-pointsLedgerSize:]
+	}
+}
+
+// Balance - Current points, zero if never awarded
+func (vd ViewerData) Balance() Currency {
+	if vd.Points == nil {
+		return 0
+	}
+	return vd.Points.Balance
+}
+
+// pointsMultiplier - Subs and followers earn faster, both apply when both are true
+func (vd ViewerData) pointsMultiplier(cfg ConfigPoints) float64 {
+	m := 1.0
+	if vd.Chatter != nil && vd.Chatter.Sub > 0 && cfg.SubMultiplier > 0 {
+		m *= cfg.SubMultiplier
+	}
+	if vd.Follower != nil && cfg.FollowerMultiplier > 0 {
+		m *= cfg.FollowerMultiplier
+	}
+	return m
+}
+
+// AddPoints - Change the balance, refuses to go below zero, returns the new balance
+func (vw *Viewer) AddPoints(amount Currency, reason string, other ID) (Currency, error) {
+	vw.Lockme()
+	defer vw.Unlockme()
+
+	if amount == 0 {
+		return vw.data.Balance(), nil
+	}
+	if vw.data.Balance()+amount < 0 {
+		return vw.data.Balance(), ErrPointsInsufficient
+	}
+
+	vp := vw.data.Points.clone()
+	vp.record(time.Now(), amount, reason, other)
+	vw.data.Points = vp
+	return vp.Balance, nil
+}
+
+// startWatch - Arrived in the room, time before now earns nothing
+func (vw *Viewer) startWatch(now time.Time) {
+	vw.Lockme()
+	defer vw.Unlockme()
+
+	vp := vw.data.Points.clone()
+	vp.LastAward = now
+	vw.data.Points = vp
+}
+
+// awardWatchTime - Points for whole minutes since the last award, part minutes carry over
+func (vw *Viewer) awardWatchTime(now time.Time, cfg ConfigPoints) Currency {
+	vw.Lockme()
+	defer vw.Unlockme()
+
+	vp := vw.data.Points.clone()
+	elapsed := now.Sub(vp.LastAward)
+	if vp.LastAward.IsZero() || elapsed > pointsMaxGap || elapsed < 0 {
+		vp.LastAward = now
+		vw.data.Points = vp
+		return 0
+	}
+
+	minutes := elapsed / time.Minute
+	if minutes == 0 {
+		return 0
+	}
+	vp.LastAward = vp.LastAward.Add(minutes * time.Minute)
+
+	amount := Currency(float64(cfg.PerMinute) * float64(minutes) * vw.data.pointsMultiplier(cfg))
+	if amount > 0 {
+		vp.record(now, amount, PointsReasonWatch, "")
+	}
+	vw.data.Points = vp
+	return amount
+}
+
+// transferPoints - Move points between viewers, taken from the sender first and returned if the receiver fails
+func transferPoints(from *Viewer, to *Viewer, amount Currency) error {
+	fromID := from.GetData().TwitchID
+	toID := to.GetData().TwitchID
+	if amount <= 0 || fromID == toID {
+		return ErrPointsInvalid
+	}
+
+	_, err := from.AddPoints(-amount, PointsReasonGive, toID)
+	if err != nil {
+		return err
+	}
+
+	_, err = to.AddPoints(amount, PointsReasonReceive, fromID)
+	if err != nil {
+		from.AddPoints(amount, PointsReasonRefund, toID)
+		return err
+	}
+	return nil
+}
+
+// PointsLeaderboard - Top viewers ordered by PointsByBalance, PointsByEarned or PointsByTime
+func (vm *ViewerMethod) PointsLeaderboard(by string, limit int) ([]PointsRank, error) {
+	var key func(r PointsRank) int64
+	switch by {
+	case PointsByBalance, "":
+		key = func(r PointsRank) int64 { return int64(r.Balance) }
+	case PointsByEarned:
+		key = func(r PointsRank) int64 { return int64(r.Earned) }
+	case PointsByTime:
+		key = func(r PointsRank) int64 { return int64(r.TimeInChannel) }
+	default:
+		return nil, fmt.Errorf("Unknown leaderboard order: %s", by)
+	}
+
+	rList := []PointsRank{}
+	err := vm.forEachData(func(vd ViewerData) bool {
+		r := PointsRank{ID: vd.TwitchID, Nick: vd.GetNick()}
+		if vd.Points != nil {
+			r.Balance = vd.Points.Balance
+			r.Earned = vd.Points.Earned
+		}
+		if vd.Chatter != nil {
+			r.TimeInChannel = vd.Chatter.TimeInChannel
+		}
+		if key(r) > 0 {
+			rList = append(rList, r)
+		}
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(rList, func(i, j int) bool {
+		if key(rList[i]) == key(rList[j]) {
+			return rList[i].Nick < rList[j].Nick
+		}
+		return key(rList[i]) > key(rList[j])
+	})
+	if limit > 0 && len(rList) > limit {
+		rList = rList[:limit]
+	}
+	for i := range rList {
+		rList[i].Rank = i + 1
+	}
+	return rList, nil
+}
+
+// pointsConfig - Rates from the client, false when points are off or there is no client
+func (c *Chat) pointsConfig() (ConfigPoints, bool) {
+	if c.weakClientRef == nil || !c.weakClientRef.Config.Enable.Points {
+		return ConfigPoints{}, false
+	}
+	return c.weakClientRef.Config.Points, true
+}
+
+// awardPoints - Bonus from chat events, logged and otherwise ignored on failure
+func (c *Chat) awardPoints(v *Viewer, amount Currency, reason string) {
+	if amount <= 0 {
+		return
+	}
+	if _, err := v.AddPoints(amount, reason, ""); err != nil {
+		c.weakClientRef.logf("Points: %s bonus for %s failed: %s", reason, v.GetData().TwitchID, err)
+	}
+}
+
+// pointsCommand - !points [nick], !give nick amount and !top from chat, true if msg was a command
+func (c *Chat) pointsCommand(v *Viewer, msg string) bool {
+	if _, ok := c.pointsConfig(); !ok || !strings.HasPrefix(msg, pointsCommandPrefix) {
+		return false
+	}
+
+	args := strings.Fields(strings.TrimPrefix(msg, pointsCommandPrefix))
+	if len(args) == 0 {
+		return false
+	}
+	cmd := strings.ToLower(args[0])
+	switch cmd {
+	case "points", "give", "top":
+	default:
+		return false
+	}
+
+	vd := v.GetData()
+	if !c.pointsUsers.ready(vd.TwitchID, time.Now(), pointsUserCooldown) {
+		return true
+	}
+	nick := vd.GetNick()
+
+	reply := ""
+	switch cmd {
+	case "points":
+		target := vd
+		if len(args) > 1 {
+			other := c.knownViewer(args[1])
+			if other == nil {
+				reply = fmt.Sprintf("@%s I don't know %s", nick, args[1])
+				break
+			}
+			target = other.GetData()
+		}
+
+		watched := time.Duration(0)
+		if target.Chatter != nil {
+			watched = target.Chatter.TimeInChannel
+		}
+		reply = fmt.Sprintf("@%s %s has %d points and has watched for %s",
+			nick, target.GetNick(), target.Balance(), watched.Truncate(time.Minute))
+
+	case "give":
+		if len(args) != 3 {
+			reply = fmt.Sprintf("@%s Usage: %sgive nick amount", nick, pointsCommandPrefix)
+			break
+		}
+		amount, err := strconv.Atoi(args[2])
+		if err != nil || amount <= 0 {
+			reply = fmt.Sprintf("@%s %s is not a number of points", nick, args[2])
+			break
+		}
+		to := c.knownViewer(args[1])
+		if to == nil {
+			reply = fmt.Sprintf("@%s I don't know %s", nick, args[1])
+			break
+		}
+		toData := to.GetData()
+		toNick := toData.GetNick()
+
+		err = transferPoints(v, to, Currency(amount))
+		if err != nil {
+			reply = fmt.Sprintf("@%s %s", nick, err)
+			break
+		}
+		reply = fmt.Sprintf("@%s gave %d points to %s", nick, amount, toNick)
+		c.Logf(LogCatSystem, "Points: %s gave %d to %s", nick, amount, toNick)
+
+	case "top":
+		if c.weakClientRef == nil || c.weakClientRef.Viewers == nil || !c.pointsTop.ready(time.Now(), pointsTopCooldown) {
+			return true
+		}
+		// Ranking visits every viewer so keep it off the chat goroutine
+		go c.sayPointsTop(c.weakClientRef.Viewers)
+		return true
+	}
+
+	c.pointsReply(reply)
+	return true
+}
+
+// knownViewer - Viewer named in a command from the nick index only, chat never reaches the API
+func (c *Chat) knownViewer(arg string) *Viewer {
+	if c.weakClientRef == nil || c.weakClientRef.Viewers == nil {
+		return nil
+	}
+	return c.weakClientRef.Viewers.findViewerByName(IrcNick(strings.TrimPrefix(arg, "@")))
+}
+
+// sayPointsTop - Leaderboard by balance to chat
+func (c *Chat) sayPointsTop(vm *ViewerMethod) {
+	rList, err := vm.PointsLeaderboard(PointsByBalance, pointsTopSize)
+	if err != nil {
+		c.weakClientRef.logf("Points leaderboard failed: %s", err)
+		return
+	}
+
+	top := make([]string, 0, len(rList))
+	for _, r := range rList {
+		top = append(top, fmt.Sprintf("%d. %s (%d)", r.Rank, r.Nick, r.Balance))
+	}
+	c.pointsReply("Top points: " + strings.Join(top, ", "))
+}
+
+// pointsReply - Replies are dropped rather than stall the chat goroutine behind a full send queue
+func (c *Chat) pointsReply(reply string) {
+	if err := c.TrySayMsg(reply); err != nil && err != ErrChatReadOnly {
+		c.weakClientRef.logf("Points reply dropped: %s", err)
+	}
+}
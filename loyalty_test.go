@@ -0,0 +1,151 @@
+package twitch
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoyaltyPoints(t *testing.T) {
+	ah := &Client{Config: DefaultConfig(), RoomName: "kimau"}
+	ah.Viewers = CreateViewerMethod(ah)
+	pc := ah.Config.Points
+
+	plain := ah.Viewers.GetFromUser(User{ID: "1", Name: "plain"})
+	fan := ah.Viewers.GetFromUser(User{ID: "2", Name: "fan"})
+	fan.SetChatter(Chatter{Nick: "fan", Sub: 1})
+	ah.Viewers.UpdateFollowers([]ChannelFollow{{User: &User{ID: "2", Name: "fan"}, CreatedAtString: "2017-01-02T03:04:05Z"}})
+
+	start := time.Now()
+	for _, v := range []*Viewer{plain, fan} {
+		v.startWatch(start)
+		v.awardWatchTime(start.Add(time.Minute*4+time.Second*30), pc)
+		v.awardWatchTime(start.Add(time.Minute*10), pc)
+	}
+
+	vd := plain.GetData()
+	if vd.Balance() != 10 || len(vd.Points.Ledger) != 1 {
+		t.Errorf("Expected 10 points in one watch entry: %+v", vd.Points)
+	}
+	if fan.GetData().Balance() != 30 {
+		t.Errorf("Sub follower should earn 3x not %d", fan.GetData().Balance())
+	}
+
+	// Gone too long earns nothing
+	if got := plain.awardWatchTime(start.Add(time.Hour), pc); got != 0 || plain.GetData().Balance() != 10 {
+		t.Errorf("Gap was awarded %d", got)
+	}
+
+	if err := transferPoints(plain, fan, 11); err != ErrPointsInsufficient {
+		t.Errorf("Overspend allowed: %v", err)
+	}
+	if err := transferPoints(plain, plain, 1); err != ErrPointsInvalid {
+		t.Errorf("Self transfer allowed: %v", err)
+	}
+	if err := transferPoints(fan, plain, 25); err != nil {
+		t.Fatal(err)
+	}
+	vd = plain.GetData()
+	if vd.Balance() != 35 || vd.Points.Earned != 10 || vd.Points.Ledger[1].Other != "2" {
+		t.Errorf("Transfer not recorded: %+v", vd.Points)
+	}
+
+	rList, err := ah.Viewers.PointsLeaderboard(PointsByBalance, 0)
+	if err != nil || len(rList) != 2 || rList[0].Nick != "plain" || rList[1].Rank != 2 {
+		t.Errorf("Leaderboard wrong: %+v %s", rList, err)
+	}
+	rList, _ = ah.Viewers.PointsLeaderboard(PointsByEarned, 1)
+	if len(rList) != 1 || rList[0].Nick != "fan" || rList[0].Earned != 30 {
+		t.Errorf("Earned leaderboard wrong: %+v", rList)
+	}
+	if _, err = ah.Viewers.PointsLeaderboard("shoes", 0); err == nil {
+		t.Errorf("Unknown order accepted")
+	}
+
+	// Chat commands reply through the say pipe
+	chat, err := createIrcClient(&DummyAuth{}, ah.Viewers, "", DefaultDataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat.weakClientRef = ah
+	chat.sayMsgPipe = make(chan string, 5)
+	cooledDown := func() {
+		chat.pointsUsers.lock.Lock()
+		chat.pointsUsers.last = nil
+		chat.pointsUsers.lock.Unlock()
+	}
+	if !chat.pointsCommand(fan, "!give @plain 5") {
+		t.Fatal("Give not handled")
+	}
+	if msg := <-chat.sayMsgPipe; !strings.Contains(msg, "gave 5 points to plain") {
+		t.Errorf("Give reply: %s", msg)
+	}
+	cooledDown()
+	chat.pointsCommand(plain, "!points")
+	if msg := <-chat.sayMsgPipe; !strings.Contains(msg, "plain has 40 points") {
+		t.Errorf("Points reply: %s", msg)
+	}
+	// Unknown nicks are not looked up on twitch
+	cooledDown()
+	chat.pointsCommand(plain, "!give stranger 5")
+	if msg := <-chat.sayMsgPipe; !strings.Contains(msg, "I don't know stranger") {
+		t.Errorf("Give to unknown reply: %s", msg)
+	}
+	cooledDown()
+	chat.pointsCommand(plain, "!points @Fan")
+	if msg := <-chat.sayMsgPipe; !strings.Contains(msg, "fan has") {
+		t.Errorf("Points for other reply: %s", msg)
+	}
+
+	cooledDown()
+	chat.pointsCommand(plain, "!top")
+	select {
+	case msg := <-chat.sayMsgPipe:
+		if !strings.Contains(msg, "1. plain (40)") {
+			t.Errorf("Top reply: %s", msg)
+		}
+	case <-time.After(time.Second):
+		t.Errorf("No top reply")
+	}
+	cooledDown()
+	if !chat.pointsCommand(fan, "!top") {
+		t.Errorf("Top during cooldown not handled")
+	}
+	select {
+	case msg := <-chat.sayMsgPipe:
+		t.Errorf("Top replied during cooldown: %s", msg)
+	case <-time.After(time.Millisecond * 100):
+	}
+
+	// One viewer cannot repeat commands inside their cooldown
+	cooledDown()
+	chat.pointsCommand(fan, "!points")
+	<-chat.sayMsgPipe
+	if !chat.pointsCommand(fan, "!points") || len(chat.sayMsgPipe) != 0 {
+		t.Errorf("Command during viewer cooldown replied")
+	}
+
+	// A full send queue drops replies instead of blocking chat
+	for i := 0; i < cap(chat.sayMsgPipe); i++ {
+		chat.sayMsgPipe <- "PRIVMSG #kimau :filler"
+	}
+	done := make(chan bool)
+	go func() { done <- chat.pointsCommand(plain, "!points") }()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatalf("Reply blocked on a full send queue")
+	}
+	for len(chat.sayMsgPipe) > 0 {
+		<-chat.sayMsgPipe
+	}
+
+	if chat.pointsCommand(plain, "!dance") || chat.pointsCommand(plain, "points") {
+		t.Errorf("Not a points command")
+	}
+
+	ah.Config.Enable.Points = false
+	if chat.pointsCommand(plain, "!points") {
+		t.Errorf("Commands work with points off")
+	}
+}
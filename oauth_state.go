@@ -0,0 +1,135 @@
+package twitch
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	// OAuthStateCookieName - Binds a pending login to the browser that started it
+	OAuthStateCookieName = "oauth_state"
+
+	pkceMethod = "S256"
+)
+
+// PendingLogin - OAuth login which has been sent to twitch and not yet returned
+type PendingLogin struct {
+	Issued   time.Time
+	Admin    bool
+	Scopes   []string
+	binding  string // SHA-256 of the browser cookie nonce
+	verifier string // PKCE code verifier
+}
+
+// pkceChallenge - S256 code challenge for verifier
+func pkceChallenge(verifier string) string {
+	h := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+func hashNonce(nonce string) string {
+	h := sha256.Sum256([]byte(nonce))
+	return base64.RawURLEncoding.EncodeToString(h[:])
+}
+
+// beginOAuth - Issue a single use state bound to this browser and redirect to twitch
+func (ah *Client) beginOAuth(w http.ResponseWriter, req *http.Request, scopes []string, admin bool) {
+	state := authInternalState(base64.RawURLEncoding.EncodeToString(GenerateRandomBytes(24)))
+	nonce := base64.RawURLEncoding.EncodeToString(GenerateRandomBytes(24))
+	verifier := base64.RawURLEncoding.EncodeToString(GenerateRandomBytes(32))
+
+	ah.pendingLock.Lock()
+	ah.purgePendingLogins()
+	ah.PendingLogins[state] = PendingLogin{
+		Issued:   time.Now(),
+		Admin:    admin,
+		Scopes:   scopes,
+		binding:  hashNonce(nonce),
+		verifier: verifier,
+	}
+	ah.pendingLock.Unlock()
+
+	// Lax so the cookie comes back on twitch's redirect
+	stateCookie := ah.makeCookie(OAuthStateCookieName, nonce)
+	stateCookie.MaxAge = int(pendingLoginLifetime / time.Second)
+	http.SetCookie(w, stateCookie)
+
+	fullRedirStr := fmt.Sprintf(twitchAuthURL,
+		ah.ClientID,
+		fmt.Sprintf(redirStringURL, ah.domain),
+		mergeScopeString(scopes),
+		state)
+	fullRedirStr += "&code_challenge=" + url.QueryEscape(pkceChallenge(verifier)) +
+		"&code_challenge_method=" + pkceMethod
+
+	http.Redirect(w, req, fullRedirStr, http.StatusSeeOther)
+}
+
+// claimOAuthState - Validate and consume the state from the redirect, states are single use
+func (ah *Client) claimOAuthState(req *http.Request, state authInternalState) (PendingLogin, error) {
+	ah.pendingLock.Lock()
+	pl, ok := ah.PendingLogins[state]
+	delete(ah.PendingLogins, state)
+	ah.purgePendingLogins()
+	ah.pendingLock.Unlock()
+
+	if !ok {
+		return pl, fmt.Errorf("Unknown login state")
+	}
+
+	if time.Since(pl.Issued) > pendingLoginLifetime {
+		return pl, fmt.Errorf("Login took too long, please try again")
+	}
+
+	c, err := req.Cookie(OAuthStateCookieName)
+	if err != nil {
+		return pl, fmt.Errorf("Login was started in a different browser")
+	}
+
+	if subtle.ConstantTimeCompare([]byte(hashNonce(c.Value)), []byte(pl.binding)) != 1 {
+		return pl, fmt.Errorf("Login was started in a different browser")
+	}
+
+	return pl, nil
+}
+
+// missingScopes - Requested scopes which were not granted
+func missingScopes(requested []string, granted []string) []string {
+	have := make(map[string]bool, len(granted))
+	for _, s := range granted {
+		have[s] = true
+	}
+
+	missing := []string{}
+	for _, s := range requested {
+		if s != "" && !have[s] {
+			missing = append(missing, s)
+		}
+	}
+	return missing
+}
+
+// oauthErrorPage - Explain a failed login and offer a retry
+func (ah *Client) oauthErrorPage(w http.ResponseWriter, status int, title string, details []string) {
+	http.SetCookie(w, ah.clearCookie(OAuthStateCookieName))
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.WriteHeader(status)
+
+	fmt.Fprintf(w, "<html><body><h1>%s</h1>", html.EscapeString(title))
+	if len(details) > 0 {
+		fmt.Fprint(w, "<ul>")
+		for _, d := range details {
+			fmt.Fprintf(w, "<li>%s</li>", html.EscapeString(d))
+		}
+		fmt.Fprint(w, "</ul>")
+	}
+	fmt.Fprintf(w, `<a href="%s">Try again</a></body></html>`, html.EscapeString(strings.TrimSuffix(ah.servePath, "/")+"/"))
+}
@@ -0,0 +1,305 @@
+package twitch
+
+import (
+	"bufio"
+	"encoding/json"
+	"log"
+	"os"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	presenceKeep     = time.Hour * 24 * 7 // Closed sessions older than this are only in the file
+	presenceNamesCap = 1000               // Twitch lists only mods once a room has this many in it
+)
+
+// Evidence a viewer arrived or left
+const (
+	PresenceJoin       = "join"
+	PresencePart       = "part"
+	PresenceNames      = "names"
+	PresenceMessage    = "message"
+	PresenceChatters   = "chatters"
+	PresenceMissing    = "missing" // Absent from a complete NAMES or chatters list
+	PresenceDisconnect = "disconnect"
+	PresenceShutdown   = "shutdown"
+)
+
+// PresenceSession - One continuous stretch of a viewer being in the room
+type PresenceSession struct {
+	ID       ID        `json:"id"`
+	Nick     IrcNick   `json:"nick"`
+	Joined   time.Time `json:"joined"`
+	Left     time.Time `json:"left"` // Zero while still present
+	JoinedBy string    `json:"joined_by"`
+	LeftBy   string    `json:"left_by,omitempty"`
+}
+
+// IsOpen - Still in the room
+func (ps PresenceSession) IsOpen() bool {
+	return ps.Left.IsZero()
+}
+
+// PresentAt - Was in the room at t
+func (ps PresenceSession) PresentAt(t time.Time) bool {
+	return !t.Before(ps.Joined) && (ps.IsOpen() || t.Before(ps.Left))
+}
+
+// Duration - Time in the room, open sessions count up to now
+func (ps PresenceSession) Duration() time.Duration {
+	if ps.IsOpen() {
+		return time.Since(ps.Joined)
+	}
+	return ps.Left.Sub(ps.Joined)
+}
+
+// PresenceLog - Open sessions and recently closed ones, closed sessions are appended to a file
+type PresenceLog struct {
+	lock   sync.RWMutex
+	open   map[IrcNick]*PresenceSession
+	closed []PresenceSession // Oldest left first
+	file   *os.File
+}
+
+// OpenPresenceLog - Load recent sessions from file and keep appending to it, empty filename is memory only
+func OpenPresenceLog(filename string) (*PresenceLog, error) {
+	pl := PresenceLog{
+		open:   make(map[IrcNick]*PresenceSession),
+		closed: []PresenceSession{},
+	}
+
+	if filename == "" {
+		return &pl, nil
+	}
+
+	f, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0600)
+	if err != nil {
+		return nil, err
+	}
+
+	cutoff := time.Now().Add(-presenceKeep)
+	ls := bufio.NewScanner(f)
+	ls.Buffer(make([]byte, 4096), journalMaxLineSize)
+	lineNum := 0
+	for ls.Scan() {
+		lineNum++
+		ps := PresenceSession{}
+		err = json.Unmarshal(ls.Bytes(), &ps)
+		if err != nil {
+			log.Printf("Presence Log %s:%d skipping bad line\n%s", filename, lineNum, err)
+			continue
+		}
+		if ps.Left.After(cutoff) {
+			pl.closed = append(pl.closed, ps)
+		}
+	}
+	if err = ls.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	sort.SliceStable(pl.closed, func(i, j int) bool { return pl.closed[i].Left.Before(pl.closed[j].Left) })
+	pl.file = f
+	return &pl, nil
+}
+
+// Arrive - Open a session unless one is already open, true if this started one
+func (pl *PresenceLog) Arrive(tid ID, nick IrcNick, source string, at time.Time) bool {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	key := nickKey(nick)
+	if _, ok := pl.open[key]; ok {
+		return false
+	}
+	pl.open[key] = &PresenceSession{ID: tid, Nick: key, Joined: at, JoinedBy: source}
+	return true
+}
+
+// Leave - Close the open session of nick
+func (pl *PresenceLog) Leave(nick IrcNick, source string, at time.Time) (PresenceSession, bool) {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	return pl.leaveLocked(nickKey(nick), source, at)
+}
+
+// LeaveAll - Close every open session, used when our view of the room is lost
+func (pl *PresenceLog) LeaveAll(source string, at time.Time) []PresenceSession {
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+
+	psList := make([]PresenceSession, 0, len(pl.open))
+	for key := range pl.open {
+		if ps, ok := pl.leaveLocked(key, source, at); ok {
+			psList = append(psList, ps)
+		}
+	}
+	return psList
+}
+
+func (pl *PresenceLog) leaveLocked(key IrcNick, source string, at time.Time) (PresenceSession, bool) {
+	ps, ok := pl.open[key]
+	if !ok {
+		return PresenceSession{}, false
+	}
+	delete(pl.open, key)
+
+	if at.Before(ps.Joined) {
+		at = ps.Joined
+	}
+	ps.Left = at
+	ps.LeftBy = source
+	pl.closed = append(pl.closed, *ps)
+	pl.trimLocked()
+
+	if pl.file != nil {
+		b, err := json.Marshal(ps)
+		if err == nil {
+			_, err = pl.file.Write(append(b, '\n'))
+		}
+		if err != nil {
+			log.Printf("Presence Log write failed for %s: %s", key, err)
+		}
+	}
+	return *ps, true
+}
+
+func (pl *PresenceLog) trimLocked() {
+	cutoff := time.Now().Add(-presenceKeep)
+	i := sort.Search(len(pl.closed), func(i int) bool { return pl.closed[i].Left.After(cutoff) })
+	if i > 0 {
+		pl.closed = append([]PresenceSession{}, pl.closed[i:]...)
+	}
+}
+
+// IsPresent - Has an open session
+func (pl *PresenceLog) IsPresent(nick IrcNick) bool {
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
+
+	_, ok := pl.open[nickKey(nick)]
+	return ok
+}
+
+// Present - Every open session ordered by nick
+func (pl *PresenceLog) Present() []PresenceSession {
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
+
+	psList := make([]PresenceSession, 0, len(pl.open))
+	for _, ps := range pl.open {
+		psList = append(psList, *ps)
+	}
+	sort.Slice(psList, func(i, j int) bool { return psList[i].Nick < psList[j].Nick })
+	return psList
+}
+
+// At - Sessions covering t ordered by nick, only as far back as presenceKeep
+func (pl *PresenceLog) At(t time.Time) []PresenceSession {
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
+
+	psList := []PresenceSession{}
+	for _, ps := range pl.open {
+		if ps.PresentAt(t) {
+			psList = append(psList, *ps)
+		}
+	}
+
+	// Closed sessions that left before t cannot match
+	i := sort.Search(len(pl.closed), func(i int) bool { return pl.closed[i].Left.After(t) })
+	for _, ps := range pl.closed[i:] {
+		if ps.PresentAt(t) {
+			psList = append(psList, ps)
+		}
+	}
+
+	sort.Slice(psList, func(i, j int) bool { return psList[i].Nick < psList[j].Nick })
+	return psList
+}
+
+// History - Recent sessions of a nick, newest first
+func (pl *PresenceLog) History(nick IrcNick) []PresenceSession {
+	pl.lock.RLock()
+	defer pl.lock.RUnlock()
+
+	key := nickKey(nick)
+	psList := []PresenceSession{}
+	if ps, ok := pl.open[key]; ok {
+		psList = append(psList, *ps)
+	}
+	for i := len(pl.closed) - 1; i >= 0; i-- {
+		if pl.closed[i].Nick == key {
+			psList = append(psList, pl.closed[i])
+		}
+	}
+	return psList
+}
+
+// Close - Close open sessions and the file
+func (pl *PresenceLog) Close() error {
+	pl.LeaveAll(PresenceShutdown, time.Now())
+
+	pl.lock.Lock()
+	defer pl.lock.Unlock()
+	if pl.file == nil {
+		return nil
+	}
+	err := pl.file.Close()
+	pl.file = nil
+	return err
+}
+
+// presenceLog - The client log so sessions outlive reconnects, nil without a client
+func (c *Chat) presenceLog() *PresenceLog {
+	if c.weakClientRef == nil {
+		return nil
+	}
+	return c.weakClientRef.Presence
+}
+
+// reconcileRoom - Everyone listed is present, when the list is complete everyone else has gone
+func (c *Chat) reconcileRoom(vList []*Viewer, source string, complete bool) {
+	listed := make(map[IrcNick]bool)
+	for _, v := range vList {
+		chatter := c.activeInRoom(v, source)
+		listed[chatter.Nick] = true
+	}
+
+	if !complete {
+		return
+	}
+
+	c.roomLock.RLock()
+	gone := []*Viewer{}
+	for nick, v := range c.inRoom {
+		if !listed[nick] {
+			gone = append(gone, v)
+		}
+	}
+	c.roomLock.RUnlock()
+
+	for _, v := range gone {
+		c.partRoom(v, PresenceMissing)
+	}
+	if len(gone) > 0 {
+		c.Logf(LogCatSystem, "%d viewers missing from %s", len(gone), source)
+	}
+}
+
+// leaveRoom - Everyone leaves, time up to now is credited
+func (c *Chat) leaveRoom(source string) {
+	c.roomLock.RLock()
+	vList := make([]*Viewer, 0, len(c.inRoom))
+	for _, v := range c.inRoom {
+		vList = append(vList, v)
+	}
+	c.roomLock.RUnlock()
+
+	for _, v := range vList {
+		c.partRoom(v, source)
+	}
+}
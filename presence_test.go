@@ -0,0 +1,135 @@
+package twitch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestPresenceSessions(t *testing.T) {
+	dir, err := ioutil.TempDir("", "presence")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "kimau_presence.log")
+
+	ah := &Client{Config: DefaultConfig(), RoomName: "kimau"}
+	ah.Viewers = CreateViewerMethod(ah)
+	ah.Presence, err = OpenPresenceLog(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	chat, err := createIrcClient(&DummyAuth{}, ah.Viewers, "", DefaultDataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat.weakClientRef = ah
+
+	ronni := ah.Viewers.GetFromUser(User{ID: "1", Name: "ronni"})
+	fred := ah.Viewers.GetFromUser(User{ID: "2", Name: "fred"})
+	wilma := ah.Viewers.GetFromUser(User{ID: "3", Name: "wilma"})
+
+	// Left hours ago, coming back is not time in channel
+	ronni.SetChatter(Chatter{Nick: "ronni", LastActive: time.Now().Add(-time.Hour * 5)})
+
+	chat.activeInRoom(ronni, PresenceJoin)
+	chat.activeInRoom(fred, PresenceMessage)
+	if vd := ronni.GetData(); vd.Chatter.TimeInChannel > time.Second {
+		t.Errorf("Time away was counted: %s", vd.Chatter.TimeInChannel)
+	}
+	if ps := ah.Presence.Present(); len(ps) != 2 || ps[0].Nick != "fred" || ps[0].JoinedBy != PresenceMessage {
+		t.Errorf("Open sessions wrong: %+v", ps)
+	}
+	joined := time.Now()
+
+	// Complete list without fred means he has gone
+	chat.reconcileRoom([]*Viewer{ronni, wilma}, PresenceNames, true)
+	if chat.IsInRoom("fred") || !chat.IsInRoom("wilma") || ah.Presence.IsPresent("fred") {
+		t.Errorf("Reconcile did not remove fred or add wilma: %v", chat.InRoomNicks())
+	}
+	if h := ah.Presence.History("Fred"); len(h) != 1 || h[0].LeftBy != PresenceMissing {
+		t.Errorf("Fred history wrong: %+v", h)
+	}
+
+	// An incomplete list only adds
+	chat.reconcileRoom([]*Viewer{fred}, PresenceNames, false)
+	if !chat.IsInRoom("ronni") || !chat.IsInRoom("fred") {
+		t.Errorf("Incomplete list removed viewers: %v", chat.InRoomNicks())
+	}
+
+	chat.leaveRoom(PresenceDisconnect)
+	if len(chat.InRoomNicks()) != 0 || len(ah.Presence.Present()) != 0 {
+		t.Errorf("Disconnect left viewers in the room")
+	}
+	left := time.Now()
+	ah.Presence.Close()
+
+	pl, err := OpenPresenceLog(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pl.Close()
+
+	if at := pl.At(joined); len(at) != 2 || at[0].Nick != "fred" || at[1].Nick != "ronni" {
+		t.Errorf("Present after joining wrong: %+v", at)
+	}
+	if at := pl.At(left.Add(time.Second)); len(at) != 0 {
+		t.Errorf("Nobody should be present after disconnect: %+v", at)
+	}
+	if h := pl.History("ronni"); len(h) != 1 || h[0].LeftBy != PresenceDisconnect || h[0].Duration() <= 0 {
+		t.Errorf("Ronni history not reloaded: %+v", h)
+	}
+}
+
+func TestPresenceNamesUnresolved(t *testing.T) {
+	ah := &Client{Config: DefaultConfig(), RoomName: "kimau"}
+	ah.Viewers = CreateViewerMethod(ah)
+	ah.Presence, _ = OpenPresenceLog("")
+
+	lookupErr := fmt.Errorf("API down")
+	ah.User = &UsersMethod{client: ah}
+	ah.User.resolverOnce.Do(func() {})
+	ah.User.resolver = createUserResolver(func(names []IrcNick) ([]User, error) {
+		return nil, lookupErr
+	})
+
+	chat, err := createIrcClient(&DummyAuth{}, ah.Viewers, "", DefaultDataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat.weakClientRef = ah
+
+	ronni := ah.Viewers.GetFromUser(User{ID: "1", Name: "ronni"})
+	fred := ah.Viewers.GetFromUser(User{ID: "2", Name: "fred"})
+	chat.activeInRoom(ronni, PresenceJoin)
+	chat.activeInRoom(fred, PresenceJoin)
+
+	// Lookup for the unknown nick fails so nobody can be called missing
+	chat.nameReplyList = []IrcNick{"ronni", "ghost"}
+	chat.processNameList()
+	if !chat.IsInRoom("fred") || len(ah.Presence.Present()) != 2 {
+		t.Errorf("Failed lookup closed sessions: %v", chat.InRoomNicks())
+	}
+
+	// Lookup works but the nick does not exist
+	lookupErr = nil
+	ah.User.resolver = createUserResolver(func(names []IrcNick) ([]User, error) {
+		return []User{}, lookupErr
+	})
+	chat.nameReplyList = []IrcNick{"ronni", "ghost"}
+	chat.processNameList()
+	if !chat.IsInRoom("fred") {
+		t.Errorf("Unresolved nick closed sessions: %v", chat.InRoomNicks())
+	}
+
+	chat.nameReplyList = []IrcNick{"ronni"}
+	chat.processNameList()
+	if chat.IsInRoom("fred") || !chat.IsInRoom("ronni") {
+		t.Errorf("Resolved list should reconcile: %v", chat.InRoomNicks())
+	}
+}
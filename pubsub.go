@@ -2,7 +2,6 @@ package twitch
 
 import (
 	"fmt"
-	"log"
 	"regexp"
 	"strings"
 	"time"
@@ -203,7 +202,7 @@ func (ps *PubSubConn) runningLoop() {
 		// Connect to Twitch
 		ps.ws, err = wshelper.ClientDial(psWebSockAddr)
 		if err != nil {
-			log.Printf("PUBSUB: %s", err.Error())
+			ps.weakClientRef.logf("PUBSUB: %s", err.Error())
 			continue
 		}
 
@@ -236,7 +235,7 @@ func (ps *PubSubConn) runningLoop() {
 				// Pong Timeout
 			case _, ok := <-ps.pongTimeOut.C:
 				if ok {
-					log.Printf("PUBSUB - PONG TIMEOUT")
+					ps.weakClientRef.logf("PUBSUB - PONG TIMEOUT")
 				}
 
 			// New Subs
@@ -349,10 +348,10 @@ func (ps *PubSubConn) handleCmdResponse(inputData []byte) {
 	psm := PubSubBase{}
 	err := json.Unmarshal(inputData, &psm)
 	if err != nil {
-		log.Printf("PUBSUB [ERROR]: %s", err.Error())
+		ps.weakClientRef.logf("PUBSUB [ERROR]: %s", err.Error())
 	}
 
-	// log.Printf("PUBSUB DEBUG: %s", inputData)
+	// ps.weakClientRef.logf("PUBSUB DEBUG: %s", inputData)
 
 	switch psm.Type {
 	case "PONG":
@@ -360,17 +359,17 @@ func (ps *PubSubConn) handleCmdResponse(inputData []byte) {
 
 	case "RESPONSE":
 		if len(psm.Error) > 3 {
-			log.Printf("PUBSUB RESPONSE: %s", psm.Error)
+			ps.weakClientRef.logf("PUBSUB RESPONSE: %s", psm.Error)
 		}
 
 	case "MESSAGE":
 		err := ps.handleMessageResponse(&psm)
 		if err != nil {
-			log.Printf("PUBSUB [ERROR]: %s", err.Error())
+			ps.weakClientRef.logf("PUBSUB [ERROR]: %s", err.Error())
 		}
 
 	default:
-		log.Printf("PUBSUB [DEBUG]: %s", inputData)
+		ps.weakClientRef.logf("PUBSUB [DEBUG]: %s", inputData)
 	}
 }
 
@@ -383,7 +382,7 @@ func (ps *PubSubConn) sendListen(topicList PubSubTopicList) error {
 		"data": { "topics": ["%s"], "auth_token": "%s" }}`,
 		nonce, topicList, authToken)
 
-	log.Printf("PUBSUB: LISTEN [%s]", topicList)
+	ps.weakClientRef.logf("PUBSUB: LISTEN [%s]", topicList)
 
 	return ps.ws.WriteString(listenJSON)
 }
@@ -0,0 +1,374 @@
+package twitch
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	// SecretKeyEnv - Base64 AES-256 key, takes priority over the key file
+	SecretKeyEnv = "TWITCH_SECRET_KEY"
+	// SecretOldKeysEnv - Comma separated keys only used to decrypt
+	SecretOldKeysEnv = "TWITCH_SECRET_KEY_OLD"
+
+	secretKeyFile    = "twitch_secret.key"
+	secretOldKeyFile = "twitch_secret.key.old"
+	secretsFile      = "twitch_secret.json"
+	adminTokenFile   = "twitch_secret_token.json"
+
+	secretFileMode os.FileMode = 0600
+	secretPrefix               = "enc:v1:"
+	secretKeySize              = 32
+)
+
+var (
+	secretBoxLock   sync.Mutex
+	secretBoxActive = make(map[string]*SecretBox) // By data dir
+)
+
+// SecretBox - AES-GCM sealing with a primary key and older keys kept for reading
+type SecretBox struct {
+	dir     string
+	primary []byte
+	old     [][]byte
+	fromEnv bool
+}
+
+func secretKeyID(key []byte) string {
+	h := sha256.Sum256(key)
+	return hex.EncodeToString(h[:4])
+}
+
+func decodeSecretKey(s string) ([]byte, error) {
+	s = strings.TrimSpace(s)
+	key, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		key, err = base64.RawURLEncoding.DecodeString(s)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("Secret key is not base64: %s", err)
+	}
+	if len(key) != secretKeySize {
+		return nil, fmt.Errorf("Secret key must be %d bytes not %d", secretKeySize, len(key))
+	}
+	return key, nil
+}
+
+func readSecretKeys(list string) [][]byte {
+	keys := [][]byte{}
+	for _, s := range strings.FieldsFunc(list, func(r rune) bool { return r == ',' || r == '\n' }) {
+		key, err := decodeSecretKey(s)
+		if err != nil {
+			log.Printf("Ignoring old secret key: %s", err)
+			continue
+		}
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// writeSecretFile - Write data readable only by this user, fixing the mode of older files
+func writeSecretFile(filename string, data []byte) error {
+	err := ioutil.WriteFile(filename, data, secretFileMode)
+	if err != nil {
+		return err
+	}
+	return os.Chmod(filename, secretFileMode)
+}
+
+// LoadSecretBox - Key from SecretKeyEnv or the key file in dataDir, a key file is created if there is neither
+func LoadSecretBox(dataDir string) (*SecretBox, error) {
+	sb := SecretBox{dir: dataDir}
+	keyFile := filepath.Join(dataDir, secretKeyFile)
+
+	if envKey := os.Getenv(SecretKeyEnv); envKey != "" {
+		key, err := decodeSecretKey(envKey)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", SecretKeyEnv, err)
+		}
+		sb.primary = key
+		sb.fromEnv = true
+	} else {
+		fileData, err := ioutil.ReadFile(keyFile)
+		switch {
+		case err == nil:
+			sb.primary, err = decodeSecretKey(string(fileData))
+			if err != nil {
+				return nil, fmt.Errorf("%s: %s", keyFile, err)
+			}
+		case os.IsNotExist(err):
+			sb.primary = GenerateRandomBytes(secretKeySize)
+			err = writeSecretFile(keyFile, []byte(base64.StdEncoding.EncodeToString(sb.primary)))
+			if err != nil {
+				return nil, err
+			}
+			log.Printf("Created secret key %s, keep it safe or set %s", keyFile, SecretKeyEnv)
+		default:
+			return nil, err
+		}
+	}
+
+	sb.old = readSecretKeys(os.Getenv(SecretOldKeysEnv))
+	if fileData, err := ioutil.ReadFile(filepath.Join(dataDir, secretOldKeyFile)); err == nil {
+		sb.old = append(sb.old, readSecretKeys(string(fileData))...)
+	}
+
+	return &sb, nil
+}
+
+// secretBox - Shared box for the data dir, loaded on first use
+func secretBox(dataDir string) (*SecretBox, error) {
+	if dataDir == "" {
+		dataDir = DefaultDataDir
+	}
+
+	secretBoxLock.Lock()
+	defer secretBoxLock.Unlock()
+
+	if sb, ok := secretBoxActive[dataDir]; ok {
+		return sb, nil
+	}
+
+	sb, err := LoadSecretBox(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	secretBoxActive[dataDir] = sb
+	return sb, nil
+}
+
+// KeyID - Short fingerprint of the primary key
+func (sb *SecretBox) KeyID() string {
+	return secretKeyID(sb.primary)
+}
+
+// IsSealed - Was s produced by Seal
+func IsSealed(s string) bool {
+	return strings.HasPrefix(s, secretPrefix)
+}
+
+// Seal - Encrypt with the primary key
+// enc:v1:<key id>:<base64 nonce + ciphertext>
+func (sb *SecretBox) Seal(plain []byte) (string, error) {
+	block, err := aes.NewCipher(sb.primary)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := GenerateRandomBytes(uint(gcm.NonceSize()))
+	sealed := gcm.Seal(nonce, nonce, plain, []byte(secretPrefix))
+
+	return secretPrefix + sb.KeyID() + ":" + base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+// Open - Decrypt a sealed string with whichever key sealed it
+func (sb *SecretBox) Open(s string) ([]byte, error) {
+	if !IsSealed(s) {
+		return nil, fmt.Errorf("Secret is not sealed")
+	}
+
+	parts := strings.SplitN(strings.TrimPrefix(s, secretPrefix), ":", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Malformed sealed secret")
+	}
+
+	var key []byte
+	for _, k := range append([][]byte{sb.primary}, sb.old...) {
+		if secretKeyID(k) == parts[0] {
+			key = k
+			break
+		}
+	}
+	if key == nil {
+		return nil, fmt.Errorf("No key for sealed secret %s", parts[0])
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, fmt.Errorf("Sealed secret too short")
+	}
+
+	return gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], []byte(secretPrefix))
+}
+
+// openString - Plain text passes through so older files still load
+func (sb *SecretBox) openString(s string) (string, error) {
+	if !IsSealed(s) {
+		return s, nil
+	}
+	b, err := sb.Open(s)
+	return string(b), err
+}
+
+// readSealedFile - Whole file sealed, plain text files are returned as is
+func (sb *SecretBox) readSealedFile(filename string) ([]byte, bool, error) {
+	fileData, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, false, err
+	}
+
+	s := strings.TrimSpace(string(fileData))
+	if !IsSealed(s) {
+		return fileData, false, nil
+	}
+
+	b, err := sb.Open(s)
+	return b, true, err
+}
+
+func (sb *SecretBox) writeSealedFile(filename string, plain []byte) error {
+	s, err := sb.Seal(plain)
+	if err != nil {
+		return err
+	}
+	return writeSecretFile(filename, []byte(s))
+}
+
+// sealAuth - Copy of the auth holding only the sealed JSON, for dumps
+func (sb *SecretBox) sealAuth(ua *UserAuth) (*UserAuth, error) {
+	b, err := json.Marshal(ua)
+	if err != nil {
+		return nil, err
+	}
+
+	s, err := sb.Seal(b)
+	if err != nil {
+		return nil, err
+	}
+	return &UserAuth{Sealed: s}, nil
+}
+
+// openAuth - Reverse of sealAuth, auth which was never sealed is returned as is
+func (sb *SecretBox) openAuth(ua *UserAuth) (*UserAuth, error) {
+	if ua == nil || ua.Sealed == "" {
+		return ua, nil
+	}
+
+	b, err := sb.Open(ua.Sealed)
+	if err != nil {
+		return nil, err
+	}
+
+	opened := UserAuth{}
+	err = json.Unmarshal(b, &opened)
+	return &opened, err
+}
+
+// saveSecrets - Write the secrets file with secret fields sealed
+func (ah *Client) saveSecrets(sb *SecretBox) error {
+	sd := *ah.tokenData
+	var err error
+
+	sd.ClientSecret, err = sb.Seal([]byte(ah.tokenData.ClientSecret))
+	if err != nil {
+		return err
+	}
+
+	sd.Webhooks = append([]WebhookTarget{}, ah.tokenData.Webhooks...)
+	for i, wt := range sd.Webhooks {
+		if wt.Secret == "" {
+			continue
+		}
+		sd.Webhooks[i].Secret, err = sb.Seal([]byte(wt.Secret))
+		if err != nil {
+			return err
+		}
+	}
+
+	b, err := json.MarshalIndent(sd, "", "  ")
+	if err != nil {
+		return err
+	}
+	return writeSecretFile(ah.Config.dataPath(secretsFile), b)
+}
+
+// RotateSecretKey - Switch to a new key and reseal the secrets, admin token and viewer dump
+// The old key is kept in the old key file so anything missed can still be read
+func (ah *Client) RotateSecretKey() (string, error) {
+	sb, err := secretBox(ah.Config.DataDir)
+	if err != nil {
+		return "", err
+	}
+
+	if sb.fromEnv {
+		return "", fmt.Errorf("Secret key comes from %s, set the new key there and the old one in %s", SecretKeyEnv, SecretOldKeysEnv)
+	}
+
+	oldKeyFile := filepath.Join(sb.dir, secretOldKeyFile)
+	oldList := base64.StdEncoding.EncodeToString(sb.primary)
+	if fileData, err := ioutil.ReadFile(oldKeyFile); err == nil {
+		oldList = strings.TrimSpace(string(fileData)) + "\n" + oldList
+	}
+	err = writeSecretFile(oldKeyFile, []byte(oldList))
+	if err != nil {
+		return "", err
+	}
+
+	newBox := SecretBox{
+		dir:     sb.dir,
+		primary: GenerateRandomBytes(secretKeySize),
+		old:     append([][]byte{sb.primary}, sb.old...),
+	}
+	err = writeSecretFile(filepath.Join(sb.dir, secretKeyFile), []byte(base64.StdEncoding.EncodeToString(newBox.primary)))
+	if err != nil {
+		return "", err
+	}
+
+	secretBoxLock.Lock()
+	secretBoxActive[sb.dir] = &newBox
+	secretBoxLock.Unlock()
+
+	ah.logf("Rotated secret key %s to %s", sb.KeyID(), newBox.KeyID())
+
+	if err = ah.saveSecrets(&newBox); err != nil {
+		return newBox.KeyID(), err
+	}
+
+	if ah.AdminAuth != nil && ah.AdminAuth.Token != nil {
+		if err = ah.saveToken(); err != nil {
+			return newBox.KeyID(), err
+		}
+	}
+
+	if ah.Viewers != nil && ah.Viewers.db != nil {
+		ah.Viewers.FlushChanges()
+		if err = ah.Viewers.db.Compact(); err != nil {
+			return newBox.KeyID(), err
+		}
+	}
+
+	if ah.Viewers != nil {
+		if err = ah.DumpViewers(); err != nil {
+			return newBox.KeyID(), err
+		}
+	}
+
+	return newBox.KeyID(), nil
+}
@@ -0,0 +1,88 @@
+package twitch
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSecretStore(t *testing.T) {
+	keyFile := filepath.Join(DefaultDataDir, secretKeyFile)
+	os.Setenv(SecretKeyEnv, "")
+	os.Remove(keyFile)
+	os.Remove(filepath.Join(DefaultDataDir, secretOldKeyFile))
+
+	sb, err := LoadSecretBox(DefaultDataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fi, err := os.Stat(keyFile)
+	if err != nil || fi.Mode().Perm() != secretFileMode {
+		t.Errorf("Key file should be created %v with mode %v: %v", keyFile, secretFileMode, fi)
+	}
+
+	sealed, err := sb.Seal([]byte("hunter2"))
+	if err != nil || !IsSealed(sealed) {
+		t.Fatalf("Seal failed %s: %s", sealed, err)
+	}
+	if s, err := sb.openString(sealed); err != nil || s != "hunter2" {
+		t.Errorf("Open gave %s: %s", s, err)
+	}
+	if s, _ := sb.openString("plain"); s != "plain" {
+		t.Errorf("Plain text should pass through: %s", s)
+	}
+
+	ua := &UserAuth{AuthCode: "abc", Scopes: map[string]bool{scopeChatLogin: true}}
+	sealedAuth, err := sb.sealAuth(ua)
+	if err != nil || sealedAuth.AuthCode != "" || sealedAuth.Sealed == "" {
+		t.Fatalf("Sealed auth leaks: %#v %s", sealedAuth, err)
+	}
+
+	// Rotate with the key from the file
+	secretBoxActive[DefaultDataDir] = sb
+	ah := &Client{tokenData: &tokenData{ClientSecret: "shh"}}
+	if _, err = ah.RotateSecretKey(); err != nil {
+		t.Fatal(err)
+	}
+
+	reloaded, err := LoadSecretBox(DefaultDataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reloaded.KeyID() == sb.KeyID() {
+		t.Errorf("Key did not change")
+	}
+
+	opened, err := reloaded.openAuth(sealedAuth)
+	if err != nil || opened.AuthCode != "abc" || !opened.Scopes[scopeChatLogin] {
+		t.Errorf("Old key should still open auth: %#v %s", opened, err)
+	}
+
+	fileData, wasSealed, err := reloaded.readSealedFile(filepath.Join(DefaultDataDir, secretsFile))
+	if err != nil || wasSealed {
+		t.Errorf("Secrets file should be JSON with sealed fields: %s", err)
+	} else if IsSealed(string(fileData)) || !IsSealed(jsonField(t, fileData, "client_secret")) {
+		t.Errorf("Client secret not sealed: %s", fileData)
+	}
+
+	// Env key takes priority and cannot be rotated here
+	os.Setenv(SecretKeyEnv, base64.StdEncoding.EncodeToString(GenerateRandomBytes(secretKeySize)))
+	defer os.Setenv(SecretKeyEnv, "")
+	delete(secretBoxActive, DefaultDataDir)
+	if _, err = ah.RotateSecretKey(); err == nil {
+		t.Errorf("Rotation should refuse an env key")
+	}
+	delete(secretBoxActive, DefaultDataDir)
+}
+
+func jsonField(t *testing.T, b []byte, field string) string {
+	m := map[string]interface{}{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		t.Fatal(err)
+	}
+	s, _ := m[field].(string)
+	return s
+}
@@ -0,0 +1,321 @@
+package twitch
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+const (
+	sessionIdleTimeout   = time.Hour * 24 * 14 // Sliding expiry after last use
+	sessionMaxAge        = time.Hour * 24 * 90 // Hard limit from creation
+	sessionRefreshEvery  = time.Minute * 5     // How often use extends the expiry
+	sessionMaxPerViewer  = 10
+	pendingLoginLifetime = time.Minute * 10
+)
+
+// UserSession - Server side record of a login, the token is only ever in the cookie
+type UserSession struct {
+	Hash      string    `json:"hash"` // SHA-256 of the token
+	Created   time.Time `json:"created"`
+	LastSeen  time.Time `json:"last_seen"`
+	Expires   time.Time `json:"expires"`
+	UserAgent string    `json:"user_agent,omitempty"`
+}
+
+func hashSessionToken(token string) string {
+	h := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(h[:])
+}
+
+// expiresFrom - Sliding expiry capped at max age
+func (us *UserSession) expiresFrom(t time.Time) time.Time {
+	exp := t.Add(sessionIdleTimeout)
+	if hard := us.Created.Add(sessionMaxAge); exp.After(hard) {
+		exp = hard
+	}
+	return exp
+}
+
+// liveSessions - Sessions which have not expired
+func (ua *UserAuth) liveSessions(now time.Time) []UserSession {
+	sList := []UserSession{}
+	for _, s := range ua.Sessions {
+		if now.Before(s.Expires) {
+			sList = append(sList, s)
+		}
+	}
+	return sList
+}
+
+// StartSession - Create a session for the viewer and return the token and expiry for the cookie
+func (vw *Viewer) StartSession(req *http.Request) (string, time.Time, error) {
+	vw.Lockme()
+	defer vw.Unlockme()
+
+	if vw.data.Auth == nil {
+		return "", time.Time{}, fmt.Errorf("Viewer %s has not logged in", vw.data.TwitchID)
+	}
+
+	now := time.Now()
+	token := GenerateRandomString(32)
+	us := UserSession{
+		Hash:      hashSessionToken(token),
+		Created:   now,
+		LastSeen:  now,
+		UserAgent: req.UserAgent(),
+	}
+	us.Expires = us.expiresFrom(now)
+
+	// Copy on write, ViewerData copies share the Auth pointer
+	ua := *vw.data.Auth
+	ua.Sessions = append(ua.liveSessions(now), us)
+	if len(ua.Sessions) > sessionMaxPerViewer {
+		ua.Sessions = ua.Sessions[len(ua.Sessions)-sessionMaxPerViewer:]
+	}
+	vw.data.Auth = &ua
+
+	return token, us.Expires, nil
+}
+
+// touchSession - Check token and slide expiry, returns the new expiry if the cookie should be refreshed
+func (vw *Viewer) touchSession(token string) (bool, time.Time) {
+	vw.Lockme()
+	defer vw.Unlockme()
+
+	if vw.data.Auth == nil {
+		return false, time.Time{}
+	}
+
+	now := time.Now()
+	hash := hashSessionToken(token)
+	for i, s := range vw.data.Auth.Sessions {
+		if subtle.ConstantTimeCompare([]byte(s.Hash), []byte(hash)) != 1 {
+			continue
+		}
+
+		if !now.Before(s.Expires) {
+			return false, time.Time{}
+		}
+
+		if now.Sub(s.LastSeen) < sessionRefreshEvery {
+			return true, time.Time{}
+		}
+
+		ua := *vw.data.Auth
+		ua.Sessions = append([]UserSession{}, ua.Sessions...)
+		ua.Sessions[i].LastSeen = now
+		ua.Sessions[i].Expires = ua.Sessions[i].expiresFrom(now)
+		vw.data.Auth = &ua
+
+		return true, ua.Sessions[i].Expires
+	}
+
+	return false, time.Time{}
+}
+
+// EndSession - Remove the session for token (logout)
+func (vw *Viewer) EndSession(token string) {
+	vw.Lockme()
+	defer vw.Unlockme()
+
+	if vw.data.Auth == nil {
+		return
+	}
+
+	hash := hashSessionToken(token)
+	ua := *vw.data.Auth
+	ua.Sessions = []UserSession{}
+	for _, s := range vw.data.Auth.Sessions {
+		if s.Hash != hash {
+			ua.Sessions = append(ua.Sessions, s)
+		}
+	}
+	vw.data.Auth = &ua
+}
+
+// RevokeSessions - Log the viewer out everywhere
+func (vw *Viewer) RevokeSessions() int {
+	vw.Lockme()
+	defer vw.Unlockme()
+
+	if vw.data.Auth == nil {
+		return 0
+	}
+
+	ua := *vw.data.Auth
+	num := len(ua.Sessions)
+	ua.Sessions = []UserSession{}
+	vw.data.Auth = &ua
+
+	return num
+}
+
+// cookiePath - Cookies are scoped to the path we are served from
+func (ah *Client) cookiePath() string {
+	p := strings.TrimSuffix(ah.servePath, "/")
+	if p == "" {
+		return "/"
+	}
+	return p
+}
+
+// makeCookie - Cookie scoped to us, Secure unless the config says we are served over plain http
+func (ah *Client) makeCookie(name, value string) *http.Cookie {
+	return &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     ah.cookiePath(),
+		HttpOnly: true,
+		Secure:   !ah.Config.InsecureHTTP,
+		SameSite: http.SameSiteLaxMode,
+	}
+}
+
+func (ah *Client) makeSessionCookie(tid ID, token string, expires time.Time) *http.Cookie {
+	c := ah.makeCookie(UserAuthSessionCookieName, fmt.Sprintf("%s:%s", tid, token))
+	c.Expires = expires
+	return c
+}
+
+func (ah *Client) clearCookie(name string) *http.Cookie {
+	c := ah.makeCookie(name, "")
+	c.MaxAge = -1
+	return c
+}
+
+// parseSessionCookie - Split cookie into viewer ID and token
+func parseSessionCookie(req *http.Request) (ID, string, error) {
+	c, err := req.Cookie(UserAuthSessionCookieName)
+	if err != nil {
+		return "", "", err
+	}
+
+	cList := strings.SplitN(c.Value, ":", 2)
+	if len(cList) != 2 || cList[0] == "" || cList[1] == "" {
+		return "", "", fmt.Errorf("Malformed session cookie")
+	}
+
+	return ID(cList[0]), cList[1], nil
+}
+
+// sessionViewer - Viewer whose session cookie is on the request, only known viewers are checked
+// Slides the expiry and refreshes the cookie on w when it has moved
+func (ah *Client) sessionViewer(w http.ResponseWriter, req *http.Request) (*Viewer, error) {
+	tid, token, err := parseSessionCookie(req)
+	if err != nil {
+		return nil, err
+	}
+
+	v, ok := ah.Viewers.lookup(tid)
+	if !ok {
+		return nil, fmt.Errorf("Unknown session user %s", tid)
+	}
+
+	valid, newExpiry := v.touchSession(token)
+	if !valid {
+		return nil, fmt.Errorf("Invalid or expired session for %s", tid)
+	}
+
+	if !newExpiry.IsZero() && w != nil {
+		http.SetCookie(w, ah.makeSessionCookie(tid, token, newExpiry))
+	}
+
+	return v, nil
+}
+
+// loginViewer - Store auth for a viewer keeping their existing sessions and start a new one
+func (ah *Client) loginViewer(w http.ResponseWriter, req *http.Request, tid ID, authU UserAuth) (*Viewer, error) {
+	v := ah.Viewers.GetPtr(tid)
+	if v == nil {
+		return nil, fmt.Errorf("Unable to find viewer %s", tid)
+	}
+
+	if old := v.GetData().Auth; old != nil {
+		authU.Sessions = old.liveSessions(time.Now())
+	}
+	v.SetAuth(authU)
+
+	token, expires, err := v.StartSession(req)
+	if err != nil {
+		return nil, err
+	}
+
+	http.SetCookie(w, ah.makeSessionCookie(tid, token, expires))
+	return v, nil
+}
+
+// sameOrigin - Request was not made by another site, checked with Sec-Fetch-Site and then Origin
+// Requests with neither header come from something other than a modern browser and are allowed
+func (ah *Client) sameOrigin(req *http.Request) bool {
+	switch req.Header.Get("Sec-Fetch-Site") {
+	case "":
+	case "same-origin", "none":
+		return true
+	default:
+		return false
+	}
+
+	origin := req.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+
+	host := ah.domain
+	if i := strings.Index(host, "/"); i >= 0 {
+		host = host[:i]
+	}
+	return strings.EqualFold(u.Host, host) || strings.EqualFold(u.Host, req.Host)
+}
+
+// logout - End this session, ending every session needs a POST from our own pages
+func (ah *Client) logout(w http.ResponseWriter, req *http.Request) {
+	if !ah.sameOrigin(req) {
+		http.Error(w, "Cross site logout refused", http.StatusForbidden)
+		return
+	}
+
+	everywhere := req.FormValue("all") == "1"
+	if everywhere && req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, "Logging out everywhere needs a POST", http.StatusMethodNotAllowed)
+		return
+	}
+
+	v, err := ah.sessionViewer(nil, req)
+	http.SetCookie(w, ah.clearCookie(UserAuthSessionCookieName))
+	if err != nil {
+		fmt.Fprint(w, "Logged out")
+		return
+	}
+
+	if everywhere {
+		num := v.RevokeSessions()
+		fmt.Fprintf(w, "Logged out of %d sessions", num)
+		return
+	}
+
+	_, token, _ := parseSessionCookie(req)
+	v.EndSession(token)
+	fmt.Fprint(w, "Logged out")
+}
+
+// purgePendingLogins - Forget OAuth states older than pendingLoginLifetime, must hold pendingLock
+func (ah *Client) purgePendingLogins() {
+	cutoff := time.Now().Add(-pendingLoginLifetime)
+	for state, pl := range ah.PendingLogins {
+		if pl.Issued.Before(cutoff) {
+			delete(ah.PendingLogins, state)
+		}
+	}
+}
@@ -0,0 +1,191 @@
+package twitch
+
+import (
+	"bytes"
+	"encoding/gob"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestViewerSessions(t *testing.T) {
+	v := &Viewer{data: ViewerData{TwitchID: "7", Auth: &UserAuth{}}}
+	req := httptest.NewRequest("GET", "/", nil)
+
+	token, expires, err := v.StartSession(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expires.After(time.Now().Add(sessionIdleTimeout + time.Minute)) {
+		t.Errorf("Session expiry wrong %v", expires)
+	}
+
+	if strings.Contains(v.data.Auth.Sessions[0].Hash, token) {
+		t.Error("Raw token stored server side")
+	}
+
+	// Survives a viewer dump round trip
+	var buf bytes.Buffer
+	if err = gob.NewEncoder(&buf).Encode(v.GetData()); err != nil {
+		t.Fatal(err)
+	}
+	loaded := &Viewer{}
+	if err = gob.NewDecoder(&buf).Decode(&loaded.data); err != nil {
+		t.Fatal(err)
+	}
+	if ok, _ := loaded.touchSession(token); !ok {
+		t.Error("Session lost in dump")
+	}
+	if ok, _ := loaded.touchSession("guess"); ok {
+		t.Error("Unknown token accepted")
+	}
+
+	// Sliding expiry moves forward once stale
+	loaded.data.Auth.Sessions[0].LastSeen = time.Now().Add(-time.Hour)
+	if ok, exp := loaded.touchSession(token); !ok || exp.IsZero() {
+		t.Error("Expiry did not slide")
+	}
+
+	loaded.data.Auth.Sessions[0].Expires = time.Now().Add(-time.Second)
+	if ok, _ := loaded.touchSession(token); ok {
+		t.Error("Expired session accepted")
+	}
+
+	v.StartSession(req)
+	v.EndSession(token)
+	if len(v.data.Auth.Sessions) != 1 {
+		t.Errorf("Logout left %d sessions", len(v.data.Auth.Sessions))
+	}
+	if v.RevokeSessions() != 1 || len(v.data.Auth.Sessions) != 0 {
+		t.Error("Revoke all failed")
+	}
+}
+
+func TestSessionCookie(t *testing.T) {
+	ah := &Client{servePath: "/stream/twitch/", Config: DefaultConfig()}
+
+	c := ah.makeSessionCookie("7", "token", time.Now().Add(time.Hour))
+	if !c.HttpOnly || !c.Secure || c.Path != "/stream/twitch" || c.Value != "7:token" {
+		t.Errorf("Cookie flags or path wrong %v", c)
+	}
+
+	ah.Config.InsecureHTTP = true
+	ah.servePath = "/"
+	if c = ah.clearCookie(UserAuthSessionCookieName); c.Secure || c.Path != "/" || c.MaxAge >= 0 {
+		t.Errorf("Plain http cookie wrong %v", c)
+	}
+}
+
+func TestLogoutEverywhere(t *testing.T) {
+	ah := &Client{domain: "example.com/twitch/", servePath: "/twitch/", Config: DefaultConfig()}
+	ah.Viewers = CreateViewerMethod(ah)
+	ah.Viewers.Set(ViewerData{TwitchID: "7", User: &User{ID: "7", Name: "ronni"}, Auth: &UserAuth{}})
+	v := ah.Viewers.GetPtr("7")
+	v.StartSession(httptest.NewRequest("GET", "/", nil))
+	token, expires, _ := v.StartSession(httptest.NewRequest("GET", "/", nil))
+	cookie := ah.makeSessionCookie("7", token, expires)
+
+	logout := func(method string, header http.Header) int {
+		req := httptest.NewRequest(method, "/twitch/logout?all=1", nil)
+		for k := range header {
+			req.Header.Set(k, header.Get(k))
+		}
+		req.AddCookie(cookie)
+		rec := httptest.NewRecorder()
+		ah.ServeHTTP(rec, req)
+		return rec.Code
+	}
+
+	if code := logout("GET", nil); code != http.StatusMethodNotAllowed {
+		t.Errorf("GET logout everywhere gave %d", code)
+	}
+	if code := logout("POST", http.Header{"Sec-Fetch-Site": {"cross-site"}}); code != http.StatusForbidden {
+		t.Errorf("Cross site logout gave %d", code)
+	}
+	if code := logout("POST", http.Header{"Origin": {"https://evil.example"}}); code != http.StatusForbidden {
+		t.Errorf("Foreign origin logout gave %d", code)
+	}
+	if len(v.GetData().Auth.Sessions) != 2 {
+		t.Fatalf("Refused logouts ended sessions")
+	}
+
+	if code := logout("POST", http.Header{"Origin": {"https://example.com"}, "Sec-Fetch-Site": {"same-origin"}}); code != http.StatusOK {
+		t.Errorf("Same origin logout gave %d", code)
+	}
+	if len(v.GetData().Auth.Sessions) != 0 {
+		t.Errorf("Sessions left after logout everywhere")
+	}
+}
+
+func TestPurgePendingLogins(t *testing.T) {
+	ah := &Client{PendingLogins: map[authInternalState]PendingLogin{
+		"old": {Issued: time.Now().Add(-pendingLoginLifetime * 2)},
+		"new": {Issued: time.Now()},
+	}}
+
+	ah.purgePendingLogins()
+	if _, ok := ah.PendingLogins["old"]; ok || len(ah.PendingLogins) != 1 {
+		t.Errorf("Pending logins not purged %v", ah.PendingLogins)
+	}
+}
+
+func TestOAuthStateBinding(t *testing.T) {
+	ah := &Client{tokenData: &tokenData{ClientID: "abc"}, domain: "example.com/twitch/", servePath: "/twitch/",
+		PendingLogins: make(map[authInternalState]PendingLogin)}
+
+	begin := func() (authInternalState, *http.Cookie) {
+		rec := httptest.NewRecorder()
+		ah.beginOAuth(rec, httptest.NewRequest("GET", "/twitch/", nil), DefaultViewerScope, false)
+
+		loc, err := url.Parse(rec.Header().Get("Location"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		state := authInternalState(loc.Query().Get("state"))
+		if loc.Query().Get("code_challenge") != pkceChallenge(ah.PendingLogins[state].verifier) {
+			t.Error("PKCE challenge does not match verifier")
+		}
+		return state, rec.Result().Cookies()[0]
+	}
+
+	claim := func(state authInternalState, c *http.Cookie) error {
+		req := httptest.NewRequest("GET", "/twitch/after_signin/", nil)
+		if c != nil {
+			req.AddCookie(c)
+		}
+		_, err := ah.claimOAuthState(req, state)
+		return err
+	}
+
+	state, c := begin()
+	if err := claim(state, c); err != nil {
+		t.Errorf("Valid state rejected: %s", err)
+	}
+	if err := claim(state, c); err == nil {
+		t.Error("State reused")
+	}
+
+	state, _ = begin()
+	if err := claim(state, &http.Cookie{Name: OAuthStateCookieName, Value: "other"}); err == nil {
+		t.Error("State accepted from another browser")
+	}
+
+	state, c = begin()
+	ah.PendingLogins[state] = PendingLogin{Issued: time.Now().Add(-time.Hour), binding: ah.PendingLogins[state].binding}
+	if err := claim(state, c); err == nil {
+		t.Error("Expired state accepted")
+	}
+
+	rec := httptest.NewRecorder()
+	ah.handlePublicOAuthResult(rec, httptest.NewRequest("GET", "/twitch/after_signin/?code=x&state=forged", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("Forged state got %d", rec.Code)
+	}
+
+	if m := missingScopes([]string{"a", "b"}, []string{"b"}); len(m) != 1 || m[0] != "a" {
+		t.Errorf("missingScopes %v", m)
+	}
+}
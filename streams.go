@@ -1,6 +1,7 @@
 package twitch
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
 	"net/http"
@@ -52,6 +53,8 @@ type HostData struct {
 type StreamsMethod struct {
 	client *Client
 	au     *UserAuth
+
+	chattersURL string // Empty uses tmiChattersURL
 }
 
 // GetStreamByUser - Get Active Stream of User
@@ -93,6 +96,40 @@ func (c *StreamsMethod) GetHostsByUser(id ID) ([]HostData, error) {
 	return hosts.HostList, nil
 }
 
+// GetChatters - Undocumented api listing everyone in chat by category, cached by twitch for about a minute
+// WARNING :: Undocumented API
+func (c *StreamsMethod) GetChatters(room IrcNick) (*ChattersResponse, error) {
+	chattersURL := c.chattersURL
+	if chattersURL == "" {
+		chattersURL = tmiChattersURL
+	}
+
+	req, err := http.NewRequest("GET", fmt.Sprintf(chattersURL, room), nil)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), tmiChattersTimeout)
+	defer cancel()
+
+	resp, err := c.client.httpClient.Do(req.WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("Chatters for %s returned %s", room, resp.Status)
+	}
+
+	chatters := ChattersResponse{}
+	err = json.NewDecoder(resp.Body).Decode(&chatters)
+	if err != nil {
+		return nil, err
+	}
+
+	return &chatters, nil
+}
+
 // func (c *StreamsMethod) GetLiveStreams() ([]*StreamBody, int, error) {}
 // func (c *StreamsMethod) GetStreamsSummary() ([]*StreamBody, int, error) {}
 // func (c *StreamsMethod) GetFeaturedStreams() ([]*StreamBody, int, error) {}
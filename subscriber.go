@@ -0,0 +1,187 @@
+package twitch
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// OverflowPolicy - What a subscription does when its buffer is full
+type OverflowPolicy int
+
+//
+const (
+	OverflowDropNewest OverflowPolicy = iota // Discard the event being posted (original behaviour)
+	OverflowDropOldest                       // Discard the oldest queued event to make room
+	OverflowBlock                            // Wait up to BlockTimeout for room then discard
+	OverflowDisconnect                       // Close the subscription channel
+
+	defaultSubBufferSize   = 10
+	defaultSubBlockTimeout = time.Second
+)
+
+func (op OverflowPolicy) String() string {
+	switch op {
+	case OverflowDropNewest:
+		return "drop-newest"
+	case OverflowDropOldest:
+		return "drop-oldest"
+	case OverflowBlock:
+		return "block"
+	case OverflowDisconnect:
+		return "disconnect"
+	}
+
+	return "unknown"
+}
+
+// MarshalJSON - JSON Helper
+func (op OverflowPolicy) MarshalJSON() ([]byte, error) {
+	return []byte(`"` + op.String() + `"`), nil
+}
+
+// SubOptions - Per subscriber delivery options
+type SubOptions struct {
+	BufferSize   int            `json:"buffer_size"`
+	Overflow     OverflowPolicy `json:"overflow"`
+	BlockTimeout time.Duration  `json:"block_timeout"` // Only used by OverflowBlock
+}
+
+// DefaultSubOptions - Options used by the plain Sub calls
+func DefaultSubOptions() SubOptions {
+	return SubOptions{
+		BufferSize:   defaultSubBufferSize,
+		Overflow:     OverflowDropNewest,
+		BlockTimeout: defaultSubBlockTimeout,
+	}
+}
+
+func (so SubOptions) sanitise() SubOptions {
+	if so.BufferSize < 1 {
+		so.BufferSize = defaultSubBufferSize
+	}
+	if so.Overflow == OverflowBlock && so.BlockTimeout <= 0 {
+		so.BlockTimeout = defaultSubBlockTimeout
+	}
+
+	return so
+}
+
+// SubStats - Snapshot of a subscription for diagnostics
+type SubStats struct {
+	Name         string     `json:"name"`
+	Options      SubOptions `json:"options"`
+	Queued       int        `json:"queued"`
+	Since        time.Time  `json:"since"`
+	Delivered    uint64     `json:"delivered"`
+	DropNewest   uint64     `json:"drop_newest"`
+	DropOldest   uint64     `json:"drop_oldest"`
+	TimedOut     uint64     `json:"timed_out"`
+	Disconnected bool       `json:"disconnected"`
+}
+
+// Dropped - Total events this subscriber never received
+func (ss SubStats) Dropped() uint64 {
+	return ss.DropNewest + ss.DropOldest + ss.TimedOut
+}
+
+// subCounters - Counters updated by the pump and read by diagnostics
+type subCounters struct {
+	delivered    uint64
+	dropNewest   uint64
+	dropOldest   uint64
+	timedOut     uint64
+	disconnected int32
+	since        time.Time
+}
+
+func newSubCounters() *subCounters {
+	return &subCounters{since: time.Now()}
+}
+
+func (sc *subCounters) stats(name string, opts SubOptions, queued int) SubStats {
+	return SubStats{
+		Name:         name,
+		Options:      opts,
+		Queued:       queued,
+		Since:        sc.since,
+		Delivered:    atomic.LoadUint64(&sc.delivered),
+		DropNewest:   atomic.LoadUint64(&sc.dropNewest),
+		DropOldest:   atomic.LoadUint64(&sc.dropOldest),
+		TimedOut:     atomic.LoadUint64(&sc.timedOut),
+		Disconnected: atomic.LoadInt32(&sc.disconnected) != 0,
+	}
+}
+
+// deliverWithPolicy - Shared overflow handling for the typed pumps
+// trySend must not block, popOldest discards one queued item and sendWithin waits up to the timeout
+// returns false if the subscriber should be disconnected
+func deliverWithPolicy(opts SubOptions, sc *subCounters,
+	trySend func() bool, popOldest func() bool, sendWithin func(time.Duration) bool) bool {
+
+	if trySend() {
+		atomic.AddUint64(&sc.delivered, 1)
+		return true
+	}
+
+	switch opts.Overflow {
+	case OverflowDropOldest:
+		if popOldest() {
+			atomic.AddUint64(&sc.dropOldest, 1)
+		}
+		if trySend() {
+			atomic.AddUint64(&sc.delivered, 1)
+		} else {
+			atomic.AddUint64(&sc.dropNewest, 1)
+		}
+
+	case OverflowBlock:
+		if sendWithin(opts.BlockTimeout) {
+			atomic.AddUint64(&sc.delivered, 1)
+		} else {
+			atomic.AddUint64(&sc.timedOut, 1)
+		}
+
+	case OverflowDisconnect:
+		atomic.AddUint64(&sc.dropNewest, 1)
+		atomic.StoreInt32(&sc.disconnected, 1)
+		return false
+
+	default:
+		atomic.AddUint64(&sc.dropNewest, 1)
+	}
+
+	return true
+}
+
+// deliverTo - Send v on a subscriber channel following its overflow policy
+// Only the pump that owns ch may call this as the channel is read to drop the oldest
+// returns false if the subscriber should be disconnected
+func deliverTo[T any](ch chan T, v T, opts SubOptions, sc *subCounters) bool {
+	return deliverWithPolicy(opts, sc,
+		func() bool {
+			select {
+			case ch <- v:
+				return true
+			default:
+				return false
+			}
+		},
+		func() bool {
+			select {
+			case <-ch:
+				return true
+			default:
+				return false
+			}
+		},
+		func(d time.Duration) bool {
+			t := time.NewTimer(d)
+			defer t.Stop()
+			select {
+			case ch <- v:
+				return true
+			case <-t.C:
+				return false
+			}
+		})
+}
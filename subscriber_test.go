@@ -0,0 +1,134 @@
+package twitch
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func waitForSubs(pump *AlertPump, num int) {
+	for i := 0; i < 100 && len(pump.Subscribers()) != num; i++ {
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestAlertPumpOverflow(t *testing.T) {
+	pump := StartAlertPump(nil)
+
+	oldest := pump.SubWithOptions("oldest", SubOptions{BufferSize: 2, Overflow: OverflowDropOldest})
+	newest := pump.SubWithOptions("newest", SubOptions{BufferSize: 2, Overflow: OverflowDropNewest})
+	discon := pump.SubWithOptions("discon", SubOptions{BufferSize: 2, Overflow: OverflowDisconnect})
+	waitForSubs(pump, 3)
+
+	for i := 0; i < 4; i++ {
+		pump.postInternal(Alert{Type: AlertHost, Source: IrcNick(fmt.Sprintf("host%d", i)), Data: i})
+	}
+
+	if a := <-oldest; a.Data != 2 {
+		t.Errorf("Drop oldest kept wrong alert: %v", a.Data)
+	}
+	if a := <-newest; a.Data != 0 {
+		t.Errorf("Drop newest kept wrong alert: %v", a.Data)
+	}
+
+	<-discon
+	<-discon
+	if _, ok := <-discon; ok {
+		t.Error("Disconnect policy left channel open")
+	}
+
+	stats := pump.Subscribers()
+	if len(stats) != 2 {
+		t.Fatalf("Expected 2 subscribers after disconnect got %d", len(stats))
+	}
+
+	for _, s := range stats {
+		if s.Dropped() != 2 {
+			t.Errorf("%s dropped %d expected 2", s.Name, s.Dropped())
+		}
+	}
+}
+
+func subChatPump(cli *chatLogInteral, name string, opts SubOptions) chan LogLineParsed {
+	opts = opts.sanitise()
+	c := make(chan LogLineParsed, opts.BufferSize)
+	cli.newSubs <- &subToChatPump{Name: name, Subbed: []LogCat{LogCatMsg}, C: c, Options: opts, counters: newSubCounters()}
+	for i := 0; i < 100 && len(cli.subscribers()) == 0; i++ {
+		time.Sleep(time.Millisecond)
+	}
+	return c
+}
+
+func TestChatPumpOverflow(t *testing.T) {
+	for _, tt := range []struct {
+		policy    OverflowPolicy
+		first     string
+		delivered uint64
+		dropped   uint64
+		open      bool
+	}{
+		{OverflowDropNewest, "line0", 2, 2, true},
+		{OverflowDropOldest, "line2", 4, 2, true},
+		{OverflowDisconnect, "line0", 2, 1, false},
+	} {
+		cli := startChatLogPump("kimau", DefaultDataDir)
+		c := subChatPump(cli, tt.policy.String(), SubOptions{BufferSize: 2, Overflow: tt.policy})
+
+		for i := 0; i < 4; i++ {
+			cli.postInternal(MakeLogLine(LogCatMsg, fmt.Sprintf("line%d", i)))
+		}
+		cli.postInternal(MakeLogLine(LogCatSystem, "not subbed"))
+
+		if llp := <-c; llp.Body != tt.first {
+			t.Errorf("%s kept %s first expected %s", tt.policy, llp.Body, tt.first)
+		}
+		<-c
+
+		stats := cli.subscribers()
+		if !tt.open {
+			if _, ok := <-c; ok || len(stats) != 0 {
+				t.Errorf("%s left the subscriber connected", tt.policy)
+			}
+			continue
+		}
+		if len(stats) != 1 || stats[0].Dropped() != tt.dropped || stats[0].Delivered != tt.delivered {
+			t.Errorf("%s stats wrong: %+v", tt.policy, stats)
+		}
+	}
+}
+
+func TestChatPumpBlock(t *testing.T) {
+	cli := startChatLogPump("kimau", DefaultDataDir)
+	c := subChatPump(cli, "block", SubOptions{BufferSize: 1, Overflow: OverflowBlock, BlockTimeout: time.Millisecond * 200})
+
+	cli.postInternal(MakeLogLine(LogCatMsg, "line0"))
+	done := make(chan bool)
+	go func() {
+		cli.postInternal(MakeLogLine(LogCatMsg, "line1"))
+		close(done)
+	}()
+
+	// A blocked delivery does not hold the subscriber lock
+	statsDone := make(chan []SubStats)
+	go func() { statsDone <- cli.subscribers() }()
+	select {
+	case <-statsDone:
+	case <-time.After(time.Millisecond * 100):
+		t.Error("Subscribers blocked behind a blocking delivery")
+	}
+
+	if llp := <-c; llp.Body != "line0" {
+		t.Errorf("Block delivered %s first", llp.Body)
+	}
+	<-done
+	if llp := <-c; llp.Body != "line1" {
+		t.Errorf("Block lost the waiting line, got %s", llp.Body)
+	}
+
+	cli.postInternal(MakeLogLine(LogCatMsg, "line2"))
+	cli.postInternal(MakeLogLine(LogCatMsg, "line3"))
+	stats := cli.subscribers()
+	if len(stats) != 1 || stats[0].TimedOut != 1 || stats[0].Delivered != 3 {
+		t.Errorf("Block stats wrong: %+v", stats)
+	}
+}
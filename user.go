@@ -2,7 +2,6 @@ package twitch
 
 import (
 	"fmt"
-	"log"
 	"sync"
 	"time"
 )
@@ -60,6 +59,9 @@ type UserFull struct {
 type UsersMethod struct {
 	client *Client
 	au     *UserAuth
+
+	resolverOnce sync.Once
+	resolver     *userResolver
 }
 
 /******************************************************************************
@@ -109,70 +111,20 @@ func (u *UsersMethod) getByNameSmall(names []IrcNick) ([]User, error) {
 	}
 
 	if uList.Total != len(names) {
-		log.Printf("Total Number of Users was: %d / %d", uList.Total, len(names))
+		u.client.logf("Total Number of Users was: %d / %d", uList.Total, len(names))
 	}
 
 	return uList.UserList, nil
 }
 
 // GetByName - Get User by v3 Name
+// Lookups are batched with any others made at the same time, unknown nicks are left out
 func (u *UsersMethod) GetByName(names []IrcNick) ([]User, error) {
-	numUsersPerGroup := 25
-	if len(names) < 25 {
-		return u.getByNameSmall(names)
-	}
-
-	uList := make([]struct {
-		Total    int    `json:"_total"`
-		UserList []User `json:"users"`
-	}, len(names)/numUsersPerGroup+1, len(names)/numUsersPerGroup+2)
-
-	lNum := 0
-	errChannel := make(chan error, len(names)/numUsersPerGroup+1)
-	wg := sync.WaitGroup{}
-
-	for i := 0; i < len(names); i += numUsersPerGroup {
-		endPointI := i + numUsersPerGroup
-		if endPointI > len(names) {
-			endPointI = len(names)
-		}
-
-		wg.Add(1)
-		go func(gnum int, is int, ie int) {
-			nameList := JoinNickComma(names[is:ie])
-
-			reqStr := fmt.Sprintf("users?login=%s", nameList)
-
-			_, err := u.client.Get(u.au, reqStr, &uList[gnum])
-			if err != nil {
-				errChannel <- err
-			}
-			wg.Done()
-		}(lNum, i, endPointI)
-
-		lNum++
-	}
-
-	wg.Wait()
-	errChannel <- nil
-
-	err := <-errChannel
-	if err != nil {
-		return nil, err
-	}
-
-	finalList := []User{}
-	totalCount := 0
-	for _, v := range uList {
-		finalList = append(finalList, v.UserList...)
-		totalCount += v.Total
-	}
-
-	if totalCount != len(names) {
-		log.Printf("Total Number of Users was: %d / %d", totalCount, len(names))
-	}
+	u.resolverOnce.Do(func() {
+		u.resolver = createUserResolver(u.getByNameSmall)
+	})
 
-	return finalList, nil
+	return u.resolver.Resolve(names)
 }
 
 // EmoteList - Get User Emotes
@@ -0,0 +1,188 @@
+package twitch
+
+import (
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	resolverBatchWindow = time.Millisecond * 50 // Wait for more nicks before asking
+	resolverMaxBatch    = 100                   // users?login limit
+	resolverMaxInFlight = 4
+	resolverNegativeTTL = time.Minute * 30 // Banned or deleted accounts
+	resolverMaxNegative = 10000            // Misses remembered at once, past this they are looked up again
+)
+
+// resolveCall - One nick being looked up, shared by everyone asking for it
+type resolveCall struct {
+	done chan struct{}
+	user *User
+	err  error
+}
+
+// userResolver - Coalesces and batches name lookups so a raid does not become hundreds of API calls
+type userResolver struct {
+	fetch func([]IrcNick) ([]User, error)
+
+	window      time.Duration
+	maxBatch    int
+	negativeTTL time.Duration
+	maxNegative int
+
+	lock     sync.Mutex
+	pending  map[IrcNick]*resolveCall // By nickKey, queued or in flight
+	queue    []IrcNick
+	timer    *time.Timer
+	negative map[IrcNick]time.Time // By nickKey, when the miss expires
+	pruneAt  time.Time
+
+	inFlight chan struct{}
+}
+
+func createUserResolver(fetch func([]IrcNick) ([]User, error)) *userResolver {
+	return &userResolver{
+		fetch: fetch,
+
+		window:      resolverBatchWindow,
+		maxBatch:    resolverMaxBatch,
+		negativeTTL: resolverNegativeTTL,
+		maxNegative: resolverMaxNegative,
+
+		pending:  make(map[IrcNick]*resolveCall),
+		negative: make(map[IrcNick]time.Time),
+		inFlight: make(chan struct{}, resolverMaxInFlight),
+	}
+}
+
+// Resolve - Users for the nicks which exist, unknown nicks are left out
+// The error is the first failed batch, users from other batches are still returned
+func (r *userResolver) Resolve(names []IrcNick) ([]User, error) {
+	now := time.Now()
+	calls := []*resolveCall{}
+
+	r.lock.Lock()
+	for _, n := range names {
+		key := nickKey(n)
+		if key == "" {
+			continue
+		}
+
+		if exp, ok := r.negative[key]; ok {
+			if now.Before(exp) {
+				continue
+			}
+			delete(r.negative, key)
+		}
+
+		rc, ok := r.pending[key]
+		if !ok {
+			rc = &resolveCall{done: make(chan struct{})}
+			r.pending[key] = rc
+			r.queue = append(r.queue, key)
+		}
+		calls = append(calls, rc)
+	}
+
+	if len(r.queue) >= r.maxBatch {
+		r.flushLocked()
+	} else if len(r.queue) > 0 && r.timer == nil {
+		r.timer = time.AfterFunc(r.window, r.flush)
+	}
+	r.lock.Unlock()
+
+	uList := []User{}
+	seen := make(map[ID]bool)
+	var err error
+	for _, rc := range calls {
+		<-rc.done
+		if rc.err != nil && err == nil {
+			err = rc.err
+		}
+		if rc.user != nil && !seen[rc.user.ID] {
+			seen[rc.user.ID] = true
+			uList = append(uList, *rc.user)
+		}
+	}
+
+	return uList, err
+}
+
+func (r *userResolver) flush() {
+	r.lock.Lock()
+	r.flushLocked()
+	r.lock.Unlock()
+}
+
+// flushLocked - Send the queue in batches, must hold lock
+func (r *userResolver) flushLocked() {
+	if r.timer != nil {
+		r.timer.Stop()
+		r.timer = nil
+	}
+
+	if now := time.Now(); now.After(r.pruneAt) {
+		r.pruneNegativeLocked(now)
+	}
+
+	for len(r.queue) > 0 {
+		n := len(r.queue)
+		if n > r.maxBatch {
+			n = r.maxBatch
+		}
+
+		batch := r.queue[:n]
+		r.queue = append([]IrcNick{}, r.queue[n:]...)
+		go r.runBatch(batch)
+	}
+}
+
+// pruneNegativeLocked - Drop expired misses, must hold lock
+func (r *userResolver) pruneNegativeLocked(now time.Time) {
+	for key, exp := range r.negative {
+		if !now.Before(exp) {
+			delete(r.negative, key)
+		}
+	}
+	r.pruneAt = now.Add(r.negativeTTL / 2)
+}
+
+func (r *userResolver) runBatch(batch []IrcNick) {
+	r.inFlight <- struct{}{}
+	uList, err := r.fetch(batch)
+	<-r.inFlight
+
+	found := make(map[IrcNick]*User)
+	for i := range uList {
+		found[nickKey(uList[i].Name)] = &uList[i]
+	}
+
+	if err != nil {
+		log.Printf("Resolver failed for %d nicks: %s", len(batch), err)
+	}
+
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	now := time.Now()
+	missExpires := now.Add(r.negativeTTL)
+	for _, key := range batch {
+		rc := r.pending[key]
+		delete(r.pending, key)
+
+		if err != nil {
+			rc.err = err
+		} else if u, ok := found[key]; ok {
+			rc.user = u
+		} else {
+			if len(r.negative) >= r.maxNegative {
+				r.pruneNegativeLocked(now)
+			}
+			if len(r.negative) < r.maxNegative {
+				r.negative[key] = missExpires
+			}
+		}
+
+		close(rc.done)
+	}
+}
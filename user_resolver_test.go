@@ -0,0 +1,133 @@
+package twitch
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestUserResolver(t *testing.T) {
+	var lock sync.Mutex
+	calls := 0
+	inFlight := 0
+	maxInFlight := 0
+	asked := make(map[IrcNick]int)
+
+	r := createUserResolver(func(names []IrcNick) ([]User, error) {
+		lock.Lock()
+		calls++
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		for _, n := range names {
+			asked[n]++
+		}
+		lock.Unlock()
+
+		if len(names) > resolverMaxBatch {
+			t.Errorf("Batch of %d is over the limit", len(names))
+		}
+
+		time.Sleep(time.Millisecond * 10)
+
+		uList := []User{}
+		for _, n := range names {
+			if strings.HasPrefix(string(n), "banned") {
+				continue
+			}
+			uList = append(uList, User{ID: ID("id_" + n), Name: n})
+		}
+
+		lock.Lock()
+		inFlight--
+		lock.Unlock()
+		return uList, nil
+	})
+
+	// Raid of 500 joins, each nick asked for by several goroutines
+	var wg sync.WaitGroup
+	for g := 0; g < 50; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 10; i++ {
+				n := (g*10 + i) % 250
+				nick := IrcNick(fmt.Sprintf("raider%d", n))
+				if n%25 == 0 {
+					nick = IrcNick(fmt.Sprintf("banned%d", n))
+				}
+
+				uList, err := r.Resolve([]IrcNick{nick, "SHARED"})
+				if err != nil {
+					t.Error(err)
+					return
+				}
+				if n%25 == 0 && len(uList) != 1 {
+					t.Errorf("%s should not resolve: %v", nick, uList)
+				}
+				if n%25 != 0 && len(uList) != 2 {
+					t.Errorf("%s should resolve with shared: %v", nick, uList)
+				}
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	if calls > 20 {
+		t.Errorf("Raid took %d calls, should be batched", calls)
+	}
+	if maxInFlight > resolverMaxInFlight {
+		t.Errorf("%d calls at once is over the cap of %d", maxInFlight, resolverMaxInFlight)
+	}
+	if asked["banned25"] != 1 {
+		t.Errorf("Missing nick fetched %d times, should be cached", asked["banned25"])
+	}
+
+	// Negative cache
+	before := calls
+	uList, err := r.Resolve([]IrcNick{"banned0", "BANNED50"})
+	if err != nil || len(uList) != 0 || calls != before {
+		t.Errorf("Cached miss should not call the API: %v %s %d", uList, err, calls-before)
+	}
+
+	// Errors are not cached
+	failing := createUserResolver(func(names []IrcNick) ([]User, error) {
+		return nil, fmt.Errorf("API down")
+	})
+	if _, err := failing.Resolve([]IrcNick{"someone"}); err == nil {
+		t.Errorf("Expected error")
+	}
+	if len(failing.negative) != 0 {
+		t.Errorf("Failed lookup cached as missing")
+	}
+}
+
+func TestUserResolverNegativeBound(t *testing.T) {
+	r := createUserResolver(func(names []IrcNick) ([]User, error) {
+		return []User{}, nil
+	})
+	r.window = time.Millisecond
+	r.negativeTTL = time.Millisecond * 20
+	r.maxNegative = 5
+
+	for i := 0; i < 8; i++ {
+		r.Resolve([]IrcNick{IrcNick(fmt.Sprintf("gone%d", i))})
+	}
+	r.lock.Lock()
+	if len(r.negative) != 5 {
+		t.Errorf("Negative cache should stop at its cap: %d", len(r.negative))
+	}
+	r.lock.Unlock()
+
+	// Expired misses are dropped on the next flush
+	time.Sleep(time.Millisecond * 30)
+	r.Resolve([]IrcNick{"fresh"})
+	r.lock.Lock()
+	if _, ok := r.negative["fresh"]; !ok || len(r.negative) != 1 {
+		t.Errorf("Expired misses left behind: %v", r.negative)
+	}
+	r.lock.Unlock()
+}
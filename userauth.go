@@ -2,9 +2,7 @@ package twitch
 
 import (
 	"fmt"
-	"net/http"
 	"strings"
-	"time"
 )
 
 const (
@@ -26,10 +24,11 @@ type authToken struct {
 
 // UserAuth - Used to manage OAuth for Logins
 type UserAuth struct {
-	AuthCode      string          `json:"authCode"`
-	IrcCode       string          `json:"ircCode"`
-	Scopes        map[string]bool `json:"scopes"`
-	SessionCookie *http.Cookie    `json:"session_cookie"`
+	AuthCode string          `json:"authCode"`
+	IrcCode  string          `json:"ircCode"`
+	Scopes   map[string]bool `json:"scopes"`
+	Sessions []UserSession   `json:"sessions"`
+	Sealed   string          `json:"sealed,omitempty"` // Everything above encrypted, only set in dumps
 
 	InteralState authInternalState `json:"interal_state"`
 	Token        *authToken        `json:"token"`
@@ -97,20 +96,3 @@ func (ua *UserAuth) checkScope(reqScopes ...string) error {
 
 	return nil
 }
-
-func (ua *UserAuth) checkCookie(c *http.Cookie) bool {
-	return (ua.SessionCookie != nil && c != nil && ua.SessionCookie.Value == c.Value)
-}
-
-func (ua *UserAuth) createSessionCookie(domain string) *http.Cookie {
-	expiration := time.Now().Add(365 * 24 * time.Hour)
-	ua.SessionCookie = &http.Cookie{
-		Name:    UserAuthSessionCookieName,
-		Value:   fmt.Sprintf("%s:%s", ua.Token.UserID, GenerateRandomString(16)),
-		Domain:  domain, // Wont work for local host because not valid domain
-		Path:    "/twitch",
-		Expires: expiration,
-	}
-
-	return ua.SessionCookie
-}
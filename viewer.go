@@ -19,19 +19,28 @@ type ViewerData struct {
 	Auth     *UserAuth      `json:"auth"`    // Read Only - not enforced for perf reasons
 	Chatter  *Chatter       `json:"chatter"` // Read Only - not enforced for perf reasons
 	Follower *ChannelFollow `json:"follow"`  // Read Only - not enforced for perf reasons
+
+	PrevNicks []NickChange    `json:"prev_nicks,omitempty"` // Oldest first
+	Points    *ViewerPoints   `json:"points,omitempty"`     // Read Only - not enforced for perf reasons
+	Activity  *ViewerActivity `json:"activity,omitempty"`   // Read Only - not enforced for perf reasons
+	Notes     []ViewerNote    `json:"notes,omitempty"`      // Oldest first
 }
 
 // Viewer is basic Viewer
 type Viewer struct {
-	data   ViewerData
-	mylock sync.Mutex
-	client *Client
+	lastUsed int64 // UnixNano, first for atomic alignment
+
+	data    ViewerData
+	mylock  sync.Mutex
+	client  *Client
+	nicks   *nickIndex
+	changes *viewerChanges // Told on every unlock when there is a database
 }
 
 // GetData - Returns the Viewer Data
 func (vw *Viewer) GetData() ViewerData {
-	vw.Lockme()
-	defer vw.Unlockme()
+	vw.mylock.Lock() // Not Lockme, reading is not a change
+	defer vw.mylock.Unlock()
 	return vw.data
 }
 
@@ -53,7 +62,12 @@ func (vw *Viewer) Lockme() {
 
 // Unlockme - Unlock the Viewer
 func (vw *Viewer) Unlockme() {
+	changes := vw.changes
+	tid := vw.data.TwitchID
 	vw.mylock.Unlock()
+	if changes != nil {
+		changes.mark(tid, vw)
+	}
 	if debugViewerLock {
 		fmt.Println("- UNLOCK -", vw.data.TwitchID)
 		debug.PrintStack()
@@ -63,7 +77,7 @@ func (vw *Viewer) Unlockme() {
 //SetUser - Sets the new value in with lock
 func (vw *Viewer) SetUser(newVal User) {
 	vw.Lockme()
-	vw.data.User = &newVal
+	vw.setUserLocked(&newVal)
 	vw.Unlockme()
 }
 
@@ -94,10 +108,16 @@ func (vw *Viewer) CreateChatter() Chatter {
 	defer vw.Unlockme()
 
 	if vw.data.Chatter == nil {
-		log.Printf("Chat: ++Created++ %s", vw.data.User.Name)
+		nick := vw.data.GetNick()
+		displayName := string(nick)
+		if vw.data.User != nil {
+			displayName = vw.data.User.DisplayName
+		}
+
+		log.Printf("Chat: ++Created++ %s", nick)
 		vw.data.Chatter = &Chatter{
-			Nick:        vw.data.User.Name,
-			DisplayName: vw.data.User.DisplayName,
+			Nick:        nick,
+			DisplayName: displayName,
 			Bits:        0,
 
 			Mod:      false,
@@ -132,15 +152,18 @@ func (vData *ViewerData) GetNick() IrcNick {
 }
 
 // UpdateUser - Calls API to update User Data
+// The viewer is not locked during the request so chat is never blocked on the API
 func (vw *Viewer) UpdateUser() error {
-	var err error
-	vw.Lockme()
+	tid := vw.GetData().TwitchID
 
-	vw.data.User, err = vw.client.User.Get(vw.data.TwitchID)
+	u, err := vw.client.User.Get(tid)
 	if err != nil {
-		log.Printf("Failed to Get User Data for %s - %s", vw.data.TwitchID, err)
+		log.Printf("Failed to Get User Data for %s - %s", tid, err)
+		return nil
 	}
 
+	vw.Lockme()
+	vw.setUserLocked(u)
 	vw.Unlockme()
 
 	return nil
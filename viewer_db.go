@@ -0,0 +1,658 @@
+package twitch
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	viewerDBPattern      = "viewers_%s.db"
+	viewerDBMagic        = "TWVDB1\n"
+	viewerDBHeaderSize   = 8                      // Length and CRC32 of each record
+	viewerDBMaxRecord    = 1 << 24                // Anything bigger is corruption
+	viewerDBCompactMin   = 1 << 20                // Bytes of log before compaction is worth it
+	viewerDBCompactRatio = 2                      // Compact once the log is this many times the live records
+	viewerDBSyncDelay    = time.Millisecond * 250 // Gather a burst of changes into one write each
+	viewerDBRetryMin     = time.Second            // Wait after a failed flush, doubled each time it fails again
+	viewerDBRetryMax     = time.Minute * 5
+)
+
+// viewerDBRecord - One upsert or delete in the log
+type viewerDBRecord struct {
+	ID      ID          `json:"id"`
+	At      time.Time   `json:"at"`
+	Deleted bool        `json:"deleted,omitempty"`
+	Data    *ViewerData `json:"data,omitempty"` // Auth is sealed
+}
+
+type viewerDBEntry struct {
+	offset int64
+	length int64 // Including the header
+}
+
+// ViewerDB - Embedded append only store of viewers keyed by ID
+// Every change is a new record so a crash loses nothing already written, a torn final record is dropped on open
+// and a damaged record in the middle is skipped
+type ViewerDB struct {
+	lock     sync.RWMutex
+	filename string
+	dataDir  string
+	f        *os.File
+	size     int64
+	live     int64
+	index    map[ID]viewerDBEntry
+}
+
+// OpenViewerDB - Open or create the store, secrets are sealed with the key of the folder it is in
+func OpenViewerDB(filename string) (*ViewerDB, error) {
+	db := &ViewerDB{
+		filename: filename,
+		dataDir:  filepath.Dir(filename),
+	}
+
+	err := db.open()
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (db *ViewerDB) open() error {
+	f, err := os.OpenFile(db.filename, os.O_RDWR|os.O_CREATE, secretFileMode)
+	if err != nil {
+		return err
+	}
+
+	db.f = f
+	db.index = make(map[ID]viewerDBEntry)
+	db.live = 0
+
+	err = db.rebuildIndex()
+	if err != nil {
+		f.Close()
+		return err
+	}
+	return nil
+}
+
+// rebuildIndex - Scan the log keeping the last record of each ID
+func (db *ViewerDB) rebuildIndex() error {
+	info, err := db.f.Stat()
+	if err != nil {
+		return err
+	}
+
+	if info.Size() == 0 {
+		_, err = db.f.WriteAt([]byte(viewerDBMagic), 0)
+		db.size = int64(len(viewerDBMagic))
+		return err
+	}
+
+	magic := make([]byte, len(viewerDBMagic))
+	if _, err = db.f.ReadAt(magic, 0); err != nil || string(magic) != viewerDBMagic {
+		return fmt.Errorf("Not a viewer database: %s", db.filename)
+	}
+
+	offset := int64(len(viewerDBMagic))
+	for offset < info.Size() {
+		rec, length, err := db.readRecord(offset)
+		if err == io.ErrUnexpectedEOF {
+			// Crash part way through the final append
+			log.Printf("Viewer database %s has a torn record at %d of %d, dropping it", db.filename, offset, info.Size())
+			if err = db.f.Truncate(offset); err != nil {
+				return err
+			}
+			break
+		}
+		if err != nil && length == 0 {
+			return fmt.Errorf("Viewer database %s damaged at %d of %d, restore it from a dump or move it aside: %s", db.filename, offset, info.Size(), err)
+		}
+		if err != nil {
+			log.Printf("Viewer database %s skipping damaged record at %d of %d bytes, that viewer may be out of date: %s", db.filename, offset, length, err)
+			offset += length
+			continue
+		}
+
+		if old, ok := db.index[rec.ID]; ok {
+			db.live -= old.length
+		}
+		if rec.Deleted {
+			delete(db.index, rec.ID)
+		} else {
+			db.index[rec.ID] = viewerDBEntry{offset: offset, length: length}
+			db.live += length
+		}
+
+		offset += length
+	}
+
+	db.size = offset
+	return nil
+}
+
+// readRecord - Record at offset, damaged records whose length can be trusted still return it so they can be skipped
+// A record cut short by the end of the file gives io.ErrUnexpectedEOF
+func (db *ViewerDB) readRecord(offset int64) (*viewerDBRecord, int64, error) {
+	header := make([]byte, viewerDBHeaderSize)
+	if _, err := db.f.ReadAt(header, offset); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > viewerDBMaxRecord {
+		return nil, 0, fmt.Errorf("Record length %d too large", length)
+	}
+
+	payload := make([]byte, length)
+	if _, err := db.f.ReadAt(payload, offset+viewerDBHeaderSize); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, 0, err
+	}
+
+	total := int64(length) + viewerDBHeaderSize
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, total, fmt.Errorf("Checksum mismatch")
+	}
+
+	rec := viewerDBRecord{}
+	if err := json.Unmarshal(payload, &rec); err != nil {
+		return nil, total, err
+	}
+
+	return &rec, total, nil
+}
+
+func encodeViewerDBRecord(rec viewerDBRecord) ([]byte, error) {
+	payload, err := json.Marshal(rec)
+	if err != nil {
+		return nil, err
+	}
+
+	b := make([]byte, viewerDBHeaderSize, viewerDBHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(b[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(b[4:8], crc32.ChecksumIEEE(payload))
+	return append(b, payload...), nil
+}
+
+// sealRecord - Record for vd with the auth sealed
+func (db *ViewerDB) sealRecord(vd ViewerData) (viewerDBRecord, error) {
+	if vd.Auth != nil {
+		sb, err := secretBox(db.dataDir)
+		if err != nil {
+			return viewerDBRecord{}, err
+		}
+
+		vd.Auth, err = sb.sealAuth(vd.Auth)
+		if err != nil {
+			return viewerDBRecord{}, err
+		}
+	}
+
+	return viewerDBRecord{ID: vd.TwitchID, At: time.Now(), Data: &vd}, nil
+}
+
+func (db *ViewerDB) openRecord(rec *viewerDBRecord) (*ViewerData, error) {
+	vd := *rec.Data
+	if vd.Auth == nil {
+		return &vd, nil
+	}
+
+	sb, err := secretBox(db.dataDir)
+	if err != nil {
+		return nil, err
+	}
+
+	vd.Auth, err = sb.openAuth(vd.Auth)
+	if err != nil {
+		return nil, err
+	}
+	return &vd, nil
+}
+
+// appendLocked - Write a record at the end, must hold the write lock
+func (db *ViewerDB) appendLocked(rec viewerDBRecord) error {
+	b, err := encodeViewerDBRecord(rec)
+	if err != nil {
+		return err
+	}
+
+	_, err = db.f.WriteAt(b, db.size)
+	if err != nil {
+		return err
+	}
+
+	if old, ok := db.index[rec.ID]; ok {
+		db.live -= old.length
+	}
+	if rec.Deleted {
+		delete(db.index, rec.ID)
+	} else {
+		db.index[rec.ID] = viewerDBEntry{offset: db.size, length: int64(len(b))}
+		db.live += int64(len(b))
+	}
+
+	db.size += int64(len(b))
+	return nil
+}
+
+// Save - Insert or replace the viewer
+func (db *ViewerDB) Save(vd ViewerData) error {
+	rec, err := db.sealRecord(vd)
+	if err != nil {
+		return err
+	}
+
+	db.lock.Lock()
+	defer db.lock.Unlock()
+	return db.appendLocked(rec)
+}
+
+// Delete - Forget the viewer
+func (db *ViewerDB) Delete(tid ID) error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	if _, ok := db.index[tid]; !ok {
+		return nil
+	}
+	return db.appendLocked(viewerDBRecord{ID: tid, At: time.Now(), Deleted: true})
+}
+
+// Load - Viewer by ID, nil if unknown
+func (db *ViewerDB) Load(tid ID) (*ViewerData, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	entry, ok := db.index[tid]
+	if !ok {
+		return nil, nil
+	}
+
+	rec, _, err := db.readRecord(entry.offset)
+	if err != nil {
+		return nil, err
+	}
+	return db.openRecord(rec)
+}
+
+// Keys - Every viewer stored
+func (db *ViewerDB) Keys() ([]ID, error) {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	keys := make([]ID, 0, len(db.index))
+	for k := range db.index {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+// Len - Number of viewers stored
+func (db *ViewerDB) Len() int {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return len(db.index)
+}
+
+// ForEach - Every viewer in file order, holds a read lock so writes wait until it returns
+func (db *ViewerDB) ForEach(fn func(ViewerData) bool) error {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+
+	entries := make([]viewerDBEntry, 0, len(db.index))
+	for _, e := range db.index {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].offset < entries[j].offset })
+
+	for _, e := range entries {
+		rec, _, err := db.readRecord(e.offset)
+		if err != nil {
+			return err
+		}
+
+		vd, err := db.openRecord(rec)
+		if err != nil {
+			log.Printf("Unable to open auth for %s: %s", rec.ID, err)
+			vd = rec.Data
+			vd.Auth = nil
+		}
+
+		if !fn(*vd) {
+			return nil
+		}
+	}
+
+	return nil
+}
+
+// Snapshot - Every viewer as of now in the same form as a loaded dump
+func (db *ViewerDB) Snapshot(room IrcNick, roomID ID) (*HistoricViewerData, error) {
+	hvd := HistoricViewerData{
+		Name:       room,
+		Timestamp:  time.Now(),
+		RoomID:     roomID,
+		ViewerData: make(map[ID]ViewerData),
+	}
+
+	err := db.ForEach(func(vd ViewerData) bool {
+		hvd.ViewerData[vd.TwitchID] = vd
+		return true
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &hvd, nil
+}
+
+// ImportDump - Upsert every viewer from a gob dump file, returns how many
+func (db *ViewerDB) ImportDump(filename string) (int, error) {
+	_, vdMap, err := readViewerDump(filename)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, vd := range vdMap {
+		err = db.Save(vd)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	log.Printf("Imported %d viewers from %s", len(vdMap), filename)
+	return len(vdMap), nil
+}
+
+// needsCompact - Is most of the log superseded records
+func (db *ViewerDB) needsCompact() bool {
+	db.lock.RLock()
+	defer db.lock.RUnlock()
+	return db.size > viewerDBCompactMin && db.size > db.live*viewerDBCompactRatio
+}
+
+// Compact - Rewrite only the live records, resealing auth with the current key
+func (db *ViewerDB) Compact() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	tmpName := db.filename + ".tmp"
+	tmp, err := os.OpenFile(tmpName, os.O_RDWR|os.O_CREATE|os.O_TRUNC, secretFileMode)
+	if err != nil {
+		return err
+	}
+
+	entries := make([]viewerDBEntry, 0, len(db.index))
+	for _, e := range db.index {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].offset < entries[j].offset })
+
+	fail := func(err error) error {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+
+	if _, err = tmp.Write([]byte(viewerDBMagic)); err != nil {
+		return fail(err)
+	}
+
+	for _, e := range entries {
+		rec, _, err := db.readRecord(e.offset)
+		if err != nil {
+			return fail(err)
+		}
+
+		vd, err := db.openRecord(rec)
+		if err != nil {
+			return fail(err)
+		}
+
+		newRec, err := db.sealRecord(*vd)
+		if err != nil {
+			return fail(err)
+		}
+		newRec.At = rec.At
+
+		b, err := encodeViewerDBRecord(newRec)
+		if err != nil {
+			return fail(err)
+		}
+		if _, err = tmp.Write(b); err != nil {
+			return fail(err)
+		}
+	}
+
+	if err = tmp.Sync(); err != nil {
+		return fail(err)
+	}
+	tmp.Close()
+
+	oldSize := db.size
+	db.f.Close()
+	if err = os.Rename(tmpName, db.filename); err != nil {
+		os.Remove(tmpName)
+	}
+
+	openErr := db.open()
+	if err == nil {
+		err = openErr
+	}
+	if err == nil {
+		log.Printf("Compacted %s from %d to %d bytes", db.filename, oldSize, db.size)
+	}
+	return err
+}
+
+// Close - Close the file
+func (db *ViewerDB) Close() error {
+	db.lock.Lock()
+	defer db.lock.Unlock()
+
+	err := db.f.Sync()
+	if closeErr := db.f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// viewerChanges - Viewers changed since they were last written, saved behind so callers never wait on disk
+type viewerChanges struct {
+	lock  sync.Mutex
+	dirty map[ID]*Viewer
+	wake  chan struct{}
+
+	retry time.Duration // Zero until a flush fails
+}
+
+func createViewerChanges() *viewerChanges {
+	return &viewerChanges{
+		dirty: make(map[ID]*Viewer),
+		wake:  make(chan struct{}, 1),
+	}
+}
+
+func (vc *viewerChanges) mark(tid ID, v *Viewer) {
+	vc.lock.Lock()
+	vc.dirty[tid] = v
+	vc.lock.Unlock()
+
+	select {
+	case vc.wake <- struct{}{}:
+	default:
+	}
+}
+
+func (vc *viewerChanges) take() map[ID]*Viewer {
+	vc.lock.Lock()
+	defer vc.lock.Unlock()
+
+	dirty := vc.dirty
+	vc.dirty = make(map[ID]*Viewer)
+	return dirty
+}
+
+// failed - Put back viewers which did not save without waking the writer, returns how long to wait
+func (vc *viewerChanges) failed(failList map[ID]*Viewer) time.Duration {
+	vc.lock.Lock()
+	defer vc.lock.Unlock()
+
+	for tid, v := range failList {
+		if _, ok := vc.dirty[tid]; !ok {
+			vc.dirty[tid] = v
+		}
+	}
+
+	if vc.retry == 0 {
+		vc.retry = viewerDBRetryMin
+	} else if vc.retry *= 2; vc.retry > viewerDBRetryMax {
+		vc.retry = viewerDBRetryMax
+	}
+	return vc.retry
+}
+
+// saved - Flush worked, true if it had been failing
+func (vc *viewerChanges) saved() bool {
+	vc.lock.Lock()
+	defer vc.lock.Unlock()
+
+	wasFailing := vc.retry > 0
+	vc.retry = 0
+	return wasFailing
+}
+
+func (vc *viewerChanges) retryDelay() time.Duration {
+	vc.lock.Lock()
+	defer vc.lock.Unlock()
+	return vc.retry
+}
+
+// attachDB - Persist every change to db and use it as the disk tier, call before any viewers are added
+// Nicks and follows are indexed from the db so lookups work without loading every viewer
+func (vm *ViewerMethod) attachDB(db *ViewerDB, limit int) error {
+	err := db.ForEach(func(vd ViewerData) bool {
+		if vd.User != nil {
+			vm.nicks.update(vd.TwitchID, "", vd.User.Name)
+		}
+		if vd.Follower != nil {
+			vm.followerCache.set(vd.TwitchID, ChannelRelationship(*vd.Follower).CreatedAt())
+		}
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	vm.db = db
+	vm.changes = createViewerChanges()
+	vm.attachDisk(db, limit)
+
+	go vm.writeBehind()
+	return nil
+}
+
+func (vm *ViewerMethod) writeBehind() {
+	for range vm.changes.wake {
+		time.Sleep(viewerDBSyncDelay)
+		if vm.FlushChanges() == nil {
+			continue
+		}
+
+		// Failed viewers are not marked again so a full disk is retried with backoff rather than every tick
+		time.Sleep(vm.changes.retryDelay())
+		select {
+		case vm.changes.wake <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// FlushChanges - Write every changed viewer to the database now
+func (vm *ViewerMethod) FlushChanges() error {
+	if vm.db == nil {
+		return nil
+	}
+
+	var firstErr error
+	failList := make(map[ID]*Viewer)
+	for tid, v := range vm.changes.take() {
+		// Evicted viewers were saved on the way out, a reload is a different pointer
+		vm.viewers.saveIf(tid, v, func() error {
+			err := vm.db.Save(v.GetData())
+			if err != nil {
+				failList[tid] = v
+				if firstErr == nil {
+					firstErr = err
+				}
+			}
+			return err
+		})
+	}
+
+	if firstErr != nil {
+		retry := vm.changes.failed(failList)
+		vm.client.logf("Unable to save %d viewers, retrying in %s: %s", len(failList), retry, firstErr)
+		return firstErr
+	}
+
+	if vm.changes.saved() {
+		vm.client.logf("Viewer saves working again")
+	}
+	return nil
+}
+
+// maintainDB - Flush changes and compact once most of the log is stale
+func (vm *ViewerMethod) maintainDB() error {
+	if vm.db == nil {
+		return nil
+	}
+
+	err := vm.FlushChanges()
+	if err != nil {
+		return err
+	}
+
+	if vm.db.needsCompact() {
+		return vm.db.Compact()
+	}
+	return nil
+}
+
+// openViewerDB - Open the room database, importing the newest dump the first time
+func (ah *Client) openViewerDB() error {
+	db, err := OpenViewerDB(ah.Config.dataPath(viewerDBPattern, ah.RoomName))
+	if err != nil {
+		return err
+	}
+
+	if db.Len() == 0 {
+		if dumpFile := mostRecentDumpFile(ah.Config.DataDir, ah.RoomName); dumpFile != "" {
+			_, err = db.ImportDump(dumpFile)
+		}
+	}
+
+	if err == nil {
+		err = ah.Viewers.attachDB(db, ah.Config.ViewerCacheSize)
+	}
+	if err != nil {
+		db.Close()
+		return err
+	}
+
+	ah.logf("Viewer database %s has %d viewers", db.filename, db.Len())
+	return nil
+}
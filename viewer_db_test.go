@@ -0,0 +1,218 @@
+package twitch
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestViewerDB(t *testing.T) {
+	dir, err := ioutil.TempDir("", "viewer_db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "viewers_test.db")
+
+	db, err := OpenViewerDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 100; i++ {
+		err = db.Save(ViewerData{TwitchID: ID(fmt.Sprint(i)), User: &User{ID: ID(fmt.Sprint(i)), Name: IrcNick(fmt.Sprintf("db%d", i))}})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	db.Save(ViewerData{TwitchID: "5", User: &User{ID: "5", Name: "renamed"}, Auth: &UserAuth{AuthCode: "code5"}})
+	db.Delete("7")
+	db.Close()
+
+	// Crash mid write leaves a torn record
+	f, _ := os.OpenFile(filename, os.O_WRONLY|os.O_APPEND, 0600)
+	f.Write([]byte{0, 0, 1, 0, 9, 9})
+	f.Close()
+
+	db, err = OpenViewerDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Len() != 99 {
+		t.Errorf("Expected 99 viewers after reopen not %d", db.Len())
+	}
+	if vd, _ := db.Load("7"); vd != nil {
+		t.Errorf("Deleted viewer came back")
+	}
+	vd, err := db.Load("5")
+	if err != nil || vd == nil || vd.User.Name != "renamed" || vd.Auth == nil || vd.Auth.AuthCode != "code5" {
+		t.Errorf("Upsert lost: %+v %s", vd, err)
+	}
+
+	sizeBefore := db.size
+	if err = db.Compact(); err != nil {
+		t.Fatal(err)
+	}
+	if db.size >= sizeBefore || db.Len() != 99 {
+		t.Errorf("Compact went from %d to %d bytes with %d viewers", sizeBefore, db.size, db.Len())
+	}
+	if vd, _ = db.Load("5"); vd == nil || vd.Auth == nil || vd.Auth.AuthCode != "code5" {
+		t.Errorf("Compact lost auth: %+v", vd)
+	}
+
+	// Old dumps import
+	dumpFile := filepath.Join(dir, "dump_test.bin")
+	f, _ = os.Create(dumpFile)
+	enc := gob.NewEncoder(f)
+	enc.Encode(ViewerData{TwitchID: "500", User: &User{ID: "500", Name: "fromdump"}})
+	enc.Encode(ViewerData{TwitchID: "5", User: &User{ID: "5", Name: "dumpname"}})
+	f.Close()
+
+	num, err := db.ImportDump(dumpFile)
+	if err != nil || num != 2 || db.Len() != 100 {
+		t.Errorf("Import gave %d with %d stored: %s", num, db.Len(), err)
+	}
+
+	hvd, err := db.Snapshot("test", "1")
+	if err != nil || len(hvd.ViewerData) != 100 || hvd.ViewerData["500"].User.Name != "fromdump" {
+		t.Errorf("Snapshot wrong: %s", err)
+	}
+
+	// Changes through viewers are written behind
+	vm := CreateViewerMethod(&Client{})
+	if err = vm.attachDB(db, 0); err != nil {
+		t.Fatal(err)
+	}
+	if v, err := vm.Find("DB42"); err != nil || v.GetData().TwitchID != "42" {
+		t.Errorf("Nick index not built from the database: %s", err)
+	}
+
+	v := vm.GetFromUser(User{ID: "900", Name: "newbie"})
+	vm.UpdateFollowers([]ChannelFollow{{User: &User{ID: "900", Name: "newbie"}, CreatedAtString: "2017-01-02T03:04:05Z"}})
+	v.SetAuth(UserAuth{AuthCode: "code900"})
+
+	time.Sleep(viewerDBSyncDelay * 3)
+	if vd, _ = db.Load("900"); vd == nil || vd.Auth == nil || vd.Follower == nil {
+		t.Errorf("Change not written behind: %+v", vd)
+	}
+	db.Close()
+
+	db, err = OpenViewerDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+
+	vm = CreateViewerMethod(&Client{})
+	vm.attachDB(db, 0)
+	if ok, _ := vm.IsFollower("900"); !ok {
+		t.Errorf("Follow not restored from the database")
+	}
+	if v := vm.GetPtr("900"); v == nil || v.GetData().Auth.AuthCode != "code900" {
+		t.Errorf("Viewer not reloaded from the database")
+	}
+}
+
+func TestViewerDBDamage(t *testing.T) {
+	dir, err := ioutil.TempDir("", "viewer_db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	filename := filepath.Join(dir, "viewers_test.db")
+
+	db, err := OpenViewerDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		db.Save(ViewerData{TwitchID: ID(fmt.Sprint(i)), User: &User{ID: ID(fmt.Sprint(i)), Name: IrcNick(fmt.Sprintf("db%d", i))}})
+	}
+	middle := db.index["1"]
+	db.Close()
+
+	// Bad payload in the middle skips that record only
+	f, _ := os.OpenFile(filename, os.O_RDWR, 0600)
+	f.WriteAt([]byte("X"), middle.offset+viewerDBHeaderSize+2)
+	f.Close()
+
+	db, err = OpenViewerDB(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if db.Len() != 2 {
+		t.Errorf("Expected 2 viewers around the damaged record not %d", db.Len())
+	}
+	if vd, _ := db.Load("2"); vd == nil {
+		t.Errorf("Record after the damage was dropped")
+	}
+	size := db.size
+	db.Close()
+
+	if fi, _ := os.Stat(filename); fi.Size() != size {
+		t.Errorf("File truncated to %d from %d", fi.Size(), size)
+	}
+
+	// A length that cannot be trusted refuses to open rather than lose the rest
+	f, _ = os.OpenFile(filename, os.O_RDWR, 0600)
+	f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, middle.offset)
+	f.Close()
+
+	if db, err = OpenViewerDB(filename); err == nil {
+		db.Close()
+		t.Errorf("Opened a database with a corrupt record length")
+	}
+	if fi, _ := os.Stat(filename); fi.Size() != size {
+		t.Errorf("Refused database was changed")
+	}
+}
+
+func TestViewerDBSaveBackoff(t *testing.T) {
+	dir, err := ioutil.TempDir("", "viewer_db")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	db, err := OpenViewerDB(filepath.Join(dir, "viewers_test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// No writer running so the wake channel shows whether a failure asks for an instant retry
+	vm := CreateViewerMethod(&Client{})
+	vm.db = db
+	vm.changes = createViewerChanges()
+	vm.GetFromUser(User{ID: "7", Name: "ronni"})
+	<-vm.changes.wake
+
+	// Every write fails like a full disk
+	db.Close()
+	for i, want := range []time.Duration{viewerDBRetryMin, viewerDBRetryMin * 2, viewerDBRetryMin * 4} {
+		if err = vm.FlushChanges(); err == nil {
+			t.Fatalf("Flush %d to a closed database worked", i)
+		}
+		if got := vm.changes.retryDelay(); got != want {
+			t.Errorf("Retry %d after %s expected %s", i, got, want)
+		}
+	}
+	if len(vm.changes.wake) != 0 {
+		t.Errorf("Failed save woke the writer straight away")
+	}
+
+	vm.db, err = OpenViewerDB(filepath.Join(dir, "viewers_test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer vm.db.Close()
+	if err = vm.FlushChanges(); err != nil || vm.changes.retryDelay() != 0 {
+		t.Errorf("Flush after recovery: %s", err)
+	}
+	if vd, _ := vm.db.Load("7"); vd == nil {
+		t.Errorf("Viewer kept after failures was not saved")
+	}
+}
@@ -0,0 +1,291 @@
+package twitch
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const (
+	dumpFormatVersion = 2
+	dumpMagic         = "TWITCHDUMP\n" // Version 1 dumps were a bare gob stream of ViewerData
+
+	// DefaultDumpKeep - Most recent dumps kept per room
+	DefaultDumpKeep = 48
+	// DefaultDumpMaxAge - Dumps older than this are removed, the newest is always kept
+	DefaultDumpMaxAge = time.Hour * 24 * 14
+)
+
+// dumpHeader - First entry of a dump
+type dumpHeader struct {
+	Version int
+	Room    IrcNick
+	RoomID  ID
+	Created time.Time
+}
+
+// dumpEntry - A viewer or, last of all, the footer
+type dumpEntry struct {
+	Viewer *ViewerData
+	End    *dumpFooter
+}
+
+// dumpFooter - Proves the dump was written in full
+type dumpFooter struct {
+	Count    int
+	Checksum uint32 // CRC32 of everything after the magic and before this entry
+}
+
+// dumpMigrations - Entry i upgrades a viewer from format i+1 to i+2
+// When ViewerData changes in a way gob cannot follow bump dumpFormatVersion and add the fix here
+var dumpMigrations = []func(*ViewerData) error{
+	nil, // 1 -> 2 only changed the file layout
+}
+
+func migrateDumpViewer(version int, vd *ViewerData) error {
+	for v := version; v < dumpFormatVersion; v++ {
+		if fix := dumpMigrations[v-1]; fix != nil {
+			if err := fix(vd); err != nil {
+				return fmt.Errorf("Migrating %s from dump format %d: %s", vd.TwitchID, v, err)
+			}
+		}
+	}
+	return nil
+}
+
+// crcReader - Checksums what gob consumes, being a ByteReader stops gob reading ahead
+type crcReader struct {
+	r   *bufio.Reader
+	crc hash.Hash32
+}
+
+func (cr *crcReader) Read(p []byte) (int, error) {
+	n, err := cr.r.Read(p)
+	cr.crc.Write(p[:n])
+	return n, err
+}
+
+func (cr *crcReader) ReadByte() (byte, error) {
+	b, err := cr.r.ReadByte()
+	if err == nil {
+		cr.crc.Write([]byte{b})
+	}
+	return b, err
+}
+
+// writeViewerDump - Write every viewer from each to a temp file and rename it over filename
+func writeViewerDump(filename string, hdr dumpHeader, sb *SecretBox, each func(func(ViewerData) bool) error) error {
+	tmpName := filename + ".tmp"
+	f, err := os.OpenFile(tmpName, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, secretFileMode)
+	if err != nil {
+		return err
+	}
+	fail := func(err error) error {
+		f.Close()
+		os.Remove(tmpName)
+		return err
+	}
+
+	bw := bufio.NewWriter(f)
+	if _, err = bw.WriteString(dumpMagic); err != nil {
+		return fail(err)
+	}
+
+	crc := crc32.NewIEEE()
+	enc := gob.NewEncoder(io.MultiWriter(bw, crc))
+	if err = enc.Encode(hdr); err != nil {
+		return fail(err)
+	}
+
+	count := 0
+	eachErr := each(func(vd ViewerData) bool {
+		if vd.Auth != nil {
+			vd.Auth, err = sb.sealAuth(vd.Auth)
+			if err != nil {
+				return false
+			}
+		}
+		err = enc.Encode(dumpEntry{Viewer: &vd})
+		count++
+		return err == nil
+	})
+	if err == nil {
+		err = eachErr
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	err = enc.Encode(dumpEntry{End: &dumpFooter{Count: count, Checksum: crc.Sum32()}})
+	if err == nil {
+		err = bw.Flush()
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err != nil {
+		return fail(err)
+	}
+
+	f.Close()
+	return os.Rename(tmpName, filename)
+}
+
+// readViewerDump - Header and every viewer in a dump with auth opened and migrated to the current format
+func readViewerDump(filename string) (*dumpHeader, map[ID]ViewerData, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer f.Close()
+
+	sb, err := secretBox(filepath.Dir(filename))
+	if err != nil {
+		return nil, nil, err
+	}
+
+	br := bufio.NewReader(f)
+	vdMap := make(map[ID]ViewerData)
+	add := func(version int, v ViewerData) error {
+		v.Auth, err = sb.openAuth(v.Auth)
+		if err != nil {
+			log.Printf("Unable to open auth for %s: %s", v.TwitchID, err)
+			v.Auth = nil
+		}
+		if err := migrateDumpViewer(version, &v); err != nil {
+			return err
+		}
+		vdMap[v.TwitchID] = v
+		return nil
+	}
+
+	magic, _ := br.Peek(len(dumpMagic))
+	if string(magic) != dumpMagic {
+		hdr := dumpHeader{Version: 1}
+		dec := gob.NewDecoder(br)
+		for {
+			v := ViewerData{}
+			err := dec.Decode(&v)
+			if err == io.EOF {
+				return &hdr, vdMap, nil
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			if err = add(hdr.Version, v); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	br.Discard(len(dumpMagic))
+
+	cr := &crcReader{r: br, crc: crc32.NewIEEE()}
+	dec := gob.NewDecoder(cr)
+
+	hdr := dumpHeader{}
+	if err = dec.Decode(&hdr); err != nil {
+		return nil, nil, err
+	}
+	if hdr.Version > dumpFormatVersion {
+		return nil, nil, fmt.Errorf("Dump %s is format %d, this build reads up to %d", filename, hdr.Version, dumpFormatVersion)
+	}
+
+	count := 0
+	for {
+		sum := cr.crc.Sum32()
+		entry := dumpEntry{}
+		err := dec.Decode(&entry)
+		if err == io.EOF {
+			return nil, nil, fmt.Errorf("Dump %s is truncated after %d viewers", filename, count)
+		}
+		if err != nil {
+			return nil, nil, err
+		}
+
+		if entry.End != nil {
+			if entry.End.Count != count || entry.End.Checksum != sum {
+				return nil, nil, fmt.Errorf("Dump %s failed its checksum", filename)
+			}
+			return &hdr, vdMap, nil
+		}
+
+		if entry.Viewer != nil {
+			if err = add(hdr.Version, *entry.Viewer); err != nil {
+				return nil, nil, err
+			}
+			count++
+		}
+	}
+}
+
+// mostRecentDumpFile - Newest timestamped dump for the room or the old untimestamped one, empty if neither
+func mostRecentDumpFile(dataDir string, chanName IrcNick) string {
+	var bigNum int64
+
+	for _, item := range getDumpListing(dataDir, chanName) {
+		v, err := strconv.ParseInt(item[2], 10, 64)
+		if err != nil {
+			log.Printf("Cannot Convert: %s", err)
+			continue
+		}
+
+		if v > bigNum {
+			bigNum = v
+		}
+	}
+
+	if bigNum > 0 {
+		return filepath.Join(dataDir, fmt.Sprintf(dumpFilePattern, chanName, bigNum))
+	}
+
+	legacy := filepath.Join(dataDir, fmt.Sprintf(legacyDumpFilePattern, chanName))
+	if _, err := os.Stat(legacy); err == nil {
+		return legacy
+	}
+	return ""
+}
+
+// pruneViewerDumps - Remove all but the newest keep dumps and any older than maxAge, the newest always stays
+func pruneViewerDumps(dataDir string, chanName IrcNick, keep int, maxAge time.Duration) (int, error) {
+	listing := getDumpListing(dataDir, chanName)
+	stamps := make([]int64, 0, len(listing))
+	names := make(map[int64]string)
+	for _, item := range listing {
+		v, err := strconv.ParseInt(item[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		stamps = append(stamps, v)
+		names[v] = item[0]
+	}
+	sort.Slice(stamps, func(i, j int) bool { return stamps[i] > stamps[j] })
+
+	cutoff := time.Now().Add(-maxAge).Unix()
+	num := 0
+	for i, ts := range stamps {
+		if i == 0 {
+			continue
+		}
+		if (keep > 0 && i >= keep) || (maxAge > 0 && ts < cutoff) {
+			err := os.Remove(filepath.Join(dataDir, names[ts]))
+			if err != nil {
+				return num, err
+			}
+			num++
+		}
+	}
+
+	if num > 0 {
+		log.Printf("Pruned %d old dumps of %s", num, chanName)
+	}
+	return num, nil
+}
@@ -0,0 +1,96 @@
+package twitch
+
+import (
+	"encoding/gob"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestViewerDumpFormat(t *testing.T) {
+	dir, err := ioutil.TempDir("", "viewer_dump")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	cfg := DefaultConfig()
+	cfg.DataDir = dir
+	cfg.DumpKeep = 3
+
+	ah := &Client{Config: cfg, RoomName: "kimau", RoomID: "1"}
+	ah.Viewers = CreateViewerMethod(ah)
+	for i := 0; i < 10; i++ {
+		ah.Viewers.Set(ViewerData{TwitchID: ID(fmt.Sprint(i)), User: &User{ID: ID(fmt.Sprint(i)), Name: IrcNick(fmt.Sprintf("dump%d", i))}})
+	}
+	ah.Viewers.GetPtr("4").SetAuth(UserAuth{AuthCode: "code4"})
+
+	// Older dumps to prune
+	sb, _ := secretBox(dir)
+	old := time.Now().Add(-time.Hour)
+	for i := 0; i < 5; i++ {
+		created := old.Add(time.Duration(i) * time.Minute)
+		err = writeViewerDump(filepath.Join(dir, fmt.Sprintf(dumpFilePattern, "kimau", created.Unix())),
+			dumpHeader{Version: dumpFormatVersion, Room: "kimau", Created: created}, sb, ah.Viewers.forEachData)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err = ah.DumpViewers(); err != nil {
+		t.Fatal(err)
+	}
+	listing := getDumpListing(dir, "kimau")
+	if len(listing) != 3 {
+		t.Errorf("Expected 3 dumps kept not %d", len(listing))
+	}
+
+	hvd, err := loadMostRecentViewerDump(dir, "kimau")
+	if err != nil || hvd == nil {
+		t.Fatalf("Newest dump did not load: %s", err)
+	}
+	if hvd.RoomID != "1" || len(hvd.ViewerData) != 10 || time.Since(hvd.Timestamp) > time.Minute {
+		t.Errorf("Dump header wrong: %s %d %s", hvd.RoomID, len(hvd.ViewerData), hvd.Timestamp)
+	}
+	if a := hvd.ViewerData["4"].Auth; a == nil || a.AuthCode != "code4" {
+		t.Errorf("Auth not restored: %+v", a)
+	}
+
+	newest := mostRecentDumpFile(dir, "kimau")
+	raw, _ := ioutil.ReadFile(newest)
+	if strings.Contains(string(raw), "code4") {
+		t.Errorf("Auth dumped in the clear")
+	}
+	if _, err = os.Stat(newest + ".tmp"); !os.IsNotExist(err) {
+		t.Errorf("Temp file left behind")
+	}
+
+	// Damage is caught
+	flipped := append([]byte{}, raw...)
+	flipped[len(flipped)/2] ^= 0xff
+	ioutil.WriteFile(filepath.Join(dir, "dump_bad_1.bin"), flipped, 0600)
+	if _, _, err = readViewerDump(filepath.Join(dir, "dump_bad_1.bin")); err == nil {
+		t.Errorf("Corrupt dump loaded")
+	}
+	ioutil.WriteFile(filepath.Join(dir, "dump_bad_2.bin"), raw[:len(raw)-20], 0600)
+	if _, _, err = readViewerDump(filepath.Join(dir, "dump_bad_2.bin")); err == nil {
+		t.Errorf("Truncated dump loaded")
+	}
+
+	// Dumps from before the header still load
+	legacyDir := filepath.Join(dir, "legacy")
+	os.Mkdir(legacyDir, 0700)
+	f, _ := os.Create(filepath.Join(legacyDir, fmt.Sprintf(legacyDumpFilePattern, "kimau")))
+	enc := gob.NewEncoder(f)
+	enc.Encode(ViewerData{TwitchID: "77", User: &User{ID: "77", Name: "oldtimer"}})
+	f.Close()
+
+	hvd, err = loadMostRecentViewerDump(legacyDir, "kimau")
+	if err != nil || hvd == nil || hvd.Name != "kimau" || hvd.ViewerData["77"].User.Name != "oldtimer" {
+		t.Errorf("Legacy dump did not load: %+v %s", hvd, err)
+	}
+}
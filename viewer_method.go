@@ -2,20 +2,25 @@ package twitch
 
 import (
 	"fmt"
-	"log"
 	"math/rand"
-	"strings"
-	"sync"
 	"time"
 )
 
 // ViewerMethod - Contains all the user functions
 type ViewerMethod struct {
+	counters viewerCacheCounters // First for atomic alignment
+
 	client *Client
 
-	mapLock       sync.Mutex
-	viewers       map[ID]*Viewer
-	followerCache map[ID]time.Time
+	viewers       *viewerStore
+	nicks         *nickIndex
+	followerCache *followerSet
+
+	disk       viewerDiskStore // Evicted viewers, nil keeps everyone in memory
+	cacheLimit int
+
+	db      *ViewerDB
+	changes *viewerChanges
 }
 
 // CreateViewerMethod - Create VM with client
@@ -23,8 +28,9 @@ func CreateViewerMethod(c *Client) *ViewerMethod {
 	return &ViewerMethod{
 		client: c,
 
-		viewers:       make(map[ID]*Viewer),
-		followerCache: make(map[ID]time.Time),
+		viewers:       createViewerStore(),
+		nicks:         createNickIndex(),
+		followerCache: createFollowerSet(),
 	}
 }
 
@@ -33,12 +39,23 @@ func (vm *ViewerMethod) Client() *Client {
 	return vm.client
 }
 
-func (vm *ViewerMethod) lockmap() {
-	vm.mapLock.Lock()
+// Count - Number of viewers in memory
+func (vm *ViewerMethod) Count() int {
+	return vm.viewers.count()
+}
+
+// lookup - Known viewer without asking the API, reloads from disk if evicted
+func (vm *ViewerMethod) lookup(tid ID) (*Viewer, bool) {
+	v, ok := vm.load(tid)
+	if ok {
+		v.touch()
+	}
+	return v, ok
 }
 
-func (vm *ViewerMethod) unlockmap() {
-	vm.mapLock.Unlock()
+// forEach - Visit every known viewer without holding the store locks
+func (vm *ViewerMethod) forEach(fn func(*Viewer) bool) {
+	vm.viewers.forEach(func(_ ID, v *Viewer) bool { return fn(v) })
 }
 
 // GetRoomID - Get ID of Room
@@ -55,12 +72,27 @@ func (vm *ViewerMethod) GetRoomName() IrcNick {
 func (vm *ViewerMethod) Set(vd ViewerData) {
 	newV := vm.allocViewer(vd.TwitchID)
 	newV.data = vd
+	newV.touch()
+	if vm.changes != nil {
+		vm.changes.mark(vd.TwitchID, newV)
+	}
+
+	var oldNick IrcNick
+	if old := vm.viewers.swap(vd.TwitchID, newV); old != nil {
+		oldData := old.GetData()
+		oldNick = oldData.GetNick()
+	}
+	if vd.User != nil {
+		vm.nicks.update(vd.TwitchID, oldNick, vd.User.Name)
+	}
 
 	if vd.Follower == nil {
-		delete(vm.followerCache, vd.TwitchID)
+		vm.followerCache.clear(vd.TwitchID)
 	} else {
-		vm.followerCache[vd.TwitchID] = ChannelRelationship(*vd.Follower).CreatedAt()
+		vm.followerCache.set(vd.TwitchID, ChannelRelationship(*vd.Follower).CreatedAt())
 	}
+
+	vm.maybeEvict()
 }
 
 //SetFollower - Sets the new value in with lock
@@ -70,41 +102,46 @@ func (vm *ViewerMethod) SetFollower(newVal ChannelFollow) {
 	v.data.Follower = &newVal
 	v.Unlockme()
 
-	vm.lockmap()
-	vm.followerCache[newVal.User.ID] = ChannelRelationship(newVal).CreatedAt()
-	vm.unlockmap()
+	vm.followerCache.set(newVal.User.ID, ChannelRelationship(newVal).CreatedAt())
 }
 
 //ClearFollower - Sets the new value in with lock
 func (vm *ViewerMethod) ClearFollower(tid ID) {
-	vm.lockmap()
-	defer vm.unlockmap()
-
-	v, ok := vm.viewers[tid]
+	v, ok := vm.lookup(tid)
 	if ok {
 		v.Lockme()
 		v.data.Follower = nil
 		v.Unlockme()
 	}
-	delete(vm.followerCache, tid)
+	vm.followerCache.clear(tid)
 }
 
 // IsFollower - IS the person a follower
 func (vm *ViewerMethod) IsFollower(tid ID) (bool, time.Time) {
-	t, ok := vm.followerCache[tid]
+	t, ok := vm.followerCache.get(tid)
 	return ok, t
 }
 
 // AllKeys - Get All Viewer IDs slower than a direct range over
 func (vm *ViewerMethod) AllKeys() []ID {
-	vm.lockmap()
-	defer vm.unlockmap()
-
-	myKeys := make([]ID, len(vm.viewers))
-	i := 0
-	for k := range vm.viewers {
-		myKeys[i] = k
-		i++
+	myKeys := make([]ID, 0, vm.viewers.count())
+	resident := make(map[ID]bool)
+	vm.viewers.forEach(func(k ID, _ *Viewer) bool {
+		myKeys = append(myKeys, k)
+		resident[k] = true
+		return true
+	})
+
+	if vm.disk != nil {
+		diskKeys, err := vm.disk.Keys()
+		if err != nil {
+			vm.client.logf("Unable to list evicted viewers: %s", err)
+		}
+		for _, k := range diskKeys {
+			if !resident[k] {
+				myKeys = append(myKeys, k)
+			}
+		}
 	}
 
 	return myKeys
@@ -112,16 +149,14 @@ func (vm *ViewerMethod) AllKeys() []ID {
 
 // GetPtr - Get Viewer by ID
 func (vm *ViewerMethod) GetPtr(twitchID ID) *Viewer {
-	vm.lockmap()
-	v, ok := vm.viewers[twitchID]
-	vm.unlockmap()
+	v, ok := vm.lookup(twitchID)
 	if ok {
 		return v
 	}
 
 	u, err := vm.client.User.Get(twitchID)
 	if err != nil {
-		log.Printf("Unable to get User %s\n%s", twitchID, err.Error())
+		vm.client.logf("Unable to get User %s\n%s", twitchID, err.Error())
 		return nil
 	}
 
@@ -137,7 +172,7 @@ func (vm *ViewerMethod) GetData(twitchID ID) (ViewerData, error) {
 	v := vm.GetPtr(twitchID)
 
 	if v != nil {
-		return v.data, nil
+		return v.GetData(), nil
 	}
 
 	return ViewerData{}, fmt.Errorf("Unable to Find Viewer")
@@ -147,24 +182,30 @@ func (vm *ViewerMethod) allocViewer(tid ID) *Viewer {
 	v := new(Viewer)
 	v.data.TwitchID = tid
 	v.client = vm.client
-
-	vm.viewers[tid] = v
+	v.nicks = vm.nicks
+	v.changes = vm.changes
 
 	return v
 }
 
 // GetFromUser - Get Viewer from User
 func (vm *ViewerMethod) GetFromUser(usr User) *Viewer {
-	vm.lockmap()
-	defer vm.unlockmap()
-
-	v, ok := vm.viewers[usr.ID]
+	v, ok := vm.lookup(usr.ID)
+	created := false
+	if !ok {
+		v, created = vm.viewers.getOrAlloc(usr.ID, func() *Viewer {
+			v := vm.allocViewer(usr.ID)
+			v.data.User = &usr
+			return v
+		})
+		v.touch()
+	}
 
-	if ok {
-		v.SetUser(usr)
+	if created {
+		vm.nicks.update(usr.ID, "", usr.Name)
+		vm.maybeEvict()
 	} else {
-		v = vm.allocViewer(usr.ID)
-		v.data.User = &usr
+		v.SetUser(usr)
 	}
 
 	v.CreateChatter()
@@ -173,17 +214,13 @@ func (vm *ViewerMethod) GetFromUser(usr User) *Viewer {
 }
 
 func (vm *ViewerMethod) findViewerByName(nick IrcNick) *Viewer {
-	vm.lockmap()
-	defer vm.unlockmap()
-
-	nick = IrcNick(strings.ToLower(string(nick)))
-	for k := range vm.viewers {
-		v := vm.viewers[k]
-		if v.data.User.Name == nick {
-			return v
-		}
+	tid, ok := vm.nicks.lookup(nick)
+	if !ok {
+		return nil
 	}
-	return nil
+
+	v, _ := vm.lookup(tid)
+	return v
 }
 
 // Find - Find Viewer Method
@@ -231,7 +268,7 @@ func (vm *ViewerMethod) UpdateViewers(nickList []IrcNick) []*Viewer {
 	// Get Full List by Name
 	userList, err := vm.client.User.GetByName(unkownNicks)
 	if err != nil {
-		log.Printf("Error in userList \n---\n%s\n---\n%s",
+		vm.client.logf("Error in userList \n---\n%s\n---\n%s",
 			JoinNicks(unkownNicks, 4, 18),
 			err.Error())
 		return nil
@@ -246,65 +283,38 @@ func (vm *ViewerMethod) UpdateViewers(nickList []IrcNick) []*Viewer {
 }
 
 func (vm *ViewerMethod) updateInteralFollowerCache(f ChannelFollow) {
-	vm.lockmap()
-	vm.followerCache[f.User.ID] = ChannelRelationship(f).CreatedAt()
-	vm.unlockmap()
+	vm.followerCache.set(f.User.ID, ChannelRelationship(f).CreatedAt())
 }
 
 // UpdateFollowers - Update Followers values and stored cache
 func (vm *ViewerMethod) UpdateFollowers(fList []ChannelFollow) {
-	for _, f := range fList {
+	for i := range fList {
+		f := fList[i]
 		v := vm.GetFromUser(*f.User)
 
 		v.Lockme()
 		v.data.Follower = &f
 		v.Unlockme()
-	}
 
-	vm.lockmap()
-	for _, f := range fList {
-		vm.followerCache[f.User.ID] = ChannelRelationship(f).CreatedAt()
+		vm.followerCache.set(f.User.ID, ChannelRelationship(f).CreatedAt())
 	}
-	vm.unlockmap()
 }
 
 // GetRandomFollowers - Returns Random Follow for Channel
+// Followers who are no longer known viewers are skipped so fewer may be returned
 func (vm *ViewerMethod) GetRandomFollowers(numFollowers int) []*Viewer {
+	followers := vm.followerCache.keys()
+	listOfOffset := rand.Perm(len(followers))
 
-	// Lock
-	vm.lockmap()
-	defer vm.unlockmap()
-
-	mapLength := len(vm.followerCache)
-	listOfOffset := rand.Perm(mapLength)
-
-	if numFollowers < mapLength {
-		listOfOffset = listOfOffset[:numFollowers]
-	}
-
-	c := 0
-	vRes := make([]*Viewer, numFollowers, numFollowers)
-
-	offset := 0
-	for i := range vm.followerCache {
-		for _, x := range listOfOffset {
-			if x == offset {
-				v := vm.viewers[i]
-				vRes[c] = v
-				c++
-				break
-			}
+	vRes := make([]*Viewer, 0, numFollowers)
+	for _, x := range listOfOffset {
+		if len(vRes) >= numFollowers {
+			break
 		}
 
-		offset++
-	}
-
-	for i := 0; i < len(vRes); i++ {
-		if vRes[i] == nil {
-			fmt.Println(mapLength)
-			fmt.Println(listOfOffset)
-			fmt.Println(vRes)
-			panic("Failed to Gen Random")
+		v, ok := vm.viewers.lookup(followers[x])
+		if ok {
+			vRes = append(vRes, v)
 		}
 	}
 
@@ -316,48 +326,41 @@ func (vm *ViewerMethod) MostUpToDateViewer(numFollowers int) (*Viewer, time.Time
 	var mostRecentViewer *Viewer
 	oldTime := time.Unix(0, 0)
 
-	// Lock
-	vm.lockmap()
-	defer vm.unlockmap()
-
-	for k := range vm.viewers {
-		v := vm.viewers[k]
-
-		if v.data.User == nil {
-			continue
+	vm.forEach(func(v *Viewer) bool {
+		vd := v.GetData()
+		if vd.User == nil {
+			return true
 		}
 
-		updatedTime := v.data.User.UpdatedAt()
+		updatedTime := vd.User.UpdatedAt()
 		if updatedTime.After(oldTime) {
 			oldTime = updatedTime
 			mostRecentViewer = v
 		}
-	}
+		return true
+	})
 
 	return mostRecentViewer, oldTime
 }
 
 // SanityScan - Checks Viewer Data for Issues
 func (vm *ViewerMethod) SanityScan() error {
-	vm.lockmap()
-	defer vm.unlockmap()
+	var err error
 
-	for k := range vm.viewers {
-		v := vm.viewers[k]
+	vm.viewers.forEach(func(k ID, v *Viewer) bool {
 		vd := v.GetData()
 
-		if k != vd.TwitchID {
-			return fmt.Errorf("Twitch ID doesn't match %s\n\t %s != %s", vd.GetNick(), k, vd.TwitchID)
+		switch {
+		case k != vd.TwitchID:
+			err = fmt.Errorf("Twitch ID doesn't match %s\n\t %s != %s", vd.GetNick(), k, vd.TwitchID)
+		case vd.User == nil:
+			err = fmt.Errorf("User is nil: %s", k)
+		case k != vd.User.ID:
+			err = fmt.Errorf("User ID doesn't match %s\n\t %s != %s", vd.GetNick(), k, vd.User.ID)
 		}
 
-		if vd.User == nil {
-			return fmt.Errorf("User is nil: %s", k)
-		}
-
-		if k != vd.User.ID {
-			return fmt.Errorf("User ID doesn't match %s\n\t %s != %s", vd.GetNick(), k, vd.User.ID)
-		}
-	}
+		return err == nil
+	})
 
-	return nil
+	return err
 }
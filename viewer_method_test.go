@@ -0,0 +1,144 @@
+package twitch
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestViewerNickIndex(t *testing.T) {
+	vm := CreateViewerMethod(&Client{})
+
+	vm.Set(ViewerData{TwitchID: "1", User: &User{ID: "1", Name: "kimau"}})
+	vm.GetFromUser(User{ID: "2", Name: "ronni", DisplayName: "Ronni"})
+	vm.Set(ViewerData{TwitchID: "3"}) // No user must not panic lookups
+
+	if v := vm.findViewerByName("KiMaU"); v == nil || v.data.TwitchID != "1" {
+		t.Errorf("Case insensitive lookup failed: %v", v)
+	}
+	if v := vm.findViewerByName("ronni"); v == nil || v.data.TwitchID != "2" {
+		t.Errorf("Lookup from GetFromUser failed: %v", v)
+	}
+
+	// Rename through the API path
+	vm.GetFromUser(User{ID: "2", Name: "ronni_new", DisplayName: "Ronni_New"})
+	if v := vm.findViewerByName("ronni"); v != nil {
+		t.Errorf("Old nick still indexed: %s", v.data.TwitchID)
+	}
+
+	v := vm.findViewerByName("Ronni_New")
+	if v == nil {
+		t.Fatalf("New nick not indexed")
+	}
+
+	vd := v.GetData()
+	if len(vd.PrevNicks) != 1 || vd.PrevNicks[0].Nick != "ronni" {
+		t.Errorf("Rename history wrong: %v", vd.PrevNicks)
+	}
+	if vd.Chatter == nil || vd.Chatter.Nick != "ronni_new" {
+		t.Errorf("Chatter nick not updated: %v", vd.Chatter)
+	}
+
+	// Same name in a different case is not a rename
+	v.SetUser(User{ID: "2", Name: "RONNI_NEW"})
+	if vd = v.GetData(); len(vd.PrevNicks) != 1 {
+		t.Errorf("Case change recorded as rename: %v", vd.PrevNicks)
+	}
+
+	// Name reused by someone else after the rename
+	vm.GetFromUser(User{ID: "4", Name: "ronni"})
+	if v := vm.findViewerByName("ronni"); v == nil || v.data.TwitchID != "4" {
+		t.Errorf("Reused nick should point at the new owner: %v", v)
+	}
+
+	if vm.nicks.size() != 3 {
+		t.Errorf("Index should have 3 nicks not %d", vm.nicks.size())
+	}
+}
+
+// Run with -race, chat, heartbeat and the follower crawl all touch the store at once
+func TestViewerStoreConcurrency(t *testing.T) {
+	const numViewers = 64
+	const numRounds = 200
+
+	vm := CreateViewerMethod(&Client{})
+	chat := &Chat{viewers: vm, inRoom: make(map[IrcNick]*Viewer)}
+
+	users := make([]User, numViewers)
+	for i := range users {
+		users[i] = User{
+			ID:              ID(fmt.Sprint(i)),
+			Name:            IrcNick(fmt.Sprintf("viewer%d", i)),
+			UpdatedAtString: time.Now().Format(time.RFC3339),
+		}
+		vm.GetFromUser(users[i])
+	}
+
+	var wg sync.WaitGroup
+	run := func(fn func(i int)) {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < numRounds; i++ {
+				fn(i)
+			}
+		}()
+	}
+
+	// Chat joins, renames and lookups
+	run(func(i int) {
+		u := users[i%numViewers]
+		v, err := vm.Find(u.Name)
+		if err != nil {
+			t.Errorf("Find %s: %s", u.Name, err)
+			return
+		}
+		chat.activeInRoom(v, PresenceJoin)
+		chat.IsInRoom(u.Name)
+		v.SetUser(u)
+	})
+	run(func(i int) {
+		chat.tickRoomActive()
+		chat.InRoomNicks()
+	})
+
+	// Heartbeat and dumps
+	run(func(i int) {
+		vm.MostUpToDateViewer(1)
+		vm.AllKeys()
+		vm.GetRandomFollowers(5)
+		if _, err := vm.GetData(users[i%numViewers].ID); err != nil {
+			t.Errorf("GetData: %s", err)
+		}
+	})
+
+	// Follower crawl
+	run(func(i int) {
+		u := users[(i*7)%numViewers]
+		vm.UpdateFollowers([]ChannelFollow{{User: &u, CreatedAtString: time.Now().Format(time.RFC3339)}})
+		vm.IsFollower(u.ID)
+		if i%3 == 0 {
+			vm.ClearFollower(u.ID)
+		}
+	})
+
+	// Dump reload replacing viewers
+	run(func(i int) {
+		u := users[(i*13)%numViewers]
+		vd := vm.GetPtr(u.ID).GetData()
+		vm.Set(vd)
+	})
+
+	wg.Wait()
+
+	if vm.Count() != numViewers {
+		t.Errorf("Expected %d viewers not %d", numViewers, vm.Count())
+	}
+	if err := vm.SanityScan(); err != nil {
+		t.Error(err)
+	}
+	if len(chat.InRoomNicks()) != numViewers {
+		t.Errorf("Expected everyone in room not %d", len(chat.InRoomNicks()))
+	}
+}
@@ -0,0 +1,91 @@
+package twitch
+
+import (
+	"log"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	viewerMaxPrevNicks = 20
+)
+
+// NickChange - Name a viewer used before a rename
+type NickChange struct {
+	Nick  IrcNick   `json:"nick"`
+	Until time.Time `json:"until"` // When the new name was first seen
+}
+
+// nickIndex - Lower case nick to ID, has its own lock so viewers can update it while the map is locked
+type nickIndex struct {
+	lock sync.RWMutex
+	ids  map[IrcNick]ID
+}
+
+func createNickIndex() *nickIndex {
+	return &nickIndex{ids: make(map[IrcNick]ID)}
+}
+
+func nickKey(nick IrcNick) IrcNick {
+	return IrcNick(strings.ToLower(string(nick)))
+}
+
+func (ni *nickIndex) lookup(nick IrcNick) (ID, bool) {
+	ni.lock.RLock()
+	defer ni.lock.RUnlock()
+
+	tid, ok := ni.ids[nickKey(nick)]
+	return tid, ok
+}
+
+// update - Point newNick at tid and drop oldNick if it still points at tid
+func (ni *nickIndex) update(tid ID, oldNick IrcNick, newNick IrcNick) {
+	ni.lock.Lock()
+	defer ni.lock.Unlock()
+
+	if oldNick != "" && ni.ids[nickKey(oldNick)] == tid {
+		delete(ni.ids, nickKey(oldNick))
+	}
+	if newNick != "" {
+		ni.ids[nickKey(newNick)] = tid
+	}
+}
+
+func (ni *nickIndex) size() int {
+	ni.lock.RLock()
+	defer ni.lock.RUnlock()
+	return len(ni.ids)
+}
+
+// setUserLocked - Replace the user, recording a rename, must hold the viewer lock
+func (vw *Viewer) setUserLocked(newVal *User) {
+	var oldNick IrcNick
+	if vw.data.User != nil {
+		oldNick = vw.data.User.Name
+	}
+
+	vw.data.User = newVal
+	if newVal == nil {
+		return
+	}
+
+	if oldNick != "" && nickKey(oldNick) != nickKey(newVal.Name) {
+		log.Printf("Viewer %s renamed %s -> %s", vw.data.TwitchID, oldNick, newVal.Name)
+		vw.data.PrevNicks = append(vw.data.PrevNicks, NickChange{Nick: oldNick, Until: time.Now()})
+		if len(vw.data.PrevNicks) > viewerMaxPrevNicks {
+			vw.data.PrevNicks = vw.data.PrevNicks[len(vw.data.PrevNicks)-viewerMaxPrevNicks:]
+		}
+
+		if vw.data.Chatter != nil {
+			c := *vw.data.Chatter
+			c.Nick = newVal.Name
+			c.DisplayName = newVal.DisplayName
+			vw.data.Chatter = &c
+		}
+	}
+
+	if vw.nicks != nil {
+		vw.nicks.update(vw.data.TwitchID, oldNick, newVal.Name)
+	}
+}
@@ -0,0 +1,228 @@
+package twitch
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	viewerMaxModActions = 50
+	viewerMaxNotes      = 100
+	viewerNoteMaxLength = 500
+	profileAlertLimit   = 20
+)
+
+// Moderation actions seen in CLEARCHAT
+const (
+	ModActionTimeout = "timeout"
+	ModActionBan     = "ban"
+)
+
+// ModAction - Timeout or ban seen in chat
+type ModAction struct {
+	At       time.Time     `json:"at"`
+	Action   string        `json:"action"`
+	Duration time.Duration `json:"duration,omitempty"` // Timeouts only
+	Reason   string        `json:"reason,omitempty"`
+}
+
+// ViewerNote - Note left by a mod
+type ViewerNote struct {
+	At       time.Time `json:"at"`
+	AuthorID ID        `json:"author_id"`
+	Author   IrcNick   `json:"author"`
+	Text     string    `json:"text"`
+}
+
+// ViewerActivity - What we have seen of a viewer in chat, saved with the rest of the viewer
+type ViewerActivity struct {
+	FirstSeen   time.Time   `json:"first_seen"`
+	LastSeen    time.Time   `json:"last_seen"`
+	Messages    int         `json:"messages"`
+	BitsCheered int         `json:"bits_cheered"`
+	SubMonths   int         `json:"sub_months"`
+	SubTier     string      `json:"sub_tier,omitempty"`   // Sub plan 1000, 2000, 3000 or Prime
+	Moderation  []ModAction `json:"moderation,omitempty"` // Oldest first
+}
+
+// ViewerProfile - Everything known about a viewer in one place
+type ViewerProfile struct {
+	ID          ID      `json:"id"`
+	Nick        IrcNick `json:"nick"`
+	DisplayName string  `json:"display_name,omitempty"`
+
+	AccountCreated *time.Time    `json:"account_created,omitempty"`
+	AccountAge     time.Duration `json:"account_age,omitempty"`
+	FollowedAt     *time.Time    `json:"followed_at,omitempty"`
+
+	Mod       bool   `json:"mod"`
+	VIP       bool   `json:"vip"`
+	SubMonths int    `json:"sub_months"`
+	SubTier   string `json:"sub_tier,omitempty"`
+
+	BitsCheered int           `json:"bits_cheered"`
+	Messages    int           `json:"messages"`
+	FirstSeen   *time.Time    `json:"first_seen,omitempty"`
+	LastSeen    *time.Time    `json:"last_seen,omitempty"`
+	WatchTime   time.Duration `json:"watch_time"`
+	InRoom      bool          `json:"in_room"`
+	Points      Currency      `json:"points"`
+
+	Moderation []ModAction  `json:"moderation"`
+	PrevNicks  []NickChange `json:"prev_nicks"`
+	Notes      []ViewerNote `json:"notes"`
+	Alerts     []Alert      `json:"alerts"`
+}
+
+// clone - Activity is swapped whole so copies handed out by GetData never change
+func (va *ViewerActivity) clone() *ViewerActivity {
+	if va == nil {
+		return &ViewerActivity{}
+	}
+	na := *va
+	na.Moderation = append([]ModAction{}, va.Moderation...)
+	return &na
+}
+
+func (va *ViewerActivity) seen(at time.Time) {
+	if va.FirstSeen.IsZero() {
+		va.FirstSeen = at
+	}
+	va.LastSeen = at
+}
+
+// updateActivity - Change a copy of the activity and swap it in
+func (vw *Viewer) updateActivity(fn func(va *ViewerActivity)) {
+	vw.Lockme()
+	defer vw.Unlockme()
+
+	va := vw.data.Activity.clone()
+	fn(va)
+	vw.data.Activity = va
+}
+
+// recordSeen - Present in the room
+func (vw *Viewer) recordSeen(at time.Time) {
+	vw.updateActivity(func(va *ViewerActivity) {
+		va.seen(at)
+	})
+}
+
+// recordMessage - Sent a chat message with bits cheered
+func (vw *Viewer) recordMessage(at time.Time, bits int) {
+	vw.updateActivity(func(va *ViewerActivity) {
+		va.seen(at)
+		va.Messages++
+		va.BitsCheered += bits
+	})
+}
+
+// recordSub - Sub or resub notice, months of zero keeps the last known value
+func (vw *Viewer) recordSub(months int, plan string) {
+	vw.updateActivity(func(va *ViewerActivity) {
+		if months > va.SubMonths {
+			va.SubMonths = months
+		} else if va.SubMonths == 0 {
+			va.SubMonths = 1
+		}
+		if plan != "" {
+			va.SubTier = plan
+		}
+	})
+}
+
+// recordModeration - Timed out or banned
+func (vw *Viewer) recordModeration(ma ModAction) {
+	vw.updateActivity(func(va *ViewerActivity) {
+		va.Moderation = append(va.Moderation, ma)
+		if len(va.Moderation) > viewerMaxModActions {
+			va.Moderation = va.Moderation[len(va.Moderation)-viewerMaxModActions:]
+		}
+	})
+}
+
+// AddNote - Attach a mod note, the oldest are dropped past viewerMaxNotes
+func (vw *Viewer) AddNote(author ViewerData, text string) (ViewerNote, error) {
+	text = strings.TrimSpace(text)
+	if text == "" || len(text) > viewerNoteMaxLength {
+		return ViewerNote{}, fmt.Errorf("Note must be 1 to %d characters", viewerNoteMaxLength)
+	}
+
+	note := ViewerNote{At: time.Now(), AuthorID: author.TwitchID, Author: author.GetNick(), Text: text}
+
+	vw.Lockme()
+	defer vw.Unlockme()
+
+	notes := append(make([]ViewerNote, 0, len(vw.data.Notes)+1), vw.data.Notes...)
+	notes = append(notes, note)
+	if len(notes) > viewerMaxNotes {
+		notes = notes[len(notes)-viewerMaxNotes:]
+	}
+	vw.data.Notes = notes
+	return note, nil
+}
+
+// ViewerProfile - Gather the viewer, their activity, presence and alerts
+func (ah *Client) ViewerProfile(tid ID) (*ViewerProfile, error) {
+	vd, err := ah.Viewers.GetData(tid)
+	if err != nil {
+		return nil, err
+	}
+
+	vp := ViewerProfile{
+		ID:         vd.TwitchID,
+		Nick:       vd.GetNick(),
+		Points:     vd.Balance(),
+		Moderation: []ModAction{},
+		PrevNicks:  append([]NickChange{}, vd.PrevNicks...),
+		Notes:      append([]ViewerNote{}, vd.Notes...),
+		Alerts:     []Alert{},
+	}
+
+	if vd.User != nil {
+		vp.DisplayName = vd.User.DisplayName
+		if vd.User.CreatedAtString != "" {
+			created := vd.User.CreatedAt()
+			vp.AccountCreated = &created
+			vp.AccountAge = time.Since(created)
+		}
+	}
+
+	if ok, followed := ah.Viewers.IsFollower(tid); ok {
+		vp.FollowedAt = &followed
+	}
+
+	if vd.Chatter != nil {
+		vp.Mod = vd.Chatter.Mod
+		vp.VIP = vd.Chatter.VIP
+		vp.SubMonths = vd.Chatter.Sub
+		vp.WatchTime = vd.Chatter.TimeInChannel
+	}
+
+	if va := vd.Activity; va != nil {
+		if va.SubMonths > vp.SubMonths {
+			vp.SubMonths = va.SubMonths
+		}
+		vp.SubTier = va.SubTier
+		vp.BitsCheered = va.BitsCheered
+		vp.Messages = va.Messages
+		if !va.FirstSeen.IsZero() {
+			first, last := va.FirstSeen, va.LastSeen
+			vp.FirstSeen, vp.LastSeen = &first, &last
+		}
+		vp.Moderation = append(vp.Moderation, va.Moderation...)
+	}
+
+	if ah.Presence != nil {
+		vp.InRoom = ah.Presence.IsPresent(vp.Nick)
+	} else if ah.Chat != nil {
+		vp.InRoom = ah.Chat.IsInRoom(vp.Nick)
+	}
+
+	if ah.Alerts != nil && ah.Alerts.Journal != nil && vp.Nick != "" {
+		vp.Alerts = ah.Alerts.Journal.Query(AlertQuery{Source: vp.Nick, Limit: profileAlertLimit})
+	}
+
+	return &vp, nil
+}
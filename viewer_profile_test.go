@@ -0,0 +1,92 @@
+package twitch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/go-irc/irc"
+)
+
+func TestViewerProfile(t *testing.T) {
+	ah := &Client{Config: DefaultConfig(), RoomName: "kimau", Alerts: StartAlertPump(nil)}
+	ah.Viewers = CreateViewerMethod(ah)
+	ah.Presence, _ = OpenPresenceLog("")
+
+	chat, err := createIrcClient(&DummyAuth{}, ah.Viewers, "", DefaultDataDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chat.weakClientRef = ah
+	ah.Chat = chat
+
+	v := ah.Viewers.GetFromUser(User{ID: "7", Name: "ronni", DisplayName: "Ronni", CreatedAtString: "2015-01-02T03:04:05Z"})
+	ah.Viewers.GetFromUser(User{ID: "1", Name: "kimau"})
+	ah.Viewers.UpdateFollowers([]ChannelFollow{{User: &User{ID: "7", Name: "ronni", CreatedAtString: "2015-01-02T03:04:05Z"}, CreatedAtString: "2017-01-02T03:04:05Z"}})
+
+	chat.activeInRoom(v, PresenceJoin)
+	v.recordMessage(time.Now(), 0)
+	v.recordMessage(time.Now(), 100)
+	v.recordSub(6, "1000")
+	v.recordSub(0, "")
+
+	for _, line := range []string{
+		"@ban-duration=600;ban-reason=Spam :tmi.twitch.tv CLEARCHAT #kimau :ronni",
+		":tmi.twitch.tv CLEARCHAT #kimau :ronni",
+		":tmi.twitch.tv CLEARCHAT #kimau",
+	} {
+		m, err := irc.ParseMessage(line)
+		if err != nil {
+			t.Fatal(err)
+		}
+		chat.clearChat(m)
+	}
+
+	call := func(method, path, body string) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(method, "/admin/"+path, strings.NewReader(body))
+		rec := httptest.NewRecorder()
+		ah.apiRoute(rec, req, path, ViewerData{TwitchID: "1", User: &User{ID: "1", Name: "kimau"}}, RoleMod)
+		return rec
+	}
+
+	if rec := call("POST", "viewers/7/notes", `{"text":"  Keeps posting links  "}`); rec.Code != http.StatusCreated {
+		t.Errorf("Add note %d %s", rec.Code, rec.Body)
+	}
+	if rec := call("POST", "viewers/7/notes", `{"text":" "}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Empty note allowed %d", rec.Code)
+	}
+
+	rec := call("GET", "viewers/7/profile", "")
+	vp := ViewerProfile{}
+	if err := json.Unmarshal(rec.Body.Bytes(), &vp); err != nil || rec.Code != http.StatusOK {
+		t.Fatalf("Profile %d %s", rec.Code, rec.Body)
+	}
+
+	if vp.AccountCreated == nil || vp.AccountCreated.Year() != 2015 || vp.AccountAge < time.Hour*24*365 {
+		t.Errorf("Account age wrong: %v %s", vp.AccountCreated, vp.AccountAge)
+	}
+	if vp.FollowedAt == nil || vp.FollowedAt.Year() != 2017 {
+		t.Errorf("Follow date wrong: %v", vp.FollowedAt)
+	}
+	if vp.Messages != 2 || vp.BitsCheered != 100 || vp.SubMonths != 6 || vp.SubTier != "1000" {
+		t.Errorf("Activity wrong: %+v", vp)
+	}
+	if vp.FirstSeen == nil || vp.LastSeen == nil || vp.LastSeen.Before(*vp.FirstSeen) || !vp.InRoom {
+		t.Errorf("Seen wrong: %v %v in room %v", vp.FirstSeen, vp.LastSeen, vp.InRoom)
+	}
+	if len(vp.Moderation) != 2 || vp.Moderation[0].Action != ModActionTimeout ||
+		vp.Moderation[0].Duration != time.Minute*10 || vp.Moderation[0].Reason != "Spam" || vp.Moderation[1].Action != ModActionBan {
+		t.Errorf("Moderation wrong: %+v", vp.Moderation)
+	}
+	if len(vp.Notes) != 1 || vp.Notes[0].Text != "Keeps posting links" || vp.Notes[0].Author != "kimau" {
+		t.Errorf("Notes wrong: %+v", vp.Notes)
+	}
+
+	// Notes persist with the viewer
+	if vd, _ := ah.Viewers.GetData("7"); len(vd.Notes) != 1 || vd.Activity == nil || len(vd.Activity.Moderation) != 2 {
+		t.Errorf("Not saved on viewer data: %+v", vd)
+	}
+}
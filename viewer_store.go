@@ -0,0 +1,179 @@
+package twitch
+
+import (
+	"hash/fnv"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	viewerShardCount = 32
+)
+
+// viewerShard - Part of the viewer map with its own lock so chat, heartbeat and crawls rarely contend
+// Lock order is shard then viewer then nick index, never hold two shards at once
+type viewerShard struct {
+	lock    sync.RWMutex
+	viewers map[ID]*Viewer
+}
+
+// viewerStore - Viewers sharded by ID
+type viewerStore struct {
+	shards [viewerShardCount]viewerShard
+}
+
+func createViewerStore() *viewerStore {
+	vs := &viewerStore{}
+	for i := range vs.shards {
+		vs.shards[i].viewers = make(map[ID]*Viewer)
+	}
+	return vs
+}
+
+func (vs *viewerStore) shard(tid ID) *viewerShard {
+	h := fnv.New32a()
+	h.Write([]byte(tid))
+	return &vs.shards[h.Sum32()%viewerShardCount]
+}
+
+func (vs *viewerStore) lookup(tid ID) (*Viewer, bool) {
+	s := vs.shard(tid)
+	s.lock.RLock()
+	defer s.lock.RUnlock()
+
+	v, ok := s.viewers[tid]
+	return v, ok
+}
+
+// getOrAlloc - Existing viewer or a new one from alloc, created is true if alloc was used
+func (vs *viewerStore) getOrAlloc(tid ID, alloc func() *Viewer) (*Viewer, bool) {
+	s := vs.shard(tid)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if v, ok := s.viewers[tid]; ok {
+		return v, false
+	}
+
+	v := alloc()
+	s.viewers[tid] = v
+	return v, true
+}
+
+// swap - Replace the viewer returning the old one
+func (vs *viewerStore) swap(tid ID, v *Viewer) *Viewer {
+	s := vs.shard(tid)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	old := s.viewers[tid]
+	s.viewers[tid] = v
+	return old
+}
+
+// saveIf - Run save with the shard locked if the viewer is still v
+func (vs *viewerStore) saveIf(tid ID, v *Viewer, save func() error) bool {
+	s := vs.shard(tid)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.viewers[tid] != v {
+		return false
+	}
+	return save() == nil
+}
+
+// removeIf - Drop the viewer if it is still v and save succeeds, save runs with the shard locked
+// so a reload cannot read the disk copy before it is written
+func (vs *viewerStore) removeIf(tid ID, v *Viewer, save func() error) bool {
+	s := vs.shard(tid)
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	if s.viewers[tid] != v {
+		return false
+	}
+
+	if err := save(); err != nil {
+		log.Printf("Unable to evict viewer %s: %s", tid, err)
+		return false
+	}
+
+	delete(s.viewers, tid)
+	return true
+}
+
+// forEach - Call fn on a snapshot of every viewer with no store locks held, stops when fn returns false
+func (vs *viewerStore) forEach(fn func(ID, *Viewer) bool) {
+	for i := range vs.shards {
+		s := &vs.shards[i]
+
+		s.lock.RLock()
+		keys := make([]ID, 0, len(s.viewers))
+		vList := make([]*Viewer, 0, len(s.viewers))
+		for k, v := range s.viewers {
+			keys = append(keys, k)
+			vList = append(vList, v)
+		}
+		s.lock.RUnlock()
+
+		for j, v := range vList {
+			if !fn(keys[j], v) {
+				return
+			}
+		}
+	}
+}
+
+func (vs *viewerStore) count() int {
+	total := 0
+	for i := range vs.shards {
+		s := &vs.shards[i]
+		s.lock.RLock()
+		total += len(s.viewers)
+		s.lock.RUnlock()
+	}
+	return total
+}
+
+// followerSet - Follow times by ID so IsFollower never touches a viewer lock
+type followerSet struct {
+	lock  sync.RWMutex
+	since map[ID]time.Time
+}
+
+func createFollowerSet() *followerSet {
+	return &followerSet{since: make(map[ID]time.Time)}
+}
+
+func (fs *followerSet) get(tid ID) (time.Time, bool) {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+
+	t, ok := fs.since[tid]
+	return t, ok
+}
+
+func (fs *followerSet) set(tid ID, t time.Time) {
+	fs.lock.Lock()
+	fs.since[tid] = t
+	fs.lock.Unlock()
+}
+
+func (fs *followerSet) clear(tid ID) {
+	fs.lock.Lock()
+	delete(fs.since, tid)
+	fs.lock.Unlock()
+}
+
+func (fs *followerSet) keys() []ID {
+	fs.lock.RLock()
+	defer fs.lock.RUnlock()
+
+	kList := make([]ID, 0, len(fs.since))
+	for k := range fs.since {
+		kList = append(kList, k)
+	}
+	return kList
+}
@@ -0,0 +1,216 @@
+package twitch
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultViewerCacheSize - Viewers kept in memory before the least recently used go to disk
+	DefaultViewerCacheSize = 50000
+
+	viewerEvictMinIdle = time.Minute * 15 // Longer than the chat PING so viewers in the room stay resident
+	viewerEvictTarget  = 90               // Percent of the limit to evict down to
+)
+
+// viewerDiskStore - Where evicted viewers live, Load returns nil for an unknown ID
+// ViewerDB is the only store, the interface keeps the tier logic apart from the file format
+type viewerDiskStore interface {
+	Load(tid ID) (*ViewerData, error)
+	Save(vd ViewerData) error
+	Keys() ([]ID, error)
+}
+
+var _ viewerDiskStore = (*ViewerDB)(nil)
+
+// ViewerCacheStats - How well the in memory working set is doing
+type ViewerCacheStats struct {
+	Resident   int    `json:"resident"`
+	Limit      int    `json:"limit"` // 0 is unbounded
+	Hits       uint64 `json:"hits"`
+	Misses     uint64 `json:"misses"` // Not in memory or on disk
+	DiskHits   uint64 `json:"disk_hits"`
+	Evictions  uint64 `json:"evictions"`
+	EvictFails uint64 `json:"evict_fails"`
+}
+
+// HitRate - Fraction of lookups answered from memory
+func (cs ViewerCacheStats) HitRate() float64 {
+	total := cs.Hits + cs.Misses + cs.DiskHits
+	if total == 0 {
+		return 0
+	}
+	return float64(cs.Hits) / float64(total)
+}
+
+// viewerCacheCounters - Updated atomically from any goroutine
+type viewerCacheCounters struct {
+	hits       uint64
+	misses     uint64
+	diskHits   uint64
+	evictions  uint64
+	evictFails uint64
+	evicting   int32
+}
+
+// touch - Mark the viewer as used for eviction
+func (vw *Viewer) touch() {
+	atomic.StoreInt64(&vw.lastUsed, time.Now().UnixNano())
+}
+
+func (vw *Viewer) idleSince() time.Time {
+	return time.Unix(0, atomic.LoadInt64(&vw.lastUsed))
+}
+
+// attachDisk - Bound the in memory viewers to limit spilling to store
+func (vm *ViewerMethod) attachDisk(store viewerDiskStore, limit int) {
+	vm.disk = store
+	vm.cacheLimit = limit
+}
+
+// CacheStats - Memory and disk tier metrics
+func (vm *ViewerMethod) CacheStats() ViewerCacheStats {
+	return ViewerCacheStats{
+		Resident:   vm.viewers.count(),
+		Limit:      vm.cacheLimit,
+		Hits:       atomic.LoadUint64(&vm.counters.hits),
+		Misses:     atomic.LoadUint64(&vm.counters.misses),
+		DiskHits:   atomic.LoadUint64(&vm.counters.diskHits),
+		Evictions:  atomic.LoadUint64(&vm.counters.evictions),
+		EvictFails: atomic.LoadUint64(&vm.counters.evictFails),
+	}
+}
+
+// load - Viewer from memory or reloaded from disk
+func (vm *ViewerMethod) load(tid ID) (*Viewer, bool) {
+	if v, ok := vm.viewers.lookup(tid); ok {
+		atomic.AddUint64(&vm.counters.hits, 1)
+		return v, true
+	}
+
+	if vm.disk == nil {
+		atomic.AddUint64(&vm.counters.misses, 1)
+		return nil, false
+	}
+
+	vd, err := vm.disk.Load(tid)
+	if err != nil {
+		vm.client.logf("Unable to reload viewer %s: %s", tid, err)
+	}
+	if vd == nil {
+		atomic.AddUint64(&vm.counters.misses, 1)
+		return nil, false
+	}
+
+	atomic.AddUint64(&vm.counters.diskHits, 1)
+	v, created := vm.viewers.getOrAlloc(tid, func() *Viewer {
+		v := vm.allocViewer(tid)
+		v.data = *vd
+		return v
+	})
+	if created {
+		vm.maybeEvict()
+	}
+	return v, true
+}
+
+// maybeEvict - Start an eviction pass if over the limit and one is not running
+func (vm *ViewerMethod) maybeEvict() {
+	if vm.disk == nil || vm.cacheLimit <= 0 || vm.viewers.count() <= vm.cacheLimit {
+		return
+	}
+
+	if atomic.CompareAndSwapInt32(&vm.counters.evicting, 0, 1) {
+		go func() {
+			vm.evict(time.Now().Add(-viewerEvictMinIdle))
+			atomic.StoreInt32(&vm.counters.evicting, 0)
+		}()
+	}
+}
+
+// evict - Write the least recently used viewers idle since before cutoff to disk and drop them
+func (vm *ViewerMethod) evict(cutoff time.Time) int {
+	type candidate struct {
+		tid  ID
+		v    *Viewer
+		used time.Time
+	}
+
+	cList := []candidate{}
+	vm.viewers.forEach(func(k ID, v *Viewer) bool {
+		if used := v.idleSince(); used.Before(cutoff) {
+			cList = append(cList, candidate{k, v, used})
+		}
+		return true
+	})
+	sort.Slice(cList, func(i, j int) bool { return cList[i].used.Before(cList[j].used) })
+
+	excess := vm.viewers.count() - vm.cacheLimit*viewerEvictTarget/100
+	num := 0
+	for _, c := range cList {
+		if num >= excess {
+			break
+		}
+
+		ok := vm.viewers.removeIf(c.tid, c.v, func() error {
+			err := vm.disk.Save(c.v.GetData())
+			if err != nil {
+				atomic.AddUint64(&vm.counters.evictFails, 1)
+			}
+			return err
+		})
+		if ok {
+			num++
+		}
+	}
+
+	atomic.AddUint64(&vm.counters.evictions, uint64(num))
+	if num > 0 {
+		vm.client.logf("Evicted %d viewers to disk, %d resident", num, vm.viewers.count())
+	}
+	return num
+}
+
+// forEachData - Every viewer in memory and on disk, disk viewers are read without being made resident
+func (vm *ViewerMethod) forEachData(fn func(ViewerData) bool) error {
+	resident := make(map[ID]bool)
+	stopped := false
+	vm.viewers.forEach(func(k ID, v *Viewer) bool {
+		resident[k] = true
+		stopped = !fn(v.GetData())
+		return !stopped
+	})
+
+	if stopped || vm.disk == nil {
+		return nil
+	}
+
+	keys, err := vm.disk.Keys()
+	if err != nil {
+		return err
+	}
+
+	for _, k := range keys {
+		if resident[k] {
+			continue
+		}
+		if v, ok := vm.viewers.lookup(k); ok {
+			if !fn(v.GetData()) {
+				return nil
+			}
+			continue
+		}
+
+		vd, err := vm.disk.Load(k)
+		if err != nil {
+			vm.client.logf("Skipping unreadable viewer %s: %s", k, err)
+			continue
+		}
+		if vd != nil && !fn(*vd) {
+			return nil
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,92 @@
+package twitch
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestViewerTierEviction(t *testing.T) {
+	dir, err := ioutil.TempDir("", "viewer_tier")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	store, err := OpenViewerDB(filepath.Join(dir, "viewers_test.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer store.Close()
+
+	vm := CreateViewerMethod(&Client{})
+	vm.attachDisk(store, 0)
+
+	for i := 0; i < 30; i++ {
+		v := vm.GetFromUser(User{ID: ID(fmt.Sprint(i)), Name: IrcNick(fmt.Sprintf("tier%d", i))})
+		if i == 3 {
+			v.SetAuth(UserAuth{AuthCode: "supersecretcode"})
+		}
+	}
+
+	// Everyone but the last five went quiet an hour ago
+	old := time.Now().Add(-time.Hour).UnixNano()
+	vm.viewers.forEach(func(k ID, v *Viewer) bool {
+		if k != "25" && k != "26" && k != "27" && k != "28" && k != "29" {
+			atomic.StoreInt64(&v.lastUsed, old)
+		}
+		return true
+	})
+
+	vm.cacheLimit = 10
+	num := vm.evict(time.Now().Add(-viewerEvictMinIdle))
+	if num != 21 || vm.Count() != 9 {
+		t.Fatalf("Expected 21 evicted leaving 9 not %d leaving %d", num, vm.Count())
+	}
+	if v := vm.GetPtr("29"); v == nil || vm.CacheStats().DiskHits != 0 {
+		t.Errorf("Recently used viewer was evicted")
+	}
+	vm.cacheLimit = 0 // No background passes while checking reloads
+
+	raw, err := ioutil.ReadFile(store.filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(raw), "supersecretcode") {
+		t.Errorf("Auth stored in the clear: %s", raw)
+	}
+
+	// Transparent reload by ID and by nick
+	v := vm.GetPtr("3")
+	if v == nil {
+		t.Fatalf("Evicted viewer not reloaded")
+	}
+	if vd := v.GetData(); vd.Auth == nil || vd.Auth.AuthCode != "supersecretcode" || vd.User.Name != "tier3" {
+		t.Errorf("Reloaded viewer lost data: %+v", vd)
+	}
+	if v, err := vm.Find("TIER4"); err != nil || v.GetData().TwitchID != "4" {
+		t.Errorf("Find did not reload evicted viewer: %v %s", v, err)
+	}
+
+	if keys := vm.AllKeys(); len(keys) != 30 {
+		t.Errorf("AllKeys should include evicted viewers, got %d", len(keys))
+	}
+	total := 0
+	vm.forEachData(func(vd ViewerData) bool {
+		total++
+		return true
+	})
+	if total != 30 || vm.Count() != 11 {
+		t.Errorf("Walking data should not reload, saw %d with %d resident", total, vm.Count())
+	}
+
+	cs := vm.CacheStats()
+	if cs.Evictions != 21 || cs.DiskHits != 2 || cs.HitRate() <= 0 || cs.HitRate() >= 1 {
+		t.Errorf("Stats wrong: %+v rate %f", cs, cs.HitRate())
+	}
+}
@@ -0,0 +1,352 @@
+package twitch
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// WebhookSignatureHeader - HMAC-SHA256 of the body using the target secret
+	WebhookSignatureHeader = "X-Twitch-Signature"
+	// WebhookTypeHeader - Alert type name
+	WebhookTypeHeader = "X-Twitch-Alert-Type"
+	// WebhookIDHeader - Alert journal ID, same across retries so receivers can dedupe
+	WebhookIDHeader = "X-Twitch-Alert-Id"
+
+	defaultWebhookAttempts   = 5
+	defaultWebhookBackoff    = time.Second * 2
+	defaultWebhookMaxBackoff = time.Minute * 2
+	defaultWebhookTimeout    = time.Second * 10
+	webhookRecentDeadLetters = 50
+)
+
+// WebhookTarget - External service that receives alerts as signed JSON posts
+type WebhookTarget struct {
+	Name        string        `json:"name"`
+	URL         string        `json:"url"`
+	Secret      string        `json:"secret"`
+	Types       []AlertType   `json:"types"` // Empty is every alert type except heartbeats
+	MaxAttempts int           `json:"max_attempts"`
+	Backoff     time.Duration `json:"backoff"` // Doubles after every failed attempt
+	MaxBackoff  time.Duration `json:"max_backoff"`
+	Timeout     time.Duration `json:"timeout"`
+}
+
+func (wt WebhookTarget) sanitise() WebhookTarget {
+	if wt.MaxAttempts < 1 {
+		wt.MaxAttempts = defaultWebhookAttempts
+	}
+	if wt.Backoff <= 0 {
+		wt.Backoff = defaultWebhookBackoff
+	}
+	if wt.MaxBackoff <= 0 {
+		wt.MaxBackoff = defaultWebhookMaxBackoff
+	}
+	if wt.Timeout <= 0 {
+		wt.Timeout = defaultWebhookTimeout
+	}
+
+	return wt
+}
+
+// backoffFor - Wait before attempt number n (n starts at 1 for the first retry)
+func (wt WebhookTarget) backoffFor(n int) time.Duration {
+	d := wt.Backoff
+	for i := 1; i < n && d < wt.MaxBackoff; i++ {
+		d *= 2
+	}
+	if d > wt.MaxBackoff {
+		d = wt.MaxBackoff
+	}
+	return d
+}
+
+// WebhookSign - Signature header value for body, receivers compare with hmac.Equal
+func WebhookSign(secret string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+// WebhookVerify - Check a signature header against the body
+func WebhookVerify(secret string, body []byte, signature string) bool {
+	return hmac.Equal([]byte(WebhookSign(secret, body)), []byte(signature))
+}
+
+// WebhookDeadLetter - Alert which could not be delivered to a target
+type WebhookDeadLetter struct {
+	Target   string    `json:"target"`
+	Alert    Alert     `json:"alert"`
+	Attempts int       `json:"attempts"`
+	Error    string    `json:"error"`
+	Time     time.Time `json:"time"`
+}
+
+// WebhookStats - Delivery counters for a target
+type WebhookStats struct {
+	Name      string   `json:"name"`
+	URL       string   `json:"url"`
+	Delivered uint64   `json:"delivered"`
+	Retries   uint64   `json:"retries"`
+	Dead      uint64   `json:"dead"`
+	Sub       SubStats `json:"sub"`
+}
+
+type webhookWorker struct {
+	Target WebhookTarget
+	src    chan Alert
+
+	delivered uint64
+	retries   uint64
+	dead      uint64
+}
+
+// WebhookDispatcher - Posts alerts from the pump to webhook targets
+type WebhookDispatcher struct {
+	pump       *AlertPump
+	httpClient *http.Client
+
+	lock        sync.Mutex
+	workers     []*webhookWorker
+	deadFile    *os.File
+	deadLetters []WebhookDeadLetter
+	wait        sync.WaitGroup
+}
+
+// CreateWebhookDispatcher - Dispatcher fed from the pump, empty filename keeps dead letters in memory only
+func CreateWebhookDispatcher(pump *AlertPump, deadLetterFilename string) *WebhookDispatcher {
+	wd := WebhookDispatcher{
+		pump:        pump,
+		httpClient:  &http.Client{},
+		workers:     []*webhookWorker{},
+		deadLetters: []WebhookDeadLetter{},
+	}
+
+	if deadLetterFilename != "" {
+		f, err := os.OpenFile(deadLetterFilename, os.O_CREATE|os.O_WRONLY|os.O_APPEND, secretFileMode)
+		if err != nil {
+			log.Printf("Webhook dead letter log unavailable: %s", err)
+		} else {
+			// Logs created before the mode was tightened, payloads carry viewer data
+			if err = f.Chmod(secretFileMode); err != nil {
+				log.Printf("Webhook dead letter log %s unable to restrict file mode\n%s", deadLetterFilename, err)
+			}
+			wd.deadFile = f
+		}
+	}
+
+	return &wd
+}
+
+// AddTarget - Start delivering alerts to target
+func (wd *WebhookDispatcher) AddTarget(target WebhookTarget) error {
+	if target.Name == "" || target.URL == "" {
+		return fmt.Errorf("Webhook target needs a name and url")
+	}
+
+	wd.lock.Lock()
+	defer wd.lock.Unlock()
+
+	for _, w := range wd.workers {
+		if w.Target.Name == target.Name {
+			return fmt.Errorf("Webhook target %s already exists", target.Name)
+		}
+	}
+
+	w := &webhookWorker{Target: target.sanitise()}
+	filter := AlertFilter{Types: target.Types}
+	if len(filter.Types) == 0 {
+		filter.Match = func(a Alert) bool { return a.Type != AlertNone }
+	}
+	w.src = wd.pump.SubFiltered("webhook:"+target.Name, filter,
+		SubOptions{BufferSize: 100, Overflow: OverflowDropOldest})
+
+	wd.workers = append(wd.workers, w)
+	wd.wait.Add(1)
+	go wd.runWorker(w)
+
+	return nil
+}
+
+// RemoveTarget - Stop delivering to target, alerts already being retried are finished
+func (wd *WebhookDispatcher) RemoveTarget(name string) error {
+	wd.lock.Lock()
+	defer wd.lock.Unlock()
+
+	for i, w := range wd.workers {
+		if w.Target.Name == name {
+			wd.pump.Unsub(w.src)
+			wd.workers = append(wd.workers[:i], wd.workers[i+1:]...)
+			return nil
+		}
+	}
+
+	return fmt.Errorf("No webhook target %s", name)
+}
+
+// Targets - Delivery stats for every target
+func (wd *WebhookDispatcher) Targets() []WebhookStats {
+	subStats := map[string]SubStats{}
+	for _, ss := range wd.pump.Subscribers() {
+		subStats[ss.Name] = ss
+	}
+
+	wd.lock.Lock()
+	defer wd.lock.Unlock()
+
+	sList := make([]WebhookStats, 0, len(wd.workers))
+	for _, w := range wd.workers {
+		sList = append(sList, WebhookStats{
+			Name:      w.Target.Name,
+			URL:       w.Target.URL,
+			Delivered: atomic.LoadUint64(&w.delivered),
+			Retries:   atomic.LoadUint64(&w.retries),
+			Dead:      atomic.LoadUint64(&w.dead),
+			Sub:       subStats["webhook:"+w.Target.Name],
+		})
+	}
+
+	return sList
+}
+
+// DeadLetters - Most recent undeliverable alerts oldest first
+func (wd *WebhookDispatcher) DeadLetters() []WebhookDeadLetter {
+	wd.lock.Lock()
+	defer wd.lock.Unlock()
+
+	retList := make([]WebhookDeadLetter, len(wd.deadLetters))
+	copy(retList, wd.deadLetters)
+	return retList
+}
+
+// Close - Remove every target, wait for in flight deliveries and close the dead letter log
+func (wd *WebhookDispatcher) Close() {
+	wd.lock.Lock()
+	for _, w := range wd.workers {
+		wd.pump.Unsub(w.src)
+	}
+	wd.workers = []*webhookWorker{}
+	wd.lock.Unlock()
+
+	wd.wait.Wait()
+
+	wd.lock.Lock()
+	if wd.deadFile != nil {
+		wd.deadFile.Close()
+		wd.deadFile = nil
+	}
+	wd.lock.Unlock()
+}
+
+func (wd *WebhookDispatcher) runWorker(w *webhookWorker) {
+	defer wd.wait.Done()
+
+	for a := range w.src {
+		wd.deliver(w, a)
+	}
+}
+
+func (wd *WebhookDispatcher) deliver(w *webhookWorker, a Alert) {
+	body, err := json.Marshal(a)
+	if err != nil {
+		wd.deadLetter(w, a, 0, err)
+		return
+	}
+
+	attempt := 0
+	for {
+		attempt++
+		retry, err := wd.post(w.Target, a, body)
+		if err == nil {
+			atomic.AddUint64(&w.delivered, 1)
+			return
+		}
+
+		if !retry || attempt >= w.Target.MaxAttempts {
+			wd.deadLetter(w, a, attempt, err)
+			return
+		}
+
+		atomic.AddUint64(&w.retries, 1)
+		time.Sleep(w.Target.backoffFor(attempt))
+	}
+}
+
+// post - Single delivery attempt, returns whether a failure is worth retrying
+func (wd *WebhookDispatcher) post(target WebhookTarget, a Alert, body []byte) (bool, error) {
+	req, err := http.NewRequest("POST", target.URL, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(WebhookSignatureHeader, WebhookSign(target.Secret, body))
+	req.Header.Set(WebhookTypeHeader, a.NameString())
+	req.Header.Set(WebhookIDHeader, strconv.FormatUint(uint64(a.ID), 10))
+
+	client := *wd.httpClient
+	client.Timeout = target.Timeout
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	switch {
+	case resp.StatusCode >= 200 && resp.StatusCode < 300:
+		return false, nil
+	case resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= 500:
+		return true, fmt.Errorf("Webhook %s returned %s", target.Name, resp.Status)
+	}
+
+	return false, fmt.Errorf("Webhook %s rejected alert: %s", target.Name, resp.Status)
+}
+
+func (wd *WebhookDispatcher) deadLetter(w *webhookWorker, a Alert, attempts int, err error) {
+	atomic.AddUint64(&w.dead, 1)
+	log.Printf("Webhook %s gave up on %s after %d attempts\n%s", w.Target.Name, a, attempts, err)
+
+	dl := WebhookDeadLetter{
+		Target:   w.Target.Name,
+		Alert:    a,
+		Attempts: attempts,
+		Error:    err.Error(),
+		Time:     time.Now(),
+	}
+
+	wd.lock.Lock()
+	defer wd.lock.Unlock()
+
+	wd.deadLetters = append(wd.deadLetters, dl)
+	if len(wd.deadLetters) > webhookRecentDeadLetters {
+		wd.deadLetters = wd.deadLetters[1:]
+	}
+
+	if wd.deadFile == nil {
+		return
+	}
+
+	b, mErr := json.Marshal(dl)
+	if mErr != nil {
+		log.Printf("Webhook dead letter marshal failed: %s", mErr)
+		return
+	}
+	if _, wErr := wd.deadFile.Write(append(b, '\n')); wErr != nil {
+		log.Printf("Webhook dead letter write failed: %s", wErr)
+	}
+}
@@ -0,0 +1,91 @@
+package twitch
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestWebhookDelivery(t *testing.T) {
+	var calls int32
+	got := make(chan Alert, 1)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := ioutil.ReadAll(req.Body)
+		if !WebhookVerify("hunter2", body, req.Header.Get(WebhookSignatureHeader)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+
+		// Fail first attempt to exercise retry
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+
+		a := Alert{}
+		if err := a.UnmarshalJSON(body); err != nil {
+			t.Error(err)
+		}
+		got <- a
+	}))
+	defer srv.Close()
+
+	pump := StartAlertPump(nil)
+	wd := CreateWebhookDispatcher(pump, "")
+
+	err := wd.AddTarget(WebhookTarget{Name: "good", URL: srv.URL, Secret: "hunter2",
+		Types: []AlertType{AlertHost}, Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = wd.AddTarget(WebhookTarget{Name: "badsecret", URL: srv.URL, Secret: "wrong",
+		MaxAttempts: 3, Backoff: time.Millisecond})
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForSubs(pump, 2)
+
+	pump.postInternal(Alert{Type: AlertHost, Source: "obezianka", Data: 5})
+
+	select {
+	case a := <-got:
+		if a.Source != "obezianka" || a.HostViewers() != 5 {
+			t.Errorf("Webhook received wrong alert %s", a)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatal("Webhook never delivered")
+	}
+
+	wd.Close()
+
+	dead := wd.DeadLetters()
+	if len(dead) != 1 || dead[0].Target != "badsecret" || dead[0].Attempts != 1 {
+		t.Errorf("Rejected delivery should dead letter without retry: %v", dead)
+	}
+
+	for _, ws := range wd.Targets() {
+		t.Errorf("Target %s still registered after close", ws.Name)
+	}
+}
+
+func TestWebhookDeadLetterFile(t *testing.T) {
+	filename := filepath.Join(t.TempDir(), "dead.log")
+
+	// Logs left by older builds are world readable
+	if err := ioutil.WriteFile(filename, []byte{}, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	wd := CreateWebhookDispatcher(StartAlertPump(nil), filename)
+	wd.Close()
+
+	if fi, err := os.Stat(filename); err != nil || fi.Mode().Perm() != secretFileMode {
+		t.Errorf("Dead letter log should be private: %v", fi)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"net/http"
+	"net/url"
 	"strings"
 
 	"github.com/gorilla/websocket"
@@ -21,6 +22,7 @@ type WebsocketHandler func(*WebsocketConn)
 // WebsocketConn - Useful Wrapper for a single Connection. Note each connection will be on it's own go routine
 type WebsocketConn struct {
 	CmdChan chan string
+	Query   url.Values // Query string of the upgrade request, empty when dialing
 	ws      *websocket.Conn
 }
 
@@ -39,7 +41,7 @@ func CreateWebsocketHelper(newHandler WebsocketHandler) *WebsocketHelper {
 // ServeHTTP - Server Approach
 func (wh *WebsocketHelper) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	var err error
-	wc := WebsocketConn{}
+	wc := WebsocketConn{Query: req.URL.Query()}
 
 	// Setup Socket
 	wc.ws, err = upgrader.Upgrade(w, req, nil)